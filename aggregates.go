@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+
+	errors "github.com/AustinMCrane/errorutil"
+)
+
+// nearMintCondition is the condition of the headline price of a card
+const nearMintCondition = "Near Mint"
+
+// latestPrice is the latest price of a sku with the product, group,
+// rarity and condition it is aggregated by, ids are tcgplayer ids
+type latestPrice struct {
+	SKUID      int `gorm:"column:sku_id"`
+	ProductID  int
+	GroupID    int
+	Rarity     string
+	Condition  string
+	Price      cents
+	Currency   string
+	IngestedAt time.Time
+}
+
+// productPrice is the headline price of a product from the latest price of
+// each of its skus in dollars, skus without listings are left out. The
+// float columns are what the cents columns held before they were added,
+// they are kept for the readers of the table
+type productPrice struct {
+	ProductID int `gorm:"primaryKey;autoIncrement:false"`
+	GroupID   int `gorm:"index"`
+	// CheapestNearMint is nil when no near mint sku is listed
+	CheapestNearMint      *float32
+	Cheapest              float32
+	Spread                float32
+	CheapestNearMintCents *cents
+	CheapestCents         cents
+	// SpreadCents is how much more the cheapest copy of the dearest
+	// condition costs than the cheapest copy of the cheapest one
+	SpreadCents cents
+	SKUs        int `gorm:"column:skus"`
+	ComputedAt  time.Time
+}
+
+func (productPrice) TableName() string {
+	return "ingest_product_prices"
+}
+
+// groupValue is what a complete set of a group costs at a point in time in
+// dollars, the sum of the cheapest copy of each of its listed cards, and
+// the set index of the group. The points computed before the cents columns
+// were added only have the float ones
+type groupValue struct {
+	GroupID    int       `gorm:"primaryKey;autoIncrement:false"`
+	ComputedAt time.Time `gorm:"primaryKey"`
+	Value      float64
+	Index      float64 `gorm:"column:set_index"`
+	ValueCents cents
+	// IndexCents is the value of a pack when weighted by pull rates, the
+	// value of the set otherwise
+	IndexCents cents `gorm:"column:set_index_cents"`
+	Weighted   bool
+	Cards      int
+}
+
+func (groupValue) TableName() string {
+	return "ingest_group_values"
+}
+
+// aggregatePrices summarizes the latest prices by product, and the products
+// by group, the set indexes are weighted by rates unless they are nil
+func aggregatePrices(prices []latestPrice, rates pullRates, now time.Time) ([]*productPrice, []*groupValue) {
+	byProduct := map[int][]latestPrice{}
+	for _, p := range prices {
+		if p.Price <= 0 {
+			continue
+		}
+		byProduct[p.ProductID] = append(byProduct[p.ProductID], p)
+	}
+
+	products := []*productPrice{}
+	byGroup := map[int]*groupValue{}
+	indexes := map[int]*setIndex{}
+	for id, skus := range byProduct {
+		product := &productPrice{ProductID: id, GroupID: skus[0].GroupID, CheapestCents: skus[0].Price,
+			SKUs: len(skus), ComputedAt: now}
+
+		// the cheapest copy of each condition
+		conditions := map[string]cents{}
+		for _, s := range skus {
+			product.CheapestCents = min(product.CheapestCents, s.Price)
+			if cheapest, ok := conditions[s.Condition]; !ok || s.Price < cheapest {
+				conditions[s.Condition] = s.Price
+			}
+		}
+
+		if nearMint, ok := conditions[nearMintCondition]; ok {
+			product.CheapestNearMintCents = &nearMint
+			dollars := float32(nearMint.dollars())
+			product.CheapestNearMint = &dollars
+		}
+
+		dearest := product.CheapestCents
+		for _, price := range conditions {
+			dearest = max(dearest, price)
+		}
+		product.SpreadCents = dearest - product.CheapestCents
+		product.Cheapest = float32(product.CheapestCents.dollars())
+		product.Spread = float32(product.SpreadCents.dollars())
+		products = append(products, product)
+
+		group, ok := byGroup[product.GroupID]
+		if !ok {
+			group = &groupValue{GroupID: product.GroupID, ComputedAt: now}
+			byGroup[product.GroupID] = group
+		}
+		group.ValueCents += product.CheapestCents
+		group.Cards++
+
+		index, ok := indexes[product.GroupID]
+		if !ok {
+			index = newSetIndex()
+			indexes[product.GroupID] = index
+		}
+		index.add(skus[0].Rarity, product.CheapestCents)
+	}
+
+	groups := []*groupValue{}
+	for _, g := range byGroup {
+		g.IndexCents, g.Weighted = g.ValueCents, rates != nil
+		if rates != nil {
+			g.IndexCents = indexes[g.GroupID].weighted(rates)
+		}
+		g.Value, g.Index = g.ValueCents.dollars(), g.IndexCents.dollars()
+		groups = append(groups, g)
+	}
+
+	sort.Slice(products, func(i, j int) bool { return products[i].ProductID < products[j].ProductID })
+	sort.Slice(groups, func(i, j int) bool { return groups[i].GroupID < groups[j].GroupID })
+
+	return products, groups
+}
+
+// updatePriceAggregates recomputes the product prices and adds a point to
+// the value and index of every group, it runs after each price run
+func updatePriceAggregates(ctx context.Context, s Store, rates pullRates, now time.Time) error {
+	prices, err := s.LatestPrices(ctx)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	products, groups := aggregatePrices(prices, rates, now)
+	err = s.WriteAggregates(ctx, products, groups)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	slog.Info("aggregated prices", "products", len(products), "groups", len(groups))
+	return nil
+}
+
+// latestPrices returns the latest price of every sku in dollars
+func latestPrices(ctx context.Context, dbConn *gorm.DB) ([]latestPrice, error) {
+	conv, err := newConverter(ctx, dbConn, priceCurrency, time.Now())
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	latest := dbConn.Table("sku_prices").Select("sku_id, max(ingested_at) AS ingested_at").Group("sku_id")
+
+	prices := []latestPrice{}
+	err = dbConn.WithContext(ctx).Table("sku_prices").
+		Select("sku_prices.sku_id, products.tcgplayer_id AS product_id, groups.tcgplayer_id AS group_id, "+
+			"rarities.name AS rarity, conditions.name AS condition, sku_prices.ingested_at, "+centsColumns).
+		Joins("JOIN (?) latest ON latest.sku_id = sku_prices.sku_id AND latest.ingested_at = sku_prices.ingested_at",
+			latest).
+		Joins("JOIN skus ON skus.tcgplayer_id = sku_prices.sku_id").
+		Joins("JOIN products ON products.id = skus.product_id").
+		Joins("JOIN groups ON groups.id = products.group_id").
+		Joins("JOIN rarities ON rarities.id = products.rarity_id").
+		Joins("JOIN conditions ON conditions.id = skus.condition_id").
+		Scan(&prices).Error
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	for i, p := range prices {
+		prices[i].Price, err = conv.convert(p.Price, p.Currency, p.IngestedAt)
+		if err != nil {
+			return nil, errors.Wrap(err)
+		}
+		prices[i].Currency = conv.currency
+	}
+
+	return prices, nil
+}
+
+// writeAggregates replaces the product prices and adds the group values in
+// a single transaction
+func writeAggregates(ctx context.Context, dbConn *gorm.DB, products []*productPrice,
+	groups []*groupValue) error {
+	return dbConn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// products without listings anymore lose their headline price
+		err := tx.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&productPrice{}).Error
+		if err != nil {
+			return errors.Wrap(err)
+		}
+
+		if len(products) > 0 {
+			err = tx.CreateInBatches(products, 1000).Error
+			if err != nil {
+				return errors.Wrap(err)
+			}
+		}
+
+		if len(groups) > 0 {
+			err = tx.Create(groups).Error
+			if err != nil {
+				return errors.Wrap(err)
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AustinMCrane/tcgplayer"
+	gomock "github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func float32Ptr(f float32) *float32 {
+	return &f
+}
+
+func centsPtr(c cents) *cents {
+	return &c
+}
+
+func TestAggregatePrices(t *testing.T) {
+	now := time.Now()
+
+	products, groups := aggregatePrices([]latestPrice{
+		{SKUID: 10, ProductID: 1, GroupID: 1, Condition: nearMintCondition, Price: 1200},
+		{SKUID: 11, ProductID: 1, GroupID: 1, Condition: nearMintCondition, Price: 1000},
+		{SKUID: 12, ProductID: 1, GroupID: 1, Condition: "Lightly Played", Price: 800},
+		{SKUID: 13, ProductID: 1, GroupID: 1, Condition: "Damaged", Price: 300},
+		{SKUID: 20, ProductID: 2, GroupID: 1, Condition: "Damaged", Price: 150},
+		// without listings
+		{SKUID: 21, ProductID: 2, GroupID: 1, Condition: nearMintCondition, Price: 0},
+		{SKUID: 30, ProductID: 3, GroupID: 2, Condition: nearMintCondition, Price: 0},
+	}, nil, now)
+
+	require.Equal(t, []*productPrice{
+		{ProductID: 1, GroupID: 1, CheapestNearMint: float32Ptr(10), Cheapest: 3, Spread: 7,
+			CheapestNearMintCents: centsPtr(1000), CheapestCents: 300, SpreadCents: 700, SKUs: 4, ComputedAt: now},
+		{ProductID: 2, GroupID: 1, Cheapest: 1.5, CheapestCents: 150, SKUs: 1, ComputedAt: now},
+	}, products)
+	require.Equal(t, []*groupValue{
+		{GroupID: 1, ComputedAt: now, Value: 4.5, Index: 4.5, ValueCents: 450, IndexCents: 450, Cards: 2},
+	}, groups)
+}
+
+func TestSQLiteUpdatePriceAggregates(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+
+	dbConn, err := getDBConnection(dbDriverSQLite, "", "", "", "", filepath.Join(t.TempDir(), "dev.db"))
+	require.NoError(t, err)
+	require.NoError(t, migrate(ctx, dbConn))
+	s := newSQLStore(dbConn)
+	writeRetentionFixture(t, s, now)
+
+	// a lightly played copy of the secret rare
+	require.NoError(t, s.UpsertConditions(ctx, []*tcgplayer.Condition{{ID: 2, Name: "Lightly Played"}}))
+	_, err = s.UpsertProducts(ctx, nil, []*tcgplayer.Product{{
+		ID: 1, GroupID: 1, CategoryID: tcgplayer.CategoryYugioh, CleanName: "test",
+		ExtendedData: []tcgplayer.ExtendedData{{Name: "Rarity", Value: "Secret Rare"}},
+		SKUS: []tcgplayer.SKU{
+			{SKUID: 10, ProductID: 1, PrintingID: 1, ConditionID: 1, LanguageID: englishLanguageID},
+			{SKUID: 11, ProductID: 1, PrintingID: 1, ConditionID: 2, LanguageID: englishLanguageID},
+		},
+	}})
+	require.NoError(t, err)
+
+	// only the latest price of each sku counts
+	require.NoError(t, s.InsertPrices(ctx, []skuPrice{
+		{SKUID: 10, PriceCents: 5000, IngestedAt: now.Add(-time.Hour * 2)},
+		{SKUID: 10, PriceCents: 4000, IngestedAt: now.Add(-time.Hour)},
+		{SKUID: 11, PriceCents: 2500, IngestedAt: now.Add(-time.Hour)},
+		{SKUID: 30, PriceCents: 200, IngestedAt: now.Add(-time.Hour)},
+	}))
+
+	require.NoError(t, updatePriceAggregates(ctx, s, nil, now))
+
+	products := []*productPrice{}
+	require.NoError(t, dbConn.Order("product_id").Find(&products).Error)
+	require.Len(t, products, 2)
+	require.Equal(t, cents(4000), *products[0].CheapestNearMintCents)
+	require.Equal(t, cents(2500), products[0].CheapestCents)
+	require.Equal(t, cents(1500), products[0].SpreadCents)
+	require.Equal(t, float32(25), products[0].Cheapest)
+	require.Equal(t, 2, products[0].SKUs)
+	require.Equal(t, 3, products[1].ProductID)
+	require.Equal(t, 2, products[1].GroupID)
+
+	// every run adds a point to the group values and replaces the products
+	require.NoError(t, s.InsertPrices(ctx, []skuPrice{{SKUID: 11, PriceCents: 2000, IngestedAt: now}}))
+	require.NoError(t, updatePriceAggregates(ctx, s, nil, now.Add(time.Hour)))
+
+	values := []*groupValue{}
+	require.NoError(t, dbConn.Where("group_id = ?", 1).Order("computed_at").Find(&values).Error)
+	require.Len(t, values, 2)
+	require.Equal(t, cents(2500), values[0].ValueCents)
+	require.Equal(t, cents(2000), values[1].ValueCents)
+	require.Equal(t, 20.0, values[1].Value)
+
+	var count int64
+	require.NoError(t, dbConn.Model(&productPrice{}).Count(&count).Error)
+	require.Equal(t, int64(2), count)
+}
+
+func TestMemoryStore_UpdatePriceAggregates(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	s := newMemoryStore()
+	writeRetentionFixture(t, s, now)
+	require.NoError(t, s.InsertPrices(ctx, []skuPrice{
+		{SKUID: 20, PriceCents: 300, IngestedAt: now.Add(-time.Hour)},
+		{SKUID: 20, PriceCents: 100, IngestedAt: now},
+		{SKUID: 30, PriceCents: 200, IngestedAt: now},
+	}))
+
+	require.NoError(t, updatePriceAggregates(ctx, s, nil, now))
+	require.Len(t, s.productPrices, 2)
+	require.Equal(t, cents(100), *s.productPrices[0].CheapestNearMintCents)
+	require.Equal(t, []*groupValue{
+		{GroupID: 1, ComputedAt: now, Value: 1, Index: 1, ValueCents: 100, IndexCents: 100, Cards: 1},
+		{GroupID: 2, ComputedAt: now, Value: 2, Index: 2, ValueCents: 200, IndexCents: 200, Cards: 1},
+	}, s.groupValues)
+}
+
+func TestIngestPrices_WatchlistSkipsAggregates(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	client := NewMockTcgplayer(ctrl)
+	now := time.Now()
+	s := newMemoryStore()
+	writeRetentionFixture(t, s, now)
+	require.NoError(t, s.InsertPrices(ctx, []skuPrice{{SKUID: 20, PriceCents: 100, IngestedAt: now}}))
+
+	// nothing is watched so the run fetches no prices
+	err := ingestPrices(ctx, priceOptions{watchlist: true, flush: testFlushPolicy})(s, nil, client)
+	require.NoError(t, err)
+	require.Empty(t, s.productPrices)
+	require.Empty(t, s.groupValues)
+}
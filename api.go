@@ -0,0 +1,441 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	errors "github.com/AustinMCrane/errorutil"
+	"github.com/AustinMCrane/tcg-market-watch-api/pkg/store"
+)
+
+const (
+	commandServe = "serve"
+
+	apiDefaultLimit = 100
+	apiMaxLimit     = 1000
+
+	// apiDefaultSince is how far back prices are listed without ?since=
+	apiDefaultSince = time.Hour * 24 * 30
+)
+
+// apiPage is a page of a list, NextOffset is the offset of the next page
+// and is left out on the last one
+type apiPage struct {
+	Items      interface{} `json:"items"`
+	Limit      int         `json:"limit"`
+	Offset     int         `json:"offset"`
+	NextOffset *int        `json:"next_offset,omitempty"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+type apiPrice struct {
+	IngestedAt time.Time `json:"ingested_at"`
+	Price      cents     `json:"price"`
+	Shipping   cents     `json:"shipping"`
+	Currency   string    `json:"currency"`
+}
+
+type apiGroup struct {
+	ID          int    `json:"id" gorm:"column:tcgplayer_id"`
+	Name        string `json:"name"`
+	PublishedOn string `json:"published_on,omitempty"`
+}
+
+type apiSKU struct {
+	ID        int    `json:"id" gorm:"column:sku_id"`
+	Condition string `json:"condition"`
+	Printing  string `json:"printing"`
+	Language  string `json:"language"`
+}
+
+type apiProduct struct {
+	ID       int       `json:"id" gorm:"column:product_id"`
+	Name     string    `json:"name"`
+	GroupID  int       `json:"group_id"`
+	Group    string    `json:"group"`
+	Rarity   string    `json:"rarity"`
+	ImageURL string    `json:"image_url,omitempty"`
+	URL      string    `json:"url,omitempty" gorm:"column:tcgplayer_url"`
+	SKUs     []*apiSKU `json:"skus,omitempty" gorm:"-"`
+}
+
+// api serves the catalog and prices read only, ids in paths and responses
+// are tcgplayer ids
+type api struct {
+	db *gorm.DB
+}
+
+// newAPIHandler returns the handler of the read only api
+//
+//	GET /skus/{id}/prices?since=   prices of a sku, since an RFC3339 time or a date
+//	GET /products/{id}             a product with its skus
+//	GET /groups                    every group
+//	GET /search?q=                 products whose name contains q
+//
+// lists take ?limit= and ?offset=, every response has an ETag and a request
+// whose If-None-Match matches it gets a 304
+func newAPIHandler(dbConn *gorm.DB) *http.ServeMux {
+	a := &api{db: dbConn}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/skus/", a.get(a.skuPrices))
+	mux.HandleFunc("/products/", a.get(a.product))
+	mux.HandleFunc("/groups", a.get(a.groups))
+	mux.HandleFunc("/search", a.get(a.search))
+
+	return mux
+}
+
+// ExecServe is the entry point of the serve command, it serves the api
+// until ctx is done
+func ExecServe(ctx context.Context, args []string) error {
+	fs := newCommandFlagSet(commandServe)
+	addr := fs.String("addr", ":8080", "address the api listens on")
+	err := fs.Parse(args)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	dbConn, err := openDB(ctx, commandServe)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+	defer closeDB(dbConn)
+
+	return serve(ctx, *addr, newAPIHandler(dbConn))
+}
+
+// serve listens on addr until ctx is done, requests in flight are given a
+// few seconds to finish
+func serve(ctx context.Context, addr string, handler http.Handler) error {
+	server := &http.Server{Addr: addr, Handler: handler, ReadHeaderTimeout: time.Second * 10}
+
+	errs := make(chan error, 1)
+	go func() {
+		slog.Info("serving api", "addr", addr)
+		errs <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return errors.Wrap(err)
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Second*5)
+	defer cancel()
+
+	err := server.Shutdown(shutdownCtx)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
+
+// apiHandler returns what a request is answered with, or an error carrying
+// its status
+type apiHandler func(r *http.Request) (interface{}, error)
+
+// apiStatusError is an error answered with a status other than 500
+type apiStatusError struct {
+	status  int
+	message string
+}
+
+func (e apiStatusError) Error() string {
+	return e.message
+}
+
+func notFound(format string, args ...interface{}) error {
+	return apiStatusError{status: http.StatusNotFound, message: fmt.Sprintf(format, args...)}
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return apiStatusError{status: http.StatusBadRequest, message: fmt.Sprintf(format, args...)}
+}
+
+// get answers GET and HEAD requests with the json of h and its ETag
+func (a *api) get(h apiHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			writeJSON(w, http.StatusMethodNotAllowed, apiError{Error: "method not allowed"})
+			return
+		}
+
+		body, err := h(r)
+		if err != nil {
+			// status errors are returned as they are, never wrapped
+			if statusErr, ok := err.(apiStatusError); ok {
+				writeJSON(w, statusErr.status, apiError{Error: statusErr.message})
+				return
+			}
+
+			slog.Error("api request failed", "path", r.URL.Path, "error", err)
+			writeJSON(w, http.StatusInternalServerError, apiError{Error: "internal error"})
+			return
+		}
+
+		b, err := json.Marshal(body)
+		if err != nil {
+			slog.Error("unable to encode api response", "path", r.URL.Path, "error", err)
+			writeJSON(w, http.StatusInternalServerError, apiError{Error: "internal error"})
+			return
+		}
+
+		hash := fnv.New64a()
+		hash.Write(b)
+		etag := fmt.Sprintf("\"%x\"", hash.Sum64())
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			w.Write(append(b, '\n'))
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	b := bytes.Buffer{}
+	json.NewEncoder(&b).Encode(body)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(b.Bytes())
+}
+
+// pageParams parses ?limit= and ?offset=
+func pageParams(r *http.Request) (int, int, error) {
+	limit, offset := apiDefaultLimit, 0
+
+	var err error
+	if v := r.URL.Query().Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > apiMaxLimit {
+			return 0, 0, badRequest("limit must be between 1 and %d", apiMaxLimit)
+		}
+	}
+
+	if v := r.URL.Query().Get("offset"); v != "" {
+		offset, err = strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			return 0, 0, badRequest("offset must be a positive number")
+		}
+	}
+
+	return limit, offset, nil
+}
+
+// paginate runs q for the page the request asks for
+func paginate[T any](r *http.Request, q *gorm.DB) (*apiPage, error) {
+	limit, offset, err := pageParams(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// one more row than asked for tells if there is a next page
+	items := []T{}
+	err = q.Limit(limit + 1).Offset(offset).Find(&items).Error
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	page := &apiPage{Limit: limit, Offset: offset}
+	if len(items) > limit {
+		next := offset + limit
+		page.NextOffset = &next
+		items = items[:limit]
+	}
+	page.Items = items
+
+	return page, nil
+}
+
+// pathID parses the id following prefix in the path, and returns what
+// follows it
+func pathID(path string, prefix string) (int, string, error) {
+	rest := strings.TrimPrefix(path, prefix)
+	idPart, suffix, _ := strings.Cut(rest, "/")
+
+	id, err := strconv.Atoi(idPart)
+	if err != nil {
+		return 0, "", notFound("not found")
+	}
+
+	return id, suffix, nil
+}
+
+// parseSince parses ?since= as an RFC3339 time or a date
+func parseSince(v string) (time.Time, error) {
+	if v == "" {
+		return time.Now().Add(-apiDefaultSince), nil
+	}
+
+	t, err := time.Parse(time.RFC3339, v)
+	if err == nil {
+		return t, nil
+	}
+
+	t, err = time.Parse(dateLayout, v)
+	if err == nil {
+		return t, nil
+	}
+
+	return time.Time{}, badRequest("since must be an RFC3339 time or a YYYY-MM-DD date")
+}
+
+func (a *api) skuPrices(r *http.Request) (interface{}, error) {
+	id, suffix, err := pathID(r.URL.Path, "/skus/")
+	if err != nil {
+		return nil, err
+	}
+	if suffix != "prices" {
+		return nil, notFound("not found")
+	}
+
+	since, err := parseSince(r.URL.Query().Get("since"))
+	if err != nil {
+		return nil, err
+	}
+
+	var count int64
+	err = a.db.WithContext(r.Context()).Model(&store.SKU{}).Where("tcgplayer_id = ?", id).Count(&count).Error
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+	if count == 0 {
+		return nil, notFound("sku %d not found", id)
+	}
+
+	conv, err := a.converter(r)
+	if err != nil {
+		return nil, err
+	}
+
+	q := a.db.WithContext(r.Context()).Table("sku_prices").
+		Select("sku_prices.ingested_at, "+centsColumns).
+		Where("sku_prices.sku_id = ? AND sku_prices.ingested_at >= ?", id, since).
+		Order("sku_prices.ingested_at, sku_prices.id")
+	page, err := paginate[*apiPrice](r, q)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range page.Items.([]*apiPrice) {
+		p.Price, err = conv.convert(p.Price, p.Currency, p.IngestedAt)
+		if err != nil {
+			return nil, errors.Wrap(err)
+		}
+
+		p.Shipping, err = conv.convert(p.Shipping, p.Currency, p.IngestedAt)
+		if err != nil {
+			return nil, errors.Wrap(err)
+		}
+		p.Currency = conv.currency
+	}
+
+	return page, nil
+}
+
+// converter returns the converter of ?currency=, prices are left in dollars
+// when it is empty
+func (a *api) converter(r *http.Request) (*converter, error) {
+	currency := r.URL.Query().Get("currency")
+	if currency == "" {
+		currency = priceCurrency
+	}
+
+	_, err := parseCurrency(currency)
+	if err != nil {
+		return nil, badRequest("currency must be an ISO 4217 code")
+	}
+
+	conv, err := newConverter(r.Context(), a.db, currency, time.Now())
+	if err != nil {
+		return nil, badRequest("%s", errorMessage(err))
+	}
+
+	return conv, nil
+}
+
+// productsQuery selects products with the names of their group and rarity
+func (a *api) productsQuery(ctx context.Context) *gorm.DB {
+	return a.db.WithContext(ctx).Table("products").
+		Select("products.tcgplayer_id AS product_id, details.name, groups.tcgplayer_id AS group_id, " +
+			"groups.name AS \"group\", rarities.name AS rarity, products.image_url, products.tcgplayer_url").
+		Joins("JOIN details ON details.id = products.detail_id").
+		Joins("JOIN groups ON groups.id = products.group_id").
+		Joins("LEFT JOIN rarities ON rarities.id = products.rarity_id")
+}
+
+func (a *api) product(r *http.Request) (interface{}, error) {
+	id, suffix, err := pathID(r.URL.Path, "/products/")
+	if err != nil {
+		return nil, err
+	}
+	if suffix != "" {
+		return nil, notFound("not found")
+	}
+
+	products := []*apiProduct{}
+	err = a.productsQuery(r.Context()).Where("products.tcgplayer_id = ?", id).Limit(1).Find(&products).Error
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+	if len(products) == 0 {
+		return nil, notFound("product %d not found", id)
+	}
+
+	p := products[0]
+	err = a.db.WithContext(r.Context()).Table("skus").
+		Select("skus.tcgplayer_id AS sku_id, conditions.name AS condition, printings.name AS printing, "+
+			"languages.name AS language").
+		Joins("JOIN products ON products.id = skus.product_id").
+		Joins("LEFT JOIN conditions ON conditions.id = skus.condition_id").
+		Joins("LEFT JOIN printings ON printings.id = skus.printing_id").
+		Joins("LEFT JOIN languages ON languages.id = skus.language_id").
+		Where("products.tcgplayer_id = ?", id).Order("skus.tcgplayer_id").Find(&p.SKUs).Error
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	return p, nil
+}
+
+func (a *api) groups(r *http.Request) (interface{}, error) {
+	q := a.db.WithContext(r.Context()).Table("groups").
+		Select("groups.tcgplayer_id, groups.name, ingest_group_syncs.published_on").
+		Joins("LEFT JOIN ingest_group_syncs ON ingest_group_syncs.tcgplayer_id = groups.tcgplayer_id").
+		Order("groups.tcgplayer_id")
+	return paginate[*apiGroup](r, q)
+}
+
+func (a *api) search(r *http.Request) (interface{}, error) {
+	query := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("q")))
+	if query == "" {
+		return nil, badRequest("q is required")
+	}
+
+	q := a.productsQuery(r.Context()).Where("lower(details.name) LIKE ? ESCAPE '!'", containsPattern(query)).
+		Order("details.name, products.tcgplayer_id")
+	return paginate[*apiProduct](r, q)
+}
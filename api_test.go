@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestAPI(t *testing.T) *httptest.Server {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+
+	dbConn, err := getDBConnection(dbDriverSQLite, "", "", "", "", filepath.Join(t.TempDir(), "dev.db"))
+	require.NoError(t, err)
+	require.NoError(t, migrate(ctx, dbConn))
+	s := newSQLStore(dbConn)
+	writeRetentionFixture(t, s, now)
+	require.NoError(t, s.InsertPrices(ctx, []skuPrice{
+		{SKUID: 10, PriceCents: 3000, IngestedAt: now.Add(-time.Hour * 2)},
+		{SKUID: 10, PriceCents: 2000, IngestedAt: now.Add(-time.Hour)},
+	}))
+
+	server := httptest.NewServer(newAPIHandler(dbConn))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+// getJSON gets the path and decodes the response into v, it returns the
+// response with its body already read
+func getJSON(t *testing.T, server *httptest.Server, path string, v interface{}) *http.Response {
+	resp, err := http.Get(server.URL + path)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	if v != nil {
+		require.NoError(t, json.Unmarshal(b, v), string(b))
+	}
+
+	return resp
+}
+
+func TestAPI_SKUPrices(t *testing.T) {
+	server := newTestAPI(t)
+
+	page := struct {
+		Items      []*apiPrice `json:"items"`
+		NextOffset *int        `json:"next_offset"`
+	}{}
+	since := time.Now().Add(-time.Hour * 3).UTC().Format(time.RFC3339)
+	resp := getJSON(t, server, "/skus/10/prices?limit=1&since="+since, &page)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Len(t, page.Items, 1)
+	require.Equal(t, cents(3000), page.Items[0].Price)
+	require.Equal(t, priceCurrency, page.Items[0].Currency)
+	require.Equal(t, 1, *page.NextOffset)
+
+	page.NextOffset = nil
+	getJSON(t, server, "/skus/10/prices?limit=1&offset=1&since="+since, &page)
+	require.Equal(t, cents(2000), page.Items[0].Price)
+	require.Nil(t, page.NextOffset)
+
+	// the fixture prices ingested 10 days ago or more are in a wider window
+	getJSON(t, server, "/skus/10/prices?since=2000-01-01", &page)
+	require.Len(t, page.Items, 5)
+
+	require.Equal(t, http.StatusNotFound, getJSON(t, server, "/skus/99/prices", nil).StatusCode)
+	require.Equal(t, http.StatusNotFound, getJSON(t, server, "/skus/10/history", nil).StatusCode)
+	require.Equal(t, http.StatusBadRequest, getJSON(t, server, "/skus/10/prices?since=yesterday", nil).StatusCode)
+	require.Equal(t, http.StatusBadRequest, getJSON(t, server, "/skus/10/prices?limit=0", nil).StatusCode)
+	require.Equal(t, http.StatusBadRequest, getJSON(t, server, "/skus/10/prices?currency=euro", nil).StatusCode)
+	// there are no exchange rates to convert to
+	require.Equal(t, http.StatusBadRequest, getJSON(t, server, "/skus/10/prices?currency=EUR", nil).StatusCode)
+}
+
+func TestAPI_Product(t *testing.T) {
+	server := newTestAPI(t)
+
+	product := apiProduct{}
+	resp := getJSON(t, server, "/products/3", &product)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, 3, product.ID)
+	require.Equal(t, "test", product.Name)
+	require.Equal(t, 2, product.GroupID)
+	require.Equal(t, "test-2", product.Group)
+	require.Equal(t, []*apiSKU{{ID: 30, Condition: "Near Mint", Printing: "1st Edition", Language: "English"}},
+		product.SKUs)
+
+	require.Equal(t, http.StatusNotFound, getJSON(t, server, "/products/99", nil).StatusCode)
+	require.Equal(t, http.StatusNotFound, getJSON(t, server, "/products/test", nil).StatusCode)
+}
+
+func TestAPI_GroupsAndSearch(t *testing.T) {
+	server := newTestAPI(t)
+
+	groups := struct {
+		Items []*apiGroup `json:"items"`
+	}{}
+	getJSON(t, server, "/groups", &groups)
+	require.Equal(t, []*apiGroup{{ID: 1, Name: "test-1"}, {ID: 2, Name: "test-2"}}, groups.Items)
+
+	products := struct {
+		Items []*apiProduct `json:"items"`
+	}{}
+	getJSON(t, server, "/search?q=ES", &products)
+	require.Len(t, products.Items, 3)
+	require.Equal(t, "Secret Rare", products.Items[0].Rarity)
+
+	getJSON(t, server, "/search?q=magician", &products)
+	require.Empty(t, products.Items)
+
+	// wildcards match themselves
+	getJSON(t, server, "/search?q=_", &products)
+	require.Empty(t, products.Items)
+	require.Equal(t, http.StatusBadRequest, getJSON(t, server, "/search", nil).StatusCode)
+}
+
+func TestAPI_ETag(t *testing.T) {
+	server := newTestAPI(t)
+
+	resp := getJSON(t, server, "/groups", nil)
+	etag := resp.Header.Get("ETag")
+	require.NotEmpty(t, etag)
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/groups", nil)
+	require.NoError(t, err)
+	req.Header.Set("If-None-Match", etag)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNotModified, resp.StatusCode)
+
+	// another page is another etag
+	require.NotEqual(t, etag, getJSON(t, server, "/groups?limit=1", nil).Header.Get("ETag"))
+
+	resp, err = http.Post(server.URL+"/groups", "application/json", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
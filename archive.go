@@ -0,0 +1,622 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"gocloud.dev/blob"
+
+	errors "github.com/AustinMCrane/errorutil"
+	"github.com/AustinMCrane/tcgplayer"
+)
+
+const (
+	commandReprocess = "reprocess"
+
+	endpointCategories = "categories"
+	endpointGroups     = "groups"
+	endpointRarities   = "rarities"
+	endpointPrintings  = "printings"
+	endpointConditions = "conditions"
+	endpointLanguages  = "languages"
+	endpointProducts   = "products"
+	endpointSKUs       = "skus"
+	endpointPrices     = "prices"
+)
+
+// archiveRecord is a single api response as stored in the archive, the
+// tcgplayer client only hands back decoded responses so the response is
+// the decoded value encoded as json again
+type archiveRecord struct {
+	Endpoint   string          `json:"endpoint"`
+	Page       int             `json:"page"`
+	Params     json.RawMessage `json:"params"`
+	Response   json.RawMessage `json:"response"`
+	CapturedAt time.Time       `json:"captured_at"`
+}
+
+// archiveKey is where a response is stored, keyed by run, endpoint and page
+func archiveKey(runID string, endpoint string, page int) string {
+	return fmt.Sprintf("%s/%s/%06d.json.gz", runID, endpoint, page)
+}
+
+// archivingClient is a Tcgplayer that writes every successful response of
+// the wrapped client to a bucket, archiving is best effort
+type archivingClient struct {
+	next   Tcgplayer
+	bucket *blob.Bucket
+	runID  string
+
+	mu    sync.Mutex
+	pages map[string]int
+}
+
+func newArchivingClient(next Tcgplayer, bucket *blob.Bucket, runID string) *archivingClient {
+	return &archivingClient{
+		next:   next,
+		bucket: bucket,
+		runID:  runID,
+		pages:  map[string]int{},
+	}
+}
+
+// archive writes a gzipped response, every endpoint has its own page count
+func (a *archivingClient) archive(ctx context.Context, endpoint string, params interface{},
+	response interface{}) error {
+	a.mu.Lock()
+	page := a.pages[endpoint]
+	a.pages[endpoint]++
+	a.mu.Unlock()
+
+	p, err := json.Marshal(params)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	r, err := json.Marshal(response)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	// stored as a gzip file rather than with a gzip content encoding, some
+	// buckets transparently decompress those when they are read
+	w, err := a.bucket.NewWriter(ctx, archiveKey(a.runID, endpoint, page), &blob.WriterOptions{
+		ContentType: "application/gzip",
+	})
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	gz := gzip.NewWriter(w)
+	err = json.NewEncoder(gz).Encode(archiveRecord{
+		Endpoint:   endpoint,
+		Page:       page,
+		Params:     p,
+		Response:   r,
+		CapturedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		w.Close()
+		return errors.Wrap(err)
+	}
+
+	err = gz.Close()
+	if err != nil {
+		w.Close()
+		return errors.Wrap(err)
+	}
+
+	err = w.Close()
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
+
+// keep archives a response, a failed write is only logged so a bucket
+// problem never fails the api call the response came from
+func (a *archivingClient) keep(ctx context.Context, endpoint string, params interface{}, response interface{}) {
+	err := a.archive(ctx, endpoint, params, response)
+	if err != nil {
+		slog.Warn("unable to archive response", "endpoint", endpoint, "run", a.runID,
+			"error", errorMessage(err))
+	}
+}
+
+func (a *archivingClient) GetCategories(ctx context.Context) ([]*tcgplayer.Category, error) {
+	r, err := a.next.GetCategories(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	a.keep(ctx, endpointCategories, nil, r)
+	return r, nil
+}
+
+func (a *archivingClient) GetGroups(ctx context.Context, params tcgplayer.GroupParams) ([]*tcgplayer.Group, error) {
+	r, err := a.next.GetGroups(ctx, params)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	a.keep(ctx, endpointGroups, params, r)
+	return r, nil
+}
+
+func (a *archivingClient) GetRarities(ctx context.Context, params *tcgplayer.RarityParams) ([]*tcgplayer.Rarity, error) {
+	r, err := a.next.GetRarities(ctx, params)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	a.keep(ctx, endpointRarities, params, r)
+	return r, nil
+}
+
+func (a *archivingClient) GetPrinting(ctx context.Context, params tcgplayer.PrintingParams) ([]*tcgplayer.Printing, error) {
+	r, err := a.next.GetPrinting(ctx, params)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	a.keep(ctx, endpointPrintings, params, r)
+	return r, nil
+}
+
+func (a *archivingClient) GetConditions(ctx context.Context, params *tcgplayer.ConditionParams) ([]*tcgplayer.Condition, error) {
+	r, err := a.next.GetConditions(ctx, params)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	a.keep(ctx, endpointConditions, params, r)
+	return r, nil
+}
+
+func (a *archivingClient) GetLanguages(ctx context.Context, params *tcgplayer.LanguageParams) ([]*tcgplayer.Language, error) {
+	r, err := a.next.GetLanguages(ctx, params)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	a.keep(ctx, endpointLanguages, params, r)
+	return r, nil
+}
+
+func (a *archivingClient) ListAllProducts(ctx context.Context, params tcgplayer.ProductParams) ([]*tcgplayer.Product, error) {
+	r, err := a.next.ListAllProducts(ctx, params)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	a.keep(ctx, endpointProducts, params, r)
+	return r, nil
+}
+
+func (a *archivingClient) ListProductSKUs(ctx context.Context, productID int) ([]*tcgplayer.SKU, error) {
+	r, err := a.next.ListProductSKUs(ctx, productID)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	a.keep(ctx, endpointSKUs, productID, r)
+	return r, nil
+}
+
+func (a *archivingClient) GetSKUPrices(ctx context.Context, skus []int) ([]*tcgplayer.SKUMarketPrice, error) {
+	r, err := a.next.GetSKUPrices(ctx, skus)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	a.keep(ctx, endpointPrices, skus, r)
+	return r, nil
+}
+
+// readArchive reads every record archived for a run
+func readArchive(ctx context.Context, bucket *blob.Bucket, runID string) ([]*archiveRecord, error) {
+	records := []*archiveRecord{}
+	iter := bucket.List(&blob.ListOptions{Prefix: runID + "/"})
+	for {
+		obj, err := iter.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err)
+		}
+
+		record, err := readArchiveRecord(ctx, bucket, obj.Key)
+		if err != nil {
+			return nil, errors.Wrap(err)
+		}
+		records = append(records, record)
+	}
+
+	if len(records) == 0 {
+		return nil, errors.New("no archived responses for run " + runID)
+	}
+
+	return records, nil
+}
+
+func readArchiveRecord(ctx context.Context, bucket *blob.Bucket, key string) (*archiveRecord, error) {
+	r, err := bucket.NewReader(ctx, key, nil)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+	defer r.Close()
+
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+	defer gz.Close()
+
+	record := &archiveRecord{}
+	err = json.NewDecoder(gz).Decode(record)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	return record, nil
+}
+
+// replayClient is a Tcgplayer answering from archived responses, a request
+// matches a response when the endpoint and params are the same
+type replayClient struct {
+	responses map[string]json.RawMessage
+}
+
+func replayKey(endpoint string, params json.RawMessage) string {
+	return endpoint + " " + string(params)
+}
+
+func newReplayClient(records []*archiveRecord) *replayClient {
+	c := &replayClient{responses: map[string]json.RawMessage{}}
+	for _, r := range records {
+		c.responses[replayKey(r.Endpoint, r.Params)] = r.Response
+	}
+
+	return c
+}
+
+func (c *replayClient) replay(endpoint string, params interface{}, response interface{}) error {
+	p, err := json.Marshal(params)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	r, ok := c.responses[replayKey(endpoint, p)]
+	if !ok {
+		return errors.New(fmt.Sprintf("no archived %s response for %s", endpoint, p))
+	}
+
+	err = json.Unmarshal(r, response)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
+
+func (c *replayClient) GetCategories(ctx context.Context) ([]*tcgplayer.Category, error) {
+	r := []*tcgplayer.Category{}
+	return r, c.replay(endpointCategories, nil, &r)
+}
+
+func (c *replayClient) GetGroups(ctx context.Context, params tcgplayer.GroupParams) ([]*tcgplayer.Group, error) {
+	r := []*tcgplayer.Group{}
+	return r, c.replay(endpointGroups, params, &r)
+}
+
+func (c *replayClient) GetRarities(ctx context.Context, params *tcgplayer.RarityParams) ([]*tcgplayer.Rarity, error) {
+	r := []*tcgplayer.Rarity{}
+	return r, c.replay(endpointRarities, params, &r)
+}
+
+func (c *replayClient) GetPrinting(ctx context.Context, params tcgplayer.PrintingParams) ([]*tcgplayer.Printing, error) {
+	r := []*tcgplayer.Printing{}
+	return r, c.replay(endpointPrintings, params, &r)
+}
+
+func (c *replayClient) GetConditions(ctx context.Context, params *tcgplayer.ConditionParams) ([]*tcgplayer.Condition, error) {
+	r := []*tcgplayer.Condition{}
+	return r, c.replay(endpointConditions, params, &r)
+}
+
+func (c *replayClient) GetLanguages(ctx context.Context, params *tcgplayer.LanguageParams) ([]*tcgplayer.Language, error) {
+	r := []*tcgplayer.Language{}
+	return r, c.replay(endpointLanguages, params, &r)
+}
+
+func (c *replayClient) ListAllProducts(ctx context.Context, params tcgplayer.ProductParams) ([]*tcgplayer.Product, error) {
+	r := []*tcgplayer.Product{}
+	return r, c.replay(endpointProducts, params, &r)
+}
+
+func (c *replayClient) ListProductSKUs(ctx context.Context, productID int) ([]*tcgplayer.SKU, error) {
+	r := []*tcgplayer.SKU{}
+	return r, c.replay(endpointSKUs, productID, &r)
+}
+
+func (c *replayClient) GetSKUPrices(ctx context.Context, skus []int) ([]*tcgplayer.SKUMarketPrice, error) {
+	r := []*tcgplayer.SKUMarketPrice{}
+	return r, c.replay(endpointPrices, skus, &r)
+}
+
+// archivedGroupIDs returns the ids of the groups whose products were
+// crawled in the archived run
+func archivedGroupIDs(records []*archiveRecord) ([]int, error) {
+	groups := []*tcgplayer.Group{}
+	names := []string{}
+	for _, r := range records {
+		switch r.Endpoint {
+		case endpointGroups:
+			page := []*tcgplayer.Group{}
+			err := json.Unmarshal(r.Response, &page)
+			if err != nil {
+				return nil, errors.Wrap(err)
+			}
+			groups = append(groups, page...)
+		case endpointProducts:
+			params := tcgplayer.ProductParams{}
+			err := json.Unmarshal(r.Params, &params)
+			if err != nil {
+				return nil, errors.Wrap(err)
+			}
+			names = append(names, params.GroupName)
+		}
+	}
+
+	ids := []int{}
+	for _, g := range groups {
+		for _, n := range names {
+			if g.Name == n && !containsID(ids, g.ID) {
+				ids = append(ids, g.ID)
+				break
+			}
+		}
+	}
+
+	return ids, nil
+}
+
+// ExecReprocess is the entry point of the reprocess command, it rebuilds the
+// catalog and prices of an archived run without calling the api
+func ExecReprocess(ctx context.Context, runID string, args []string) error {
+	fs := newCommandFlagSet(commandReprocess)
+	archivedRunID := fs.String("run", "", "id of the archived run to reprocess")
+	partitionInterval := fs.String("price-partition-interval", partitionDay,
+		"range of each sku_prices partition when the table is partitioned, day or month")
+	err := fs.Parse(args)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	if *archiveURL == "" || *archivedRunID == "" {
+		return errors.New("reprocess needs -archive and -run")
+	}
+
+	return runCommand(ctx, runID, commandReprocess, func(s Store, events *eventPublisher) error {
+		bucket, err := openBucket(ctx, *archiveURL)
+		if err != nil {
+			return errors.Wrap(err)
+		}
+		defer bucket.Close()
+
+		records, err := readArchive(ctx, bucket, *archivedRunID)
+		if err != nil {
+			return errors.Wrap(err)
+		}
+
+		return reprocess(ctx, s, records, *partitionInterval)
+	})
+}
+
+// archivedEndpoints returns the endpoints with at least one archived response
+func archivedEndpoints(records []*archiveRecord) map[string]bool {
+	endpoints := map[string]bool{}
+	for _, r := range records {
+		endpoints[r.Endpoint] = true
+	}
+
+	return endpoints
+}
+
+// archivedDiscoveries returns the products listed with their skus in the
+// archived run, which are the products discovery found, and their groups
+func archivedDiscoveries(records []*archiveRecord) ([]*tcgplayer.Group, []*tcgplayer.Product, error) {
+	groups := map[int]*tcgplayer.Group{}
+	products := map[int]*tcgplayer.Product{}
+	skus := map[int][]*tcgplayer.SKU{}
+	order := []int{}
+	for _, r := range records {
+		switch r.Endpoint {
+		case endpointGroups:
+			page := []*tcgplayer.Group{}
+			err := json.Unmarshal(r.Response, &page)
+			if err != nil {
+				return nil, nil, errors.Wrap(err)
+			}
+			for _, g := range page {
+				groups[g.ID] = g
+			}
+		case endpointProducts:
+			page := []*tcgplayer.Product{}
+			err := json.Unmarshal(r.Response, &page)
+			if err != nil {
+				return nil, nil, errors.Wrap(err)
+			}
+			for _, p := range page {
+				products[p.ID] = p
+			}
+		case endpointSKUs:
+			productID := 0
+			err := json.Unmarshal(r.Params, &productID)
+			if err != nil {
+				return nil, nil, errors.Wrap(err)
+			}
+
+			page := []*tcgplayer.SKU{}
+			err = json.Unmarshal(r.Response, &page)
+			if err != nil {
+				return nil, nil, errors.Wrap(err)
+			}
+			skus[productID] = page
+			order = append(order, productID)
+		}
+	}
+
+	discovered := []*tcgplayer.Product{}
+	discoveredGroups := []*tcgplayer.Group{}
+	for _, id := range order {
+		p, ok := products[id]
+		if !ok {
+			slog.Warn("archived skus of a product that wasn't archived", "product", id)
+			continue
+		}
+
+		p.SKUS = []tcgplayer.SKU{}
+		for _, s := range skus[id] {
+			p.SKUS = append(p.SKUS, *s)
+		}
+		discovered = append(discovered, p)
+
+		g, ok := groups[p.GroupID]
+		if !ok {
+			return nil, nil, errors.New(fmt.Sprintf("group %d of product %d wasn't archived", p.GroupID, p.ID))
+		}
+		if !containsGroup(discoveredGroups, g.ID) {
+			discoveredGroups = append(discoveredGroups, g)
+		}
+	}
+
+	return discoveredGroups, discovered, nil
+}
+
+func containsGroup(groups []*tcgplayer.Group, id int) bool {
+	for _, g := range groups {
+		if g.ID == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// reprocess writes the archived responses to the database, only what the
+// archived run requested is replayed. A catalog sync archives the reference
+// data so the groups it crawled are replaced, discovery archives the skus
+// of the products it found so those products are written. The archived
+// prices are inserted with the time they were captured at, a price already
+// stored at that time is skipped so a run can be reprocessed again
+func reprocess(ctx context.Context, s Store, records []*archiveRecord, interval string) error {
+	endpoints := archivedEndpoints(records)
+
+	groupIDs := []int{}
+	if endpoints[endpointRarities] && endpoints[endpointPrintings] && endpoints[endpointConditions] &&
+		endpoints[endpointLanguages] {
+		var err error
+		groupIDs, err = archivedGroupIDs(records)
+		if err != nil {
+			return errors.Wrap(err)
+		}
+	}
+
+	if len(groupIDs) > 0 {
+		opts := catalogOptions{groupIDs: groupIDs, recrawl: true}
+		slog.Info("reprocessing catalog", "groups", len(groupIDs))
+		err := updateImmutableDataTcgPlayer(ctx, s, newReplayClient(records), nil, tcgplayer.CategoryYugioh, opts)
+		if err != nil {
+			return errors.Wrap(err)
+		}
+	}
+
+	groups, products, err := archivedDiscoveries(records)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	if len(products) > 0 {
+		slog.Info("reprocessing discovered products", "products", len(products))
+		err := s.Transaction(ctx, func(tx Store) error {
+			_, err := tx.UpsertGroups(ctx, groups)
+			if err != nil {
+				return errors.Wrap(err)
+			}
+
+			_, err = tx.UpsertProducts(ctx, nil, products)
+			return err
+		})
+		if err != nil {
+			return errors.Wrap(err)
+		}
+	}
+
+	// the partitions ingest-prices creates start at the current time, the
+	// ones of the captured times may never have existed
+	partitioner, partitioned := s.(pricePartitioner)
+	partitions := map[time.Time]bool{}
+	prices := []skuPrice{}
+	skipped := 0
+	for _, r := range records {
+		if r.Endpoint != endpointPrices {
+			continue
+		}
+
+		page := []*tcgplayer.SKUMarketPrice{}
+		err := json.Unmarshal(r.Response, &page)
+		if err != nil {
+			return errors.Wrap(err)
+		}
+
+		ids := []int{}
+		for _, p := range page {
+			ids = append(ids, p.SKUID)
+		}
+
+		stored, err := s.PricedSKUIDs(ctx, r.CapturedAt, ids)
+		if err != nil {
+			return errors.Wrap(err)
+		}
+
+		for _, p := range page {
+			if containsID(stored, p.SKUID) {
+				skipped++
+				continue
+			}
+			prices = append(prices, newSKUPrice(p, r.CapturedAt))
+		}
+
+		start, _, err := partitionRange(interval, r.CapturedAt)
+		if err != nil {
+			return errors.Wrap(err)
+		}
+		if !partitioned || partitions[start] {
+			continue
+		}
+		partitions[start] = true
+
+		_, err = partitioner.CreatePricePartitions(ctx, interval, start, 0)
+		if err != nil {
+			return errors.Wrap(err)
+		}
+	}
+
+	err = s.InsertPrices(ctx, prices)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	slog.Info("reprocessed archive", "groups", len(groupIDs), "discovered", len(products),
+		"prices", len(prices), "skipped", skipped, "responses", len(records))
+	return nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/AustinMCrane/tcgplayer"
+	"github.com/DATA-DOG/go-sqlmock"
+	gomock "github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"gocloud.dev/blob"
+)
+
+func TestArchivingClient_Replay(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	next := NewMockTcgplayer(ctrl)
+
+	bucket, err := blob.OpenBucket(ctx, "mem://")
+	require.NoError(t, err)
+	defer bucket.Close()
+
+	params := tcgplayer.ProductParams{CategoryID: tcgplayer.CategoryYugioh, GroupName: "test-1", Limit: 100}
+	products := []*tcgplayer.Product{{ID: 1, GroupID: 1, Name: "test"}}
+	next.EXPECT().ListAllProducts(gomock.Any(), params).Return(products, nil)
+	next.EXPECT().GetSKUPrices(gomock.Any(), []int{1, 2}).
+		Return([]*tcgplayer.SKUMarketPrice{{SKUID: 1, LowPrice: 1.5}}, nil)
+
+	client := newArchivingClient(next, bucket, "test-run")
+	_, err = client.ListAllProducts(ctx, params)
+	require.NoError(t, err)
+	_, err = client.GetSKUPrices(ctx, []int{1, 2})
+	require.NoError(t, err)
+
+	exists, err := bucket.Exists(ctx, archiveKey("test-run", endpointProducts, 0))
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	records, err := readArchive(ctx, bucket, "test-run")
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+
+	// the same requests are answered from the archive
+	replay := newReplayClient(records)
+	replayed, err := replay.ListAllProducts(ctx, params)
+	require.NoError(t, err)
+	require.Equal(t, products, replayed)
+
+	_, err = replay.GetSKUPrices(ctx, []int{3})
+	require.Error(t, err)
+}
+
+func TestReprocess_Prices(t *testing.T) {
+	ctx := context.Background()
+	dbConn, mock := GetMockDB(t)
+
+	capturedAt := time.Date(2023, 3, 1, 12, 0, 0, 0, time.UTC)
+	records := []*archiveRecord{
+		{
+			Endpoint:   endpointPrices,
+			Params:     []byte(`[1,2]`),
+			Response:   []byte(`[{"skuId":1,"lowPrice":1.5,"lowestShipping":0.5},{"skuId":2,"lowPrice":3}]`),
+			CapturedAt: capturedAt,
+		},
+	}
+
+	// sku 2 was already reprocessed
+	mock.ExpectQuery(`SELECT DISTINCT "sku_id" FROM "sku_prices" WHERE ingested_at = \$1 AND sku_id IN \(\$2,\$3\)`).
+		WithArgs(capturedAt, 1, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"sku_id"}).AddRow(2))
+
+	// the partition of the day the prices were captured is created
+	mock.ExpectQuery(`SELECT EXISTS \(SELECT 1 FROM pg_partitioned_table`).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery(`SELECT c.relname FROM pg_inherits`).
+		WillReturnRows(sqlmock.NewRows([]string{"relname"}).AddRow("sku_prices_p20230302"))
+	mock.ExpectExec(`CREATE TABLE sku_prices_p20230301 PARTITION OF sku_prices ` +
+		`FOR VALUES FROM \('2023-03-01'\) TO \('2023-03-02'\)`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	// prices keep the time they were originally captured at
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO \"sku_prices\" (.+)`).
+		WithArgs(1, float32(1.5), float32(0.5), int64(150), int64(50), priceCurrency, capturedAt).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+
+	err := reprocess(ctx, newSQLStore(dbConn), records, partitionDay)
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestArchivingClient_WriteFails(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	next := NewMockTcgplayer(ctrl)
+
+	bucket, err := blob.OpenBucket(ctx, "mem://")
+	require.NoError(t, err)
+	require.NoError(t, bucket.Close())
+
+	prices := []*tcgplayer.SKUMarketPrice{{SKUID: 1, LowPrice: 1.5}}
+	next.EXPECT().GetSKUPrices(gomock.Any(), []int{1}).Return(prices, nil)
+
+	// the response is still handed back when it can't be archived
+	client := newArchivingClient(next, bucket, "test-run")
+	r, err := client.GetSKUPrices(ctx, []int{1})
+	require.NoError(t, err)
+	require.Equal(t, prices, r)
+}
+
+func TestReprocess_Discovery(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	next := NewMockTcgplayer(ctrl)
+
+	bucket, err := blob.OpenBucket(ctx, "mem://")
+	require.NoError(t, err)
+	defer bucket.Close()
+
+	next.EXPECT().GetGroups(gomock.Any(), gomock.Any()).
+		Return([]*tcgplayer.Group{{ID: 1, Name: "new-set", PublishedOn: "2023-03-10T00:00:00"}}, nil)
+	next.EXPECT().GetRarities(gomock.Any(), gomock.Any()).
+		Return([]*tcgplayer.Rarity{{ID: 1, Name: "Common"}}, nil)
+	next.EXPECT().GetPrinting(gomock.Any(), gomock.Any()).
+		Return([]*tcgplayer.Printing{{ID: 1, Name: "1st Edition"}}, nil)
+	next.EXPECT().GetConditions(gomock.Any(), gomock.Any()).
+		Return([]*tcgplayer.Condition{{ID: 1, Name: "Near Mint"}}, nil)
+	next.EXPECT().GetLanguages(gomock.Any(), gomock.Any()).
+		Return([]*tcgplayer.Language{{ID: 1, Name: "English"}}, nil)
+	next.EXPECT().ListAllProducts(gomock.Any(), gomock.Any()).
+		Return([]*tcgplayer.Product{{ID: 2, GroupID: 1, CategoryID: tcgplayer.CategoryYugioh, CleanName: "new-card"}}, nil)
+	next.EXPECT().ListProductSKUs(gomock.Any(), 2).
+		Return([]*tcgplayer.SKU{{SKUID: 20, ProductID: 2, LanguageID: 1, PrintingID: 1, ConditionID: 1}}, nil)
+	next.EXPECT().GetSKUPrices(gomock.Any(), []int{20}).
+		Return([]*tcgplayer.SKUMarketPrice{{SKUID: 20, LowPrice: 1.5}}, nil)
+
+	// a price run discovers a product with the reference data of its group
+	// then fetches its prices, the reprocess replays both
+	client := newArchivingClient(next, bucket, "test-run")
+	_, err = discoverNewProducts(ctx, newMemoryStore(), client, nil, tcgplayer.CategoryYugioh)
+	require.NoError(t, err)
+	_, err = client.GetSKUPrices(ctx, []int{20})
+	require.NoError(t, err)
+
+	records, err := readArchive(ctx, bucket, "test-run")
+	require.NoError(t, err)
+
+	s := newMemoryStore()
+	require.NoError(t, reprocess(ctx, s, records, partitionDay))
+
+	skus, err := s.ListSKUIDs(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []int{20}, skus)
+	require.Len(t, s.groups, 1)
+	require.Len(t, s.prices, 1)
+
+	// reprocessing the run again doesn't duplicate its prices
+	require.NoError(t, reprocess(ctx, s, records, partitionDay))
+	require.Len(t, s.prices, 1)
+}
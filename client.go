@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	errors "github.com/AustinMCrane/errorutil"
+	"github.com/AustinMCrane/tcgplayer"
+)
+
+// tcgplayerClient adapts the tcgplayer client to the Tcgplayer interface,
+// the underlying client has no context support so each call checks ctx
+// before going out to the api
+type tcgplayerClient struct {
+	client *tcgplayer.Client
+}
+
+func newTcgplayerClient(publicKey string, privateKey string) (*tcgplayerClient, error) {
+	client, err := tcgplayer.New(publicKey, privateKey)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	return &tcgplayerClient{client: client}, nil
+}
+
+func (c *tcgplayerClient) GetCategories(ctx context.Context) ([]*tcgplayer.Category, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	return c.client.GetCategories()
+}
+
+func (c *tcgplayerClient) GetGroups(ctx context.Context, params tcgplayer.GroupParams) ([]*tcgplayer.Group, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	return c.client.GetGroups(params)
+}
+
+func (c *tcgplayerClient) GetRarities(ctx context.Context, params *tcgplayer.RarityParams) ([]*tcgplayer.Rarity, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	return c.client.GetRarities(params)
+}
+
+func (c *tcgplayerClient) GetPrinting(ctx context.Context, params tcgplayer.PrintingParams) ([]*tcgplayer.Printing, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	return c.client.GetPrinting(params)
+}
+
+func (c *tcgplayerClient) GetConditions(ctx context.Context, params *tcgplayer.ConditionParams) ([]*tcgplayer.Condition, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	return c.client.GetConditions(params)
+}
+
+func (c *tcgplayerClient) GetLanguages(ctx context.Context, params *tcgplayer.LanguageParams) ([]*tcgplayer.Language, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	return c.client.GetLanguages(params)
+}
+
+func (c *tcgplayerClient) ListAllProducts(ctx context.Context, params tcgplayer.ProductParams) ([]*tcgplayer.Product, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	return c.client.ListAllProducts(params)
+}
+
+func (c *tcgplayerClient) ListProductSKUs(ctx context.Context, productID int) ([]*tcgplayer.SKU, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	return c.client.ListProductSKUs(productID)
+}
+
+func (c *tcgplayerClient) GetSKUPrices(ctx context.Context, skus []int) ([]*tcgplayer.SKUMarketPrice, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	return c.client.GetSKUPrices(skus)
+}
+
+// sleepContext sleeps for d or until ctx is done, whichever comes first
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	errors "github.com/AustinMCrane/errorutil"
+	"github.com/AustinMCrane/tcgplayer"
+)
+
+const (
+	commandSyncCategories = "sync-categories"
+	commandSyncCatalog    = "sync-catalog"
+	commandIngestPrices   = "ingest-prices"
+	commandTrim           = "trim"
+)
+
+// commandHelp is the one line description of every command, in the order
+// the usage lists them
+var commandHelp = []struct {
+	name        string
+	description string
+}{
+	{commandSyncCategories, "write the tcgplayer categories"},
+	{commandSyncCatalog, "crawl the groups, products and skus of yugioh that changed"},
+	{commandIngestPrices, "fetch the prices of every sku, or of a budget or the watchlist"},
+	{commandTrim, "remove the prices the retention policy no longer keeps"},
+	{commandSyncRates, "write the exchange rates of the dollar from a rates endpoint or a csv file"},
+	{commandVerify, "check the integrity of the catalog"},
+	{commandExport, "write the prices of a date range to csv, jsonl or parquet"},
+	{commandLookup, "print the current and recent prices of the cards matching a name"},
+	{commandServe, "serve the catalog and prices over a read only http api"},
+	{commandDaemon, "run the sync, price and trim jobs on a schedule and serve health endpoints"},
+	{commandRuns, "list the latest runs and how they went"},
+	{commandWatch, "add, remove or list watchlist entries"},
+	{commandRepairSKUs, "refetch the skus of products with missing or broken skus"},
+	{commandReprocess, "rebuild the catalog and prices of an archived run"},
+}
+
+// usage prints the global flags and the commands
+func usage() {
+	w := flag.CommandLine.Output()
+	fmt.Fprintf(w, "usage: %s [global flags] <command> [flags]\n\ncommands:\n", os.Args[0])
+	for _, c := range commandHelp {
+		fmt.Fprintf(w, "  %-16s %s\n", c.name, c.description)
+	}
+
+	fmt.Fprintf(w, "\nrun %s <command> -h for the flags of a command\n\nglobal flags:\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+// newCommandFlagSet returns the flag set of a command, -h prints its
+// description and flags
+func newCommandFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s [global flags] %s [flags]\n\n", os.Args[0], name)
+		for _, c := range commandHelp {
+			if c.name == name {
+				fmt.Fprintf(fs.Output(), "%s\n\n", c.description)
+			}
+		}
+
+		fmt.Fprintf(fs.Output(), "flags:\n")
+		fs.PrintDefaults()
+	}
+
+	return fs
+}
+
+// runCommand opens the store and the events publisher and runs fn, its
+// outcome is published as an event and recorded in the run history
+func runCommand(ctx context.Context, runID string, command string,
+	fn func(s Store, events *eventPublisher) error) (err error) {
+	s, err := openStore(ctx)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	events, err := newEventPublisher(ctx, *eventsTopic, runID)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	start := time.Now()
+	defer func() {
+		// ctx may already be cancelled, the outcome is still worth sending
+		flushCtx := context.WithoutCancel(ctx)
+		completed := runCompletedEvent{
+			Command:         command,
+			OK:              err == nil,
+			DurationSeconds: time.Since(start).Seconds(),
+		}
+		if err != nil {
+			completed.Error = errorMessage(err)
+		}
+		events.publish(flushCtx, eventRunCompleted, completed)
+
+		recordErr := s.RecordRun(flushCtx, newIngestRun(runID, start, completed))
+		if recordErr != nil {
+			slog.Error("unable to record run", "error", recordErr)
+		}
+
+		shutdownErr := events.Shutdown(flushCtx)
+		if shutdownErr != nil {
+			slog.Error("unable to flush events", "error", shutdownErr)
+		}
+
+		// the daemon runs commands over and over, each must not leave its
+		// connections open
+		if closer, ok := s.(io.Closer); ok {
+			closeErr := closer.Close()
+			if closeErr != nil {
+				slog.Error("unable to close store", "error", closeErr)
+			}
+		}
+	}()
+
+	return fn(s, events)
+}
+
+// clientFactory returns the tcgplayer client of a run and a func releasing
+// it
+type clientFactory func(ctx context.Context, runID string) (Tcgplayer, func(), error)
+
+// clientFunc is the work of a command that talks to tcgplayer
+type clientFunc func(s Store, events *eventPublisher, client Tcgplayer) error
+
+// runClientCommand is runCommand with a tcgplayer client from connect, a
+// client that can't be created fails the run like any other error
+func runClientCommand(ctx context.Context, runID string, command string, connect clientFactory,
+	fn clientFunc) error {
+	return runCommand(ctx, runID, command, func(s Store, events *eventPublisher) error {
+		client, closeClient, err := connect(ctx, runID)
+		if err != nil {
+			return errors.Wrap(err)
+		}
+		defer closeClient()
+
+		return fn(s, events, client)
+	})
+}
+
+// newRunClient returns the tcgplayer client of a run, it archives the raw
+// responses when -archive is set, closeClient releases the archive
+func newRunClient(ctx context.Context, runID string) (client Tcgplayer, closeClient func(), err error) {
+	client, err = newTcgplayerClient(*publicKey, *privateKey)
+	if err != nil {
+		return nil, nil, errors.Wrap(err)
+	}
+
+	return archiveClient(ctx, client, runID)
+}
+
+// archiveClient wraps client so it archives the raw responses when -archive
+// is set
+func archiveClient(ctx context.Context, client Tcgplayer, runID string) (Tcgplayer, func(), error) {
+	if *archiveURL == "" {
+		return client, func() {}, nil
+	}
+
+	bucket, err := openBucket(ctx, *archiveURL)
+	if err != nil {
+		return nil, nil, errors.Wrap(err)
+	}
+
+	return newArchivingClient(client, bucket, runID), func() { bucket.Close() }, nil
+}
+
+// ExecSyncCategories is the entry point of the sync-categories command
+func ExecSyncCategories(ctx context.Context, runID string, args []string) error {
+	fs := newCommandFlagSet(commandSyncCategories)
+	err := fs.Parse(args)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	return runClientCommand(ctx, runID, commandSyncCategories, newRunClient,
+		func(s Store, events *eventPublisher, client Tcgplayer) error {
+			categories, err := getCategories(ctx, client)
+			if err != nil {
+				return errors.Wrap(err)
+			}
+
+			err = s.UpsertCategories(ctx, categories)
+			if err != nil {
+				return errors.Wrap(err)
+			}
+
+			slog.Info("synced categories", "categories", len(categories))
+			return nil
+		})
+}
+
+// ExecSyncCatalog is the entry point of the sync-catalog command
+func ExecSyncCatalog(ctx context.Context, runID string, args []string) error {
+	fs := newCommandFlagSet(commandSyncCatalog)
+	groupIDs := fs.String("groups", "", "comma separated tcgplayer group ids to sync, all groups when empty")
+	fullRefresh := fs.Bool("full-refresh", false, "empty the catalog and crawl every group again")
+	sampleSize := fs.Int("sample", 0, "only sync this many groups picked at random by -sample-seed, "+
+		"every group when 0")
+	sampleGroups := fs.String("sample-groups", "", "comma separated tcgplayer ids or names of the only groups to sync")
+	sampleSeed := fs.Int64("sample-seed", 1, "seed picking the -sample groups, the same seed picks the same groups")
+	devMode := fs.Bool("dev", false, fmt.Sprintf("same as -sample %d", devGroupLimit))
+	err := fs.Parse(args)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	opts := catalogOptions{fullRefresh: *fullRefresh}
+	opts.groupIDs, err = parseIDs(*groupIDs)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	if *devMode && *sampleSize == 0 {
+		*sampleSize = devGroupLimit
+	}
+	if *sampleSize > 0 || *sampleGroups != "" {
+		opts.sample = &groupSample{names: parseGroupNames(*sampleGroups), size: *sampleSize, seed: *sampleSeed}
+	}
+
+	return runClientCommand(ctx, runID, commandSyncCatalog, newRunClient, syncCatalog(ctx, opts))
+}
+
+// syncCatalog is the work of the sync-catalog command
+func syncCatalog(ctx context.Context, opts catalogOptions) clientFunc {
+	return func(s Store, events *eventPublisher, client Tcgplayer) error {
+		slog.Info("syncing catalog", "category", tcgplayer.CategoryYugioh)
+		return updateImmutableDataTcgPlayer(ctx, s, client, events, tcgplayer.CategoryYugioh, opts)
+	}
+}
+
+// priceOptions controls which prices an ingest-prices run fetches
+type priceOptions struct {
+	// watchlist only refreshes the watchlisted skus and skips discovery
+	watchlist bool
+	// budget is how many price requests the run may make, every sku is
+	// fetched when 0
+	budget            int
+	discoverProducts  bool
+	flush             flushPolicy
+	partitionInterval string
+	partitionsAhead   int
+	// pullRates weight the set indexes, they are plain set values when nil
+	pullRates pullRates
+}
+
+// ExecIngestPrices is the entry point of the ingest-prices command, it
+// fetches the prices of every sku unless limited to a budget or the
+// watchlist
+func ExecIngestPrices(ctx context.Context, runID string, args []string) error {
+	fs := newCommandFlagSet(commandIngestPrices)
+	watchlist := fs.Bool("watchlist", false, "only refresh the prices of watchlisted skus, "+
+		"meant to run more often than the full sweep")
+	priceBudget := fs.Int("price-budget", 0, "how many price requests the run may make, "+
+		"the most volatile and stalest skus are fetched first, every sku is fetched when 0")
+	discoverProducts := fs.Bool("discover-products", true,
+		"add new products of the groups added or modified since the last sync before ingesting prices")
+	flushSize := fs.Int("price-flush-size", 5000, "how many prices are buffered before they are written")
+	flushInterval := fs.Duration("price-flush-interval", time.Second*10,
+		"how long prices are buffered before they are written")
+	partitionInterval := fs.String("price-partition-interval", partitionDay,
+		"range of each sku_prices partition when the table is partitioned, day or month")
+	partitionsAhead := fs.Int("price-partitions-ahead", 3,
+		"how many sku_prices partitions are created ahead of the current one")
+	pullRatesConfig := fs.String("pull-rates", "", "json file of the cards of each rarity a pack holds, "+
+		"weights the set indexes, they are plain set values without one")
+	err := fs.Parse(args)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	rates, err := loadPullRates(*pullRatesConfig)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	return runClientCommand(ctx, runID, commandIngestPrices, newRunClient, ingestPrices(ctx, priceOptions{
+		watchlist:         *watchlist,
+		budget:            *priceBudget,
+		discoverProducts:  *discoverProducts,
+		flush:             flushPolicy{size: *flushSize, interval: *flushInterval},
+		partitionInterval: *partitionInterval,
+		partitionsAhead:   *partitionsAhead,
+		pullRates:         rates,
+	}))
+}
+
+// ingestPrices is the work of the ingest-prices command
+func ingestPrices(ctx context.Context, opts priceOptions) clientFunc {
+	return func(s Store, events *eventPublisher, client Tcgplayer) error {
+		if partitioner, ok := s.(pricePartitioner); ok {
+			_, err := partitioner.CreatePricePartitions(ctx, opts.partitionInterval, time.Now(),
+				opts.partitionsAhead)
+			if err != nil {
+				return errors.Wrap(err)
+			}
+		}
+
+		err := fetchPrices(ctx, s, client, events, opts)
+		if err != nil {
+			return errors.Wrap(err)
+		}
+
+		// a watchlist refresh is meant to be quick and only touches a few
+		// skus, the aggregates and set values are left to the full sweeps
+		if opts.watchlist {
+			return nil
+		}
+
+		return updatePriceAggregates(ctx, s, opts.pullRates, time.Now())
+	}
+}
+
+// fetchPrices fetches the prices an ingest-prices run is limited to
+func fetchPrices(ctx context.Context, s Store, client Tcgplayer, events *eventPublisher, opts priceOptions) error {
+	if opts.watchlist {
+		// the watchlist is refreshed between full sweeps, discovery is left
+		// to them
+		skus, err := s.WatchedSKUIDs(ctx)
+		if err != nil {
+			return errors.Wrap(err)
+		}
+
+		slog.Info("ingesting watchlist prices", "skus", len(skus))
+		return ingestSKUPrices(ctx, s, client, events, skus, time.Millisecond*100, opts.flush)
+	}
+
+	if opts.discoverProducts {
+		_, err := discoverNewProducts(ctx, s, client, events, tcgplayer.CategoryYugioh)
+		if err != nil {
+			return errors.Wrap(err)
+		}
+	}
+
+	slog.Info("ingesting prices")
+	if opts.budget > 0 {
+		return ingestPricesWithinBudget(ctx, s, client, events, opts.budget, time.Millisecond*100, opts.flush)
+	}
+
+	return ingetPrices(ctx, s, client, events, time.Millisecond*100, opts.flush)
+}
+
+// ExecTrim is the entry point of the trim command
+func ExecTrim(ctx context.Context, runID string, args []string) error {
+	fs := newCommandFlagSet(commandTrim)
+	retentionConfig := fs.String("retention-config", "",
+		fmt.Sprintf("json file with the price retention rules, prices are kept %d days without one",
+			defaultRetentionDays))
+	err := fs.Parse(args)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	policy, err := loadRetentionPolicy(*retentionConfig)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	return runCommand(ctx, runID, commandTrim, func(s Store, events *eventPublisher) error {
+		return trimOldPriceData(ctx, s, policy)
+	})
+}
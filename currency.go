@@ -0,0 +1,411 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"math/big"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	errors "github.com/AustinMCrane/errorutil"
+)
+
+const (
+	commandSyncRates = "sync-rates"
+
+	// priceCurrency is the currency tcgplayer prices are in and sku_prices
+	// stores them in
+	priceCurrency = "USD"
+
+	// rateScale is what exchange rates are multiplied by to be kept as
+	// integers, six decimal places
+	rateScale = 1_000_000
+)
+
+// cents is an exact amount of money in hundredths of its currency
+type cents int64
+
+// toCents rounds a price in dollars as the api reports it to the nearest
+// cent
+func toCents(price float64) cents {
+	return cents(math.Round(price * 100))
+}
+
+// dollars returns the amount in whole units of its currency
+func (c cents) dollars() float64 {
+	return float64(c) / 100
+}
+
+func (c cents) String() string {
+	sign := ""
+	if c < 0 {
+		sign, c = "-", -c
+	}
+
+	return fmt.Sprintf("%s%d.%02d", sign, c/100, c%100)
+}
+
+// MarshalJSON writes the exact decimal amount as a json number
+func (c cents) MarshalJSON() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// UnmarshalJSON reads a decimal amount exactly, it has to be whole cents
+func (c *cents) UnmarshalJSON(data []byte) error {
+	r, ok := new(big.Rat).SetString(string(data))
+	if !ok {
+		return errors.New("invalid amount: " + string(data))
+	}
+
+	r.Mul(r, big.NewRat(100, 1))
+	if !r.IsInt() || !r.Num().IsInt64() {
+		return errors.New("amount isn't whole cents: " + string(data))
+	}
+
+	*c = cents(r.Num().Int64())
+	return nil
+}
+
+// exchangeRate is how many units of a currency one dollar bought on a day,
+// kept as an integer of rateScale so conversions are exact
+type exchangeRate struct {
+	Day       string `gorm:"primaryKey"`
+	Currency  string `gorm:"primaryKey"`
+	Rate      int64
+	Source    string
+	FetchedAt time.Time
+}
+
+func (exchangeRate) TableName() string {
+	return "ingest_exchange_rates"
+}
+
+// convert converts an amount of dollars at the rate, half a cent rounds
+// away from zero
+func (r *exchangeRate) convert(c cents) cents {
+	product := int64(c) * r.Rate
+	if product < 0 {
+		return cents((product - rateScale/2) / rateScale)
+	}
+
+	return cents((product + rateScale/2) / rateScale)
+}
+
+// parseRate parses a decimal exchange rate exactly
+func parseRate(s string) (*big.Rat, error) {
+	r, ok := new(big.Rat).SetString(strings.TrimSpace(s))
+	if !ok {
+		return nil, errors.New("invalid exchange rate: " + s)
+	}
+
+	return r, nil
+}
+
+// scaleRate returns the rate as an integer of rateScale, digits past the
+// sixth decimal place are rounded half up
+func scaleRate(r *big.Rat) (int64, error) {
+	scaled := new(big.Rat).Mul(r, big.NewRat(rateScale, 1))
+	scaled.Add(scaled, big.NewRat(1, 2))
+
+	rate := new(big.Int).Quo(scaled.Num(), scaled.Denom())
+	if rate.Sign() <= 0 || !rate.IsInt64() {
+		return 0, errors.New("exchange rate out of range: " + r.FloatString(8))
+	}
+
+	return rate.Int64(), nil
+}
+
+// parseCurrency returns the upper case iso 4217 code
+func parseCurrency(s string) (string, error) {
+	code := strings.ToUpper(strings.TrimSpace(s))
+	if len(code) != 3 || strings.Trim(code, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+		return "", errors.New("invalid currency code: " + s)
+	}
+
+	return code, nil
+}
+
+// ExecSyncRates is the entry point of the sync-rates command, it writes the
+// exchange rates of the dollar from a json rates endpoint or a csv file
+func ExecSyncRates(ctx context.Context, runID string, args []string) error {
+	fs := newCommandFlagSet(commandSyncRates)
+	source := fs.String("source", "", "url of a json rates endpoint, e.g. https://api.frankfurter.app/latest?from=USD, "+
+		"or path of a csv file of day,currency,rate rows")
+	err := fs.Parse(args)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	if *source == "" {
+		return errors.New("sync-rates needs a -source")
+	}
+
+	return runCommand(ctx, runID, commandSyncRates, syncRates(ctx, *source))
+}
+
+// syncRates is the work of the sync-rates command
+func syncRates(ctx context.Context, source string) func(s Store, events *eventPublisher) error {
+	return func(s Store, events *eventPublisher) error {
+		rates, err := fetchRates(ctx, source, time.Now())
+		if err != nil {
+			return errors.Wrap(err)
+		}
+
+		err = s.UpsertExchangeRates(ctx, rates)
+		if err != nil {
+			return errors.Wrap(err)
+		}
+
+		slog.Info("synced exchange rates", "source", source, "rates", len(rates))
+		return nil
+	}
+}
+
+// fetchRates reads the rates of a source, an http url is a json endpoint
+// and anything else a csv file
+func fetchRates(ctx context.Context, source string, now time.Time) ([]*exchangeRate, error) {
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		f, err := os.Open(source)
+		if err != nil {
+			return nil, errors.Wrap(err)
+		}
+		defer f.Close()
+
+		return parseRatesCSV(f, source, now)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	client := &http.Client{Timeout: time.Second * 30}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(fmt.Sprintf("unable to fetch rates from %s: %s", source, resp.Status))
+	}
+
+	return parseRatesJSON(resp.Body, source, now)
+}
+
+// parseRatesCSV parses day,currency,rate rows after a header, every rate is
+// the units of the currency a dollar buys on the day
+func parseRatesCSV(r io.Reader, source string, now time.Time) ([]*exchangeRate, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	if len(records) == 0 || strings.Join(records[0], ",") != "day,currency,rate" {
+		return nil, errors.New("rates csv must start with a day,currency,rate header")
+	}
+
+	rates := []*exchangeRate{}
+	for i, record := range records[1:] {
+		r, err := parseRate(record[2])
+		if err != nil {
+			return nil, errors.Wrap(err)
+		}
+
+		rate, err := newExchangeRate(record[0], record[1], r, source, now)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("line %d of the rates csv: %s", i+2, errorMessage(err)))
+		}
+		rates = append(rates, rate)
+	}
+
+	return rates, nil
+}
+
+// ratesResponse is what a json rates endpoint answers, the rates are units
+// of each currency one unit of base buys
+type ratesResponse struct {
+	Base  string                 `json:"base"`
+	Date  string                 `json:"date"`
+	Rates map[string]json.Number `json:"rates"`
+}
+
+// parseRatesJSON parses the rates of a day, rates of another base than the
+// dollar are converted through the dollar rate they come with
+func parseRatesJSON(r io.Reader, source string, now time.Time) ([]*exchangeRate, error) {
+	resp := ratesResponse{}
+	err := json.NewDecoder(r).Decode(&resp)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	base, err := parseCurrency(resp.Base)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	// a dollar buys base/usd of each currency
+	dollar := big.NewRat(1, 1)
+	if base != priceCurrency {
+		usd, ok := resp.Rates[priceCurrency]
+		if !ok {
+			return nil, errors.New("rates of " + base + " have no dollar rate to convert them with")
+		}
+
+		r, err := parseRate(usd.String())
+		if err != nil {
+			return nil, errors.Wrap(err)
+		}
+		if r.Sign() <= 0 {
+			return nil, errors.New("invalid dollar rate: " + usd.String())
+		}
+		dollar.Inv(r)
+
+		// the base is left out of its own rates
+		resp.Rates[base] = json.Number("1")
+	}
+
+	codes := []string{}
+	for code := range resp.Rates {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+
+	rates := []*exchangeRate{}
+	for _, code := range codes {
+		if code == priceCurrency {
+			continue
+		}
+
+		r, err := parseRate(resp.Rates[code].String())
+		if err != nil {
+			return nil, errors.Wrap(err)
+		}
+
+		rate, err := newExchangeRate(resp.Date, code, r.Mul(r, dollar), source, now)
+		if err != nil {
+			return nil, errors.Wrap(err)
+		}
+		rates = append(rates, rate)
+	}
+
+	return rates, nil
+}
+
+func newExchangeRate(day string, currency string, rate *big.Rat, source string,
+	now time.Time) (*exchangeRate, error) {
+	_, err := time.Parse(dateLayout, day)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	code, err := parseCurrency(currency)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	scaled, err := scaleRate(rate)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	return &exchangeRate{Day: day, Currency: code, Rate: scaled, Source: source, FetchedAt: now}, nil
+}
+
+// upsertExchangeRates writes the rates, a rate fetched again replaces the
+// one of its day
+func upsertExchangeRates(ctx context.Context, dbConn *gorm.DB, rates []*exchangeRate) error {
+	if len(rates) == 0 {
+		return nil
+	}
+
+	err := dbConn.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(rates).Error
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
+
+// converter converts the stored dollar prices to a currency at the rate of
+// the day each price was ingested, or of the latest day before it with a
+// rate since rates aren't published every day
+type converter struct {
+	currency string
+	// rates are ordered by day, there are none for the dollar
+	rates []*exchangeRate
+}
+
+// newConverter loads the rates of the currency up to the given time
+func newConverter(ctx context.Context, dbConn *gorm.DB, currency string, to time.Time) (*converter, error) {
+	code, err := parseCurrency(currency)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	c := &converter{currency: code}
+	if code == priceCurrency {
+		return c, nil
+	}
+
+	err = dbConn.WithContext(ctx).Where("currency = ? AND day <= ?", code, to.UTC().Format(dateLayout)).
+		Order("day").Find(&c.rates).Error
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	if len(c.rates) == 0 {
+		return nil, errors.New("no exchange rates of " + code + ", run sync-rates first")
+	}
+
+	return c, nil
+}
+
+// convertSnapshot converts the price and shipping of a snapshot
+func (c *converter) convertSnapshot(row *priceSnapshot) error {
+	price, err := c.convert(row.Price, row.Currency, row.IngestedAt)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	shipping, err := c.convert(row.Shipping, row.Currency, row.IngestedAt)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	row.Price, row.Shipping, row.Currency = price, shipping, c.currency
+	return nil
+}
+
+// convert returns an amount stored in currency and ingested at the given
+// time in the currency of the converter, the rates are of the dollar so
+// only dollar amounts can be converted
+func (c *converter) convert(amount cents, currency string, at time.Time) (cents, error) {
+	if currency != priceCurrency {
+		return 0, errors.New(fmt.Sprintf("unable to convert a price stored in %s, rates are of the %s",
+			currency, priceCurrency))
+	}
+
+	if c.currency == priceCurrency {
+		return amount, nil
+	}
+
+	day := at.UTC().Format(dateLayout)
+	i := sort.Search(len(c.rates), func(i int) bool { return c.rates[i].Day > day })
+	if i == 0 {
+		return 0, errors.New(fmt.Sprintf("no exchange rate of %s on or before %s", c.currency, day))
+	}
+
+	return c.rates[i-1].convert(amount), nil
+}
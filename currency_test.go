@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCents(t *testing.T) {
+	require.Equal(t, cents(1999), toCents(19.99))
+	require.Equal(t, cents(10), toCents(0.1))
+	require.Equal(t, "19.99", cents(1999).String())
+	require.Equal(t, "0.05", cents(5).String())
+	require.Equal(t, "-1.05", cents(-105).String())
+
+	data, err := json.Marshal(cents(1999))
+	require.NoError(t, err)
+	require.Equal(t, "19.99", string(data))
+
+	var c cents
+	require.NoError(t, json.Unmarshal([]byte("-1.05"), &c))
+	require.Equal(t, cents(-105), c)
+	require.Error(t, json.Unmarshal([]byte("1.005"), &c))
+}
+
+func TestExchangeRate_Convert(t *testing.T) {
+	r := &exchangeRate{Rate: 923456}
+
+	// 19.99 * 0.923456 = 18.45988544
+	require.Equal(t, cents(1846), r.convert(1999))
+	require.Equal(t, cents(-1846), r.convert(-1999))
+
+	// half a cent rounds up
+	require.Equal(t, cents(1), (&exchangeRate{Rate: 500000}).convert(1))
+}
+
+func TestScaleRate(t *testing.T) {
+	for s, want := range map[string]int64{"0.92": 920000, "0.9234565": 923457, "149.1": 149100000} {
+		r, err := parseRate(s)
+		require.NoError(t, err)
+		rate, err := scaleRate(r)
+		require.NoError(t, err)
+		require.Equal(t, want, rate, s)
+	}
+
+	for _, s := range []string{"0", "-1", "0.0000001"} {
+		r, err := parseRate(s)
+		require.NoError(t, err)
+		_, err = scaleRate(r)
+		require.Error(t, err, s)
+	}
+
+	_, err := parseRate("one")
+	require.Error(t, err)
+}
+
+func TestParseRatesCSV(t *testing.T) {
+	now := time.Now()
+
+	rates, err := parseRatesCSV(strings.NewReader("day,currency,rate\n2024-01-02,eur,0.91\n2024-01-02,GBP,0.79\n"),
+		"rates.csv", now)
+	require.NoError(t, err)
+	require.Equal(t, []*exchangeRate{
+		{Day: "2024-01-02", Currency: "EUR", Rate: 910000, Source: "rates.csv", FetchedAt: now},
+		{Day: "2024-01-02", Currency: "GBP", Rate: 790000, Source: "rates.csv", FetchedAt: now},
+	}, rates)
+
+	_, err = parseRatesCSV(strings.NewReader("date,currency,rate\n"), "rates.csv", now)
+	require.Error(t, err)
+
+	_, err = parseRatesCSV(strings.NewReader("day,currency,rate\n2024-01-02,EUR,0.91\n2024-01-02,euro,0.91\n"),
+		"rates.csv", now)
+	require.ErrorContains(t, err, "line 3 of the rates csv: invalid currency code: euro")
+}
+
+func TestParseRatesJSON(t *testing.T) {
+	now := time.Now()
+
+	rates, err := parseRatesJSON(strings.NewReader(`{"base":"USD","date":"2024-01-02","rates":{"GBP":0.79,"EUR":0.91}}`),
+		"api", now)
+	require.NoError(t, err)
+	require.Len(t, rates, 2)
+	require.Equal(t, "EUR", rates[0].Currency)
+	require.Equal(t, int64(910000), rates[0].Rate)
+
+	// euro rates are converted through the dollar, the euro itself included
+	rates, err = parseRatesJSON(strings.NewReader(`{"base":"EUR","date":"2024-01-02","rates":{"USD":1.1,"GBP":0.86}}`),
+		"api", now)
+	require.NoError(t, err)
+	require.Len(t, rates, 2)
+	require.Equal(t, "EUR", rates[0].Currency)
+	require.Equal(t, int64(909091), rates[0].Rate)
+	require.Equal(t, "GBP", rates[1].Currency)
+	require.Equal(t, int64(781818), rates[1].Rate)
+
+	_, err = parseRatesJSON(strings.NewReader(`{"base":"EUR","date":"2024-01-02","rates":{"GBP":0.86}}`), "api", now)
+	require.Error(t, err)
+}
+
+func TestFetchRates(t *testing.T) {
+	ctx := context.Background()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"base":"USD","date":"2024-01-02","rates":{"EUR":0.91}}`)
+	}))
+	t.Cleanup(server.Close)
+
+	rates, err := fetchRates(ctx, server.URL, time.Now())
+	require.NoError(t, err)
+	require.Len(t, rates, 1)
+	require.Equal(t, server.URL, rates[0].Source)
+
+	path := filepath.Join(t.TempDir(), "rates.csv")
+	require.NoError(t, os.WriteFile(path, []byte("day,currency,rate\n2024-01-02,EUR,0.91\n"), 0o644))
+	rates, err = fetchRates(ctx, path, time.Now())
+	require.NoError(t, err)
+	require.Len(t, rates, 1)
+}
+
+func TestSQLiteConverter(t *testing.T) {
+	ctx := context.Background()
+
+	dbConn, err := getDBConnection(dbDriverSQLite, "", "", "", "", filepath.Join(t.TempDir(), "dev.db"))
+	require.NoError(t, err)
+	require.NoError(t, migrate(ctx, dbConn))
+	s := newSQLStore(dbConn)
+
+	require.NoError(t, s.UpsertExchangeRates(ctx, []*exchangeRate{
+		{Day: "2024-01-02", Currency: "EUR", Rate: 800000},
+		{Day: "2024-01-05", Currency: "EUR", Rate: 800000},
+	}))
+	// synced again the rate of a day replaces the old one
+	require.NoError(t, s.UpsertExchangeRates(ctx, []*exchangeRate{{Day: "2024-01-02", Currency: "EUR", Rate: 900000}}))
+
+	conv, err := newConverter(ctx, dbConn, "eur", time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	require.Len(t, conv.rates, 2)
+
+	// rates carry over the days without one
+	price, err := conv.convert(1000, priceCurrency, time.Date(2024, 1, 4, 12, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	require.Equal(t, cents(900), price)
+
+	price, err = conv.convert(1000, priceCurrency, time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	require.Equal(t, cents(800), price)
+
+	_, err = conv.convert(1000, priceCurrency, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	require.Error(t, err)
+
+	// the rates are of the dollar, prices stored in another currency aren't
+	// converted
+	_, err = conv.convert(1000, "GBP", time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC))
+	require.Error(t, err)
+
+	_, err = newConverter(ctx, dbConn, "GBP", time.Now())
+	require.Error(t, err)
+
+	conv, err = newConverter(ctx, dbConn, "usd", time.Now())
+	require.NoError(t, err)
+	price, err = conv.convert(1000, priceCurrency, time.Now())
+	require.NoError(t, err)
+	require.Equal(t, cents(1000), price)
+}
+
+func TestLookup_Currency(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+
+	dbConn, err := getDBConnection(dbDriverSQLite, "", "", "", "", filepath.Join(t.TempDir(), "dev.db"))
+	require.NoError(t, err)
+	require.NoError(t, migrate(ctx, dbConn))
+	s := newSQLStore(dbConn)
+	writeRetentionFixture(t, s, now)
+	require.NoError(t, s.InsertPrices(ctx, []skuPrice{{SKUID: 10, PriceCents: 1999, ShippingCents: 100, IngestedAt: now}}))
+	require.NoError(t, s.UpsertExchangeRates(ctx, []*exchangeRate{
+		{Day: now.AddDate(0, 0, -30).Format(dateLayout), Currency: "GBP", Rate: 790000},
+	}))
+
+	skus, err := lookup(ctx, dbConn, lookupQuery{name: "test", group: "test-1", currency: "gbp", limit: 1},
+		now.Add(-time.Hour))
+	require.NoError(t, err)
+	require.Len(t, skus, 1)
+	require.Equal(t, "GBP", skus[0].Currency)
+	// 19.99 * 0.79 = 15.7921
+	require.Equal(t, cents(1579), skus[0].Current.Price)
+	require.Equal(t, cents(79), skus[0].Current.Shipping)
+
+	_, err = lookup(ctx, dbConn, lookupQuery{name: "test", currency: "EUR", limit: 1}, now.Add(-time.Hour))
+	require.Error(t, err)
+}
+
+func TestMemoryStore_UpsertExchangeRates(t *testing.T) {
+	ctx := context.Background()
+	s := newMemoryStore()
+
+	require.NoError(t, s.UpsertExchangeRates(ctx, []*exchangeRate{
+		{Day: "2024-01-02", Currency: "EUR", Rate: 800000},
+		{Day: "2024-01-02", Currency: "GBP", Rate: 790000},
+	}))
+	require.NoError(t, s.UpsertExchangeRates(ctx, []*exchangeRate{{Day: "2024-01-02", Currency: "EUR", Rate: 900000}}))
+
+	require.Len(t, s.rates, 2)
+	require.Equal(t, int64(900000), s.rates["2024-01-02 EUR"].Rate)
+}
@@ -0,0 +1,466 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	errors "github.com/AustinMCrane/errorutil"
+)
+
+const (
+	commandDaemon = "daemon"
+
+	// jobIngestWatchlist is the daemon job refreshing the watchlist, its
+	// runs are recorded under this name to tell them from full sweeps
+	jobIngestWatchlist = "ingest-watchlist"
+
+	// readyTimeout is how long the readiness probe waits for the database
+	readyTimeout = time.Second * 2
+)
+
+// daemonJob is a command the daemon runs every interval
+type daemonJob struct {
+	name  string
+	every time.Duration
+	run   func(ctx context.Context, runID string) error
+}
+
+// jobRun is how a run of a job went
+type jobRun struct {
+	RunID      string    `json:"run_id"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	OK         bool      `json:"ok"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// jobStatus is the last run and the next scheduled run of a job
+type jobStatus struct {
+	Name      string    `json:"name"`
+	Every     string    `json:"every"`
+	LastRun   *jobRun   `json:"last_run,omitempty"`
+	NextRunAt time.Time `json:"next_run_at"`
+}
+
+// jobProgress is how far the running job is, e.g. price batch 3 of 40
+type jobProgress struct {
+	Stage string `json:"stage"`
+	Step  int    `json:"step"`
+	Steps int    `json:"steps"`
+}
+
+// currentJob is the job the daemon is running
+type currentJob struct {
+	Name      string       `json:"name"`
+	RunID     string       `json:"run_id"`
+	StartedAt time.Time    `json:"started_at"`
+	Progress  *jobProgress `json:"progress,omitempty"`
+}
+
+// daemonStatus is the body of /status, Current is null between jobs
+type daemonStatus struct {
+	StartedAt time.Time    `json:"started_at"`
+	Current   *currentJob  `json:"current"`
+	Jobs      []*jobStatus `json:"jobs"`
+}
+
+// daemon runs the jobs one at a time, each when its interval has passed
+// since it last started, and keeps what /status and /readyz report
+type daemon struct {
+	db         *gorm.DB
+	jobs       []*daemonJob
+	jobTimeout time.Duration
+
+	// authenticate creates a tcgplayer client, the readiness probe reports
+	// whether the last attempt succeeded
+	authenticate func() (Tcgplayer, error)
+
+	mu        sync.Mutex
+	startedAt time.Time
+	status    []*jobStatus
+	current   *currentJob
+	authErr   error
+	authed    bool
+}
+
+// ExecDaemon is the entry point of the daemon command, it runs the jobs on
+// their schedules and serves the health endpoints, and the api unless
+// disabled, until ctx is done
+func ExecDaemon(ctx context.Context, args []string) error {
+	fs := newCommandFlagSet(commandDaemon)
+	addr := fs.String("addr", ":8080", "address the health endpoints and the api listen on")
+	serveAPI := fs.Bool("api", true, "serve the read only api next to the health endpoints")
+	catalogEvery := fs.Duration("catalog-every", time.Hour*24, "how often the catalog is synced, never when 0")
+	pricesEvery := fs.Duration("prices-every", time.Hour*6, "how often prices are ingested, never when 0")
+	watchlistEvery := fs.Duration("watchlist-every", 0,
+		"how often the watchlist prices are refreshed, never when 0")
+	trimEvery := fs.Duration("trim-every", time.Hour*24, "how often old prices are trimmed, never when 0")
+	priceBudget := fs.Int("price-budget", 0, "how many price requests each price run may make, "+
+		"every sku is fetched when 0")
+	retentionConfig := fs.String("retention-config", "",
+		fmt.Sprintf("json file with the price retention rules, prices are kept %d days without one",
+			defaultRetentionDays))
+	pullRatesConfig := fs.String("pull-rates", "", "json file of the cards of each rarity a pack holds, "+
+		"weights the set indexes, they are plain set values without one")
+	ratesSource := fs.String("rates-source", "", "url of a json rates endpoint or path of a csv file "+
+		"exchange rates are synced from, rates aren't synced when empty")
+	ratesEvery := fs.Duration("rates-every", time.Hour*24, "how often exchange rates are synced")
+	jobTimeout := fs.Duration("job-timeout", 0, "deadline of each job run, 0 means no deadline")
+	err := fs.Parse(args)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	// every job opens the store again, in memory it would start empty
+	if *dryRun {
+		return errors.New("the daemon can't run with -dry-run")
+	}
+
+	policy, err := loadRetentionPolicy(*retentionConfig)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	rates, err := loadPullRates(*pullRatesConfig)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	dbConn, err := openDB(ctx, commandDaemon)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+	defer closeDB(dbConn)
+
+	d := &daemon{
+		db:         dbConn,
+		jobTimeout: *jobTimeout,
+		authenticate: func() (Tcgplayer, error) {
+			return newTcgplayerClient(*publicKey, *privateKey)
+		},
+	}
+
+	prices := priceOptions{
+		budget:            *priceBudget,
+		discoverProducts:  true,
+		flush:             flushPolicy{size: 5000, interval: time.Second * 10},
+		partitionInterval: partitionDay,
+		partitionsAhead:   3,
+		pullRates:         rates,
+	}
+	watchlist := prices
+	watchlist.watchlist = true
+
+	if *ratesSource == "" {
+		*ratesEvery = 0
+	}
+
+	d.jobs = []*daemonJob{
+		{name: commandSyncCatalog, every: *catalogEvery, run: d.clientJob(commandSyncCatalog,
+			func(ctx context.Context) clientFunc { return syncCatalog(ctx, catalogOptions{}) })},
+		{name: commandIngestPrices, every: *pricesEvery, run: d.clientJob(commandIngestPrices,
+			func(ctx context.Context) clientFunc { return ingestPrices(ctx, prices) })},
+		{name: jobIngestWatchlist, every: *watchlistEvery, run: d.clientJob(jobIngestWatchlist,
+			func(ctx context.Context) clientFunc { return ingestPrices(ctx, watchlist) })},
+		{name: commandTrim, every: *trimEvery, run: func(ctx context.Context, runID string) error {
+			return runCommand(ctx, runID, commandTrim, func(s Store, events *eventPublisher) error {
+				return trimOldPriceData(ctx, s, policy)
+			})
+		}},
+		{name: commandSyncRates, every: *ratesEvery, run: func(ctx context.Context, runID string) error {
+			return runCommand(ctx, runID, commandSyncRates, syncRates(ctx, *ratesSource))
+		}},
+	}
+
+	err = d.schedule(ctx, time.Now())
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	handler := http.NewServeMux()
+	if *serveAPI {
+		handler = newAPIHandler(dbConn)
+	}
+	d.registerHandlers(handler)
+
+	// the loop is stopped with the server, even when it fails to listen,
+	// and waited for so a running job gets to write what it fetched
+	loopCtx, stop := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		d.loop(loopCtx)
+	}()
+
+	err = serve(ctx, *addr, handler)
+	stop()
+	<-done
+
+	return err
+}
+
+// clientJob returns the run of a job needing a tcgplayer client, the client
+// comes from connect so its authentication is reported by /readyz
+func (d *daemon) clientJob(name string, work func(ctx context.Context) clientFunc) func(context.Context, string) error {
+	return func(ctx context.Context, runID string) error {
+		return runClientCommand(ctx, runID, name, d.connect, work(ctx))
+	}
+}
+
+// connect authenticates with tcgplayer for a run and records the outcome
+func (d *daemon) connect(ctx context.Context, runID string) (Tcgplayer, func(), error) {
+	client, err := d.authenticate()
+	d.recordAuth(err)
+	if err != nil {
+		return nil, nil, errors.Wrap(err)
+	}
+
+	return archiveClient(ctx, client, runID)
+}
+
+func (d *daemon) recordAuth(err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.authed = true
+	d.authErr = err
+}
+
+// schedule drops the disabled jobs and schedules the others from their last
+// recorded run, so a restart doesn't run every job again right away
+func (d *daemon) schedule(ctx context.Context, now time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.startedAt = now
+	enabled := []*daemonJob{}
+	d.status = []*jobStatus{}
+	for _, job := range d.jobs {
+		if job.every <= 0 {
+			continue
+		}
+
+		runs, err := listRuns(ctx, d.db, job.name, 1)
+		if err != nil {
+			return errors.Wrap(err)
+		}
+
+		status := &jobStatus{Name: job.name, Every: job.every.String(), NextRunAt: now}
+		if len(runs) > 0 {
+			last := runs[0]
+			status.LastRun = &jobRun{
+				RunID:      last.RunID,
+				StartedAt:  last.StartedAt,
+				FinishedAt: last.FinishedAt,
+				OK:         last.OK,
+			}
+			status.LastRun.Error = last.Error
+			status.NextRunAt = nextRunAt(last.StartedAt, job.every, now)
+		}
+
+		enabled = append(enabled, job)
+		d.status = append(d.status, status)
+	}
+	d.jobs = enabled
+
+	return nil
+}
+
+// nextRunAt is an interval after the last start, or now when that already
+// passed
+func nextRunAt(lastStart time.Time, every time.Duration, now time.Time) time.Time {
+	next := lastStart.Add(every)
+	if next.Before(now) {
+		return now
+	}
+
+	return next
+}
+
+// nextJob returns the index of the job due first, the earlier job on a tie
+func (d *daemon) nextJob() (int, time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	next := 0
+	for i, status := range d.status {
+		if status.NextRunAt.Before(d.status[next].NextRunAt) {
+			next = i
+		}
+	}
+
+	return next, d.status[next].NextRunAt
+}
+
+// loop runs the jobs as they come due until ctx is done
+func (d *daemon) loop(ctx context.Context) {
+	// authenticate once up front so readiness doesn't wait for the first
+	// job using the client
+	_, err := d.authenticate()
+	d.recordAuth(err)
+	if err != nil {
+		slog.Error("unable to authenticate with tcgplayer", "error", err)
+	}
+
+	if len(d.jobs) == 0 {
+		slog.Warn("every daemon job is disabled")
+		return
+	}
+
+	for {
+		i, at := d.nextJob()
+		err := sleepContext(ctx, time.Until(at))
+		if err != nil {
+			return
+		}
+
+		d.runJob(ctx, i)
+	}
+}
+
+// runJob runs the i-th job and schedules its next run, a failing job is
+// logged and retried at its next run
+func (d *daemon) runJob(ctx context.Context, i int) {
+	job := d.jobs[i]
+	runID := newRunID()
+	start := time.Now()
+
+	d.mu.Lock()
+	d.current = &currentJob{Name: job.name, RunID: runID, StartedAt: start}
+	d.mu.Unlock()
+
+	jobCtx := withProgress(ctx, d.setProgress)
+	if d.jobTimeout > 0 {
+		var cancel context.CancelFunc
+		jobCtx, cancel = context.WithTimeout(jobCtx, d.jobTimeout)
+		defer cancel()
+	}
+
+	slog.Info("running job", "job", job.name, "job_run_id", runID)
+	err := job.run(jobCtx, runID)
+	if err != nil {
+		slog.Error("job failed", "job", job.name, "job_run_id", runID, "error", err)
+	}
+
+	run := &jobRun{RunID: runID, StartedAt: start, FinishedAt: time.Now(), OK: err == nil}
+	if err != nil {
+		run.Error = errorMessage(err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.current = nil
+	d.status[i].LastRun = run
+	d.status[i].NextRunAt = nextRunAt(start, job.every, run.FinishedAt)
+}
+
+// setProgress records the progress of the running job
+func (d *daemon) setProgress(stage string, step int, steps int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.current != nil {
+		d.current.Progress = &jobProgress{Stage: stage, Step: step, Steps: steps}
+	}
+}
+
+// snapshot returns a copy of the status safe to encode without the lock
+func (d *daemon) snapshot() *daemonStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	status := &daemonStatus{StartedAt: d.startedAt, Jobs: []*jobStatus{}}
+	if d.current != nil {
+		current := *d.current
+		if current.Progress != nil {
+			progress := *current.Progress
+			current.Progress = &progress
+		}
+		status.Current = &current
+	}
+
+	for _, s := range d.status {
+		job := *s
+		if job.LastRun != nil {
+			last := *job.LastRun
+			job.LastRun = &last
+		}
+		status.Jobs = append(status.Jobs, &job)
+	}
+
+	return status
+}
+
+// registerHandlers adds the health endpoints
+//
+//	GET /healthz   the process is up
+//	GET /readyz    the database is reachable and tcgplayer auth succeeded
+//	GET /status    the last and next run of every job and the running job
+func (d *daemon) registerHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	mux.HandleFunc("/readyz", d.ready)
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, d.snapshot())
+	})
+}
+
+// ready answers 200 when the database answers a ping and the last tcgplayer
+// authentication succeeded, 503 with what failed otherwise
+func (d *daemon) ready(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]string{"database": "ok", "tcgplayer": "ok"}
+	ok := true
+
+	ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+	defer cancel()
+
+	sqlDB, err := d.db.DB()
+	if err == nil {
+		err = sqlDB.PingContext(ctx)
+	}
+	if err != nil {
+		checks["database"] = errorMessage(err)
+		ok = false
+	}
+
+	d.mu.Lock()
+	switch {
+	case !d.authed:
+		checks["tcgplayer"] = "not authenticated yet"
+		ok = false
+	case d.authErr != nil:
+		checks["tcgplayer"] = errorMessage(d.authErr)
+		ok = false
+	}
+	d.mu.Unlock()
+
+	status := http.StatusOK
+	if !ok {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, checks)
+}
+
+type progressKey struct{}
+
+// withProgress returns a ctx the work of a job reports its progress through
+func withProgress(ctx context.Context, report func(stage string, step int, steps int)) context.Context {
+	return context.WithValue(ctx, progressKey{}, report)
+}
+
+// reportProgress reports that the work is at step of steps of a stage, it
+// does nothing outside of the daemon
+func reportProgress(ctx context.Context, stage string, step int, steps int) {
+	report, ok := ctx.Value(progressKey{}).(func(string, int, int))
+	if ok {
+		report(stage, step, steps)
+	}
+}
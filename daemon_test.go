@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	errors "github.com/AustinMCrane/errorutil"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestDaemon(t *testing.T, jobs ...*daemonJob) *daemon {
+	ctx := context.Background()
+
+	dbConn, err := getDBConnection(dbDriverSQLite, "", "", "", "", filepath.Join(t.TempDir(), "dev.db"))
+	require.NoError(t, err)
+	require.NoError(t, migrate(ctx, dbConn))
+
+	return &daemon{
+		db:   dbConn,
+		jobs: jobs,
+		authenticate: func() (Tcgplayer, error) {
+			return nil, nil
+		},
+	}
+}
+
+func TestDaemon_Schedule(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+
+	d := newTestDaemon(t,
+		&daemonJob{name: commandSyncCatalog, every: time.Hour * 24},
+		&daemonJob{name: commandIngestPrices, every: time.Hour * 6},
+		&daemonJob{name: jobIngestWatchlist},
+		&daemonJob{name: commandTrim, every: time.Hour * 24},
+	)
+
+	// the catalog synced two hours ago is due in 22 hours, the prices that
+	// failed a day ago are overdue
+	require.NoError(t, recordRun(ctx, d.db, &ingestRun{RunID: "a", Command: commandSyncCatalog,
+		StartedAt: now.Add(-time.Hour * 2), FinishedAt: now.Add(-time.Hour), OK: true}))
+	require.NoError(t, recordRun(ctx, d.db, &ingestRun{RunID: "b", Command: commandIngestPrices,
+		StartedAt: now.Add(-time.Hour * 24), FinishedAt: now.Add(-time.Hour * 23),
+		Error: "unable to fetch prices"}))
+
+	require.NoError(t, d.schedule(ctx, now))
+
+	// the disabled watchlist job is dropped
+	status := d.snapshot()
+	require.Len(t, status.Jobs, 3)
+	require.Equal(t, commandSyncCatalog, status.Jobs[0].Name)
+	require.True(t, now.Add(time.Hour*22).Equal(status.Jobs[0].NextRunAt))
+	require.True(t, status.Jobs[0].LastRun.OK)
+
+	require.Equal(t, "b", status.Jobs[1].LastRun.RunID)
+	require.Equal(t, "unable to fetch prices", status.Jobs[1].LastRun.Error)
+	require.True(t, now.Equal(status.Jobs[1].NextRunAt))
+
+	require.Nil(t, status.Jobs[2].LastRun)
+	require.True(t, now.Equal(status.Jobs[2].NextRunAt))
+
+	// the overdue prices run first, the trim never ran but comes later
+	i, at := d.nextJob()
+	require.Equal(t, 1, i)
+	require.True(t, now.Equal(at))
+}
+
+func TestDaemon_RunJob(t *testing.T) {
+	ctx := context.Background()
+
+	var during *daemonStatus
+	job := &daemonJob{name: commandIngestPrices, every: time.Hour}
+	d := newTestDaemon(t, job)
+	job.run = func(ctx context.Context, runID string) error {
+		reportProgress(ctx, "price batch", 3, 40)
+		during = d.snapshot()
+		return errors.New("unable to fetch prices")
+	}
+	require.NoError(t, d.schedule(ctx, time.Now()))
+
+	d.runJob(ctx, 0)
+
+	require.Equal(t, commandIngestPrices, during.Current.Name)
+	require.Equal(t, &jobProgress{Stage: "price batch", Step: 3, Steps: 40}, during.Current.Progress)
+
+	status := d.snapshot()
+	require.Nil(t, status.Current)
+	last := status.Jobs[0].LastRun
+	require.False(t, last.OK)
+	require.Equal(t, during.Current.RunID, last.RunID)
+	require.Equal(t, "unable to fetch prices", last.Error)
+	require.True(t, last.StartedAt.Add(time.Hour).Equal(status.Jobs[0].NextRunAt))
+
+	// progress outside of a job is ignored
+	reportProgress(ctx, "price batch", 1, 1)
+}
+
+func TestDaemon_Endpoints(t *testing.T) {
+	d := newTestDaemon(t, &daemonJob{name: commandTrim, every: time.Hour})
+	require.NoError(t, d.schedule(context.Background(), time.Now()))
+
+	mux := http.NewServeMux()
+	d.registerHandlers(mux)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	require.Equal(t, http.StatusOK, getJSON(t, server, "/healthz", nil).StatusCode)
+
+	// not ready until tcgplayer auth succeeded
+	checks := map[string]string{}
+	resp := getJSON(t, server, "/readyz", &checks)
+	require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	require.Equal(t, "ok", checks["database"])
+	require.Equal(t, "not authenticated yet", checks["tcgplayer"])
+
+	d.recordAuth(errors.New("invalid keys"))
+	resp = getJSON(t, server, "/readyz", &checks)
+	require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	require.Equal(t, "invalid keys", checks["tcgplayer"])
+
+	d.recordAuth(nil)
+	require.Equal(t, http.StatusOK, getJSON(t, server, "/readyz", nil).StatusCode)
+
+	status := daemonStatus{}
+	resp = getJSON(t, server, "/status", &status)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Nil(t, status.Current)
+	require.Len(t, status.Jobs, 1)
+	require.Equal(t, "1h0m0s", status.Jobs[0].Every)
+
+	// a database that went away makes it unready
+	sqlDB, err := d.db.DB()
+	require.NoError(t, err)
+	require.NoError(t, sqlDB.Close())
+	require.Equal(t, http.StatusServiceUnavailable, getJSON(t, server, "/readyz", nil).StatusCode)
+}
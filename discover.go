@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	errors "github.com/AustinMCrane/errorutil"
+	"github.com/AustinMCrane/tcgplayer"
+)
+
+// discoverNewProducts looks for products that aren't in the catalog yet in
+// the groups added or modified since they were last synced and inserts them
+// with their skus, so new releases get prices without waiting for a catalog
+// sync, it returns how many products were added
+func discoverNewProducts(ctx context.Context, s Store, client Tcgplayer, events *eventPublisher,
+	categoryID int) (int, error) {
+	groups, err := getGroups(ctx, client, categoryID)
+	if err != nil {
+		return 0, errors.Wrap(err)
+	}
+
+	ids := []int{}
+	for _, g := range groups {
+		ids = append(ids, g.ID)
+	}
+
+	synced, err := s.SyncedGroups(ctx, ids)
+	if err != nil {
+		return 0, errors.Wrap(err)
+	}
+
+	// only the groups that were never synced or whose publishedOn changed
+	// since are crawled, a group recorded by the migration baseline has no
+	// publishedOn yet so it takes the current one without being crawled
+	changed := []*tcgplayer.Group{}
+	baseline := []*tcgplayer.Group{}
+	for _, g := range groups {
+		publishedOn, ok := synced[g.ID]
+		switch {
+		case ok && publishedOn == "":
+			baseline = append(baseline, g)
+		case !ok || publishedOn != g.PublishedOn:
+			changed = append(changed, g)
+		}
+	}
+
+	if len(baseline) > 0 {
+		err := s.MarkGroupsSynced(ctx, baseline)
+		if err != nil {
+			return 0, errors.Wrap(err)
+		}
+	}
+
+	if len(changed) == 0 {
+		return 0, nil
+	}
+
+	// the rarities, printings, conditions and languages are written with
+	// the products so their skus resolve on a database that was never
+	// synced, a group that can't be crawled is tried again on the next run
+	c, err := fetchCatalog(ctx, client, categoryID, changed, changed)
+	if err != nil {
+		return 0, errors.Wrap(err)
+	}
+
+	ids = []int{}
+	for _, p := range c.products {
+		ids = append(ids, p.ID)
+	}
+
+	knownProductIDs, err := s.KnownProductIDs(ctx, ids)
+	if err != nil {
+		return 0, errors.Wrap(err)
+	}
+
+	newProducts := []*tcgplayer.Product{}
+	for _, p := range c.products {
+		if containsID(knownProductIDs, p.ID) {
+			continue
+		}
+
+		skus, err := client.ListProductSKUs(ctx, p.ID)
+		if err != nil {
+			return 0, errors.Wrap(err)
+		}
+
+		p.SKUS = []tcgplayer.SKU{}
+		for _, s := range skus {
+			p.SKUS = append(p.SKUS, *s)
+		}
+		newProducts = append(newProducts, p)
+
+		err = sleepContext(ctx, time.Millisecond*100)
+		if err != nil {
+			return 0, errors.Wrap(err)
+		}
+	}
+
+	// the products of a crawled group replace the stored ones like a catalog
+	// sync would, so the group counts as synced and isn't crawled again
+	// until it is modified
+	err = s.Transaction(ctx, func(tx Store) error {
+		_, err := tx.UpsertGroups(ctx, c.groups)
+		if err != nil {
+			return errors.Wrap(err)
+		}
+
+		err = tx.UpsertRarities(ctx, c.rarities)
+		if err != nil {
+			return errors.Wrap(err)
+		}
+
+		err = tx.UpsertPrintings(ctx, c.printings)
+		if err != nil {
+			return errors.Wrap(err)
+		}
+
+		err = tx.UpsertConditions(ctx, c.conditions)
+		if err != nil {
+			return errors.Wrap(err)
+		}
+
+		err = tx.UpsertLanguages(ctx, c.languages)
+		if err != nil {
+			return errors.Wrap(err)
+		}
+
+		if len(c.crawled) == 0 {
+			return nil
+		}
+
+		crawledIDs := []int{}
+		for _, g := range c.crawled {
+			crawledIDs = append(crawledIDs, g.ID)
+		}
+
+		_, err = tx.UpsertProducts(ctx, crawledIDs, c.products)
+		if err != nil {
+			return errors.Wrap(err)
+		}
+		return tx.MarkGroupsSynced(ctx, c.crawled)
+	})
+	if err != nil {
+		return 0, errors.Wrap(err)
+	}
+
+	for _, p := range newProducts {
+		events.publish(ctx, eventProductAdded, productAddedEvent{
+			ProductID: p.ID,
+			GroupID:   p.GroupID,
+			Name:      p.Name,
+		})
+	}
+
+	slog.Info("discovered new products", "category", categoryID, "groups", len(c.crawled),
+		"failed", len(c.failed), "products", len(newProducts))
+	return len(newProducts), nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/AustinMCrane/tcgplayer"
+	gomock "github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiscoverNewProducts(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	client := NewMockTcgplayer(ctrl)
+	s := newMemoryStore()
+
+	_, err := s.UpsertProducts(ctx, nil, []*tcgplayer.Product{{ID: 1, GroupID: 1}})
+	require.NoError(t, err)
+	require.NoError(t, s.MarkGroupsSynced(ctx, []*tcgplayer.Group{
+		{ID: 2, PublishedOn: "2020-01-01T00:00:00"},
+		{ID: 3, PublishedOn: "2020-01-01T00:00:00"},
+		// recorded by the migration baseline
+		{ID: 4, PublishedOn: ""},
+	}))
+
+	groups := []*tcgplayer.Group{
+		{ID: 1, Name: "new-set", PublishedOn: "2023-03-10T00:00:00"},
+		{ID: 2, Name: "old-set", PublishedOn: "2020-01-01T00:00:00"},
+		{ID: 3, Name: "modified-set", PublishedOn: "2023-03-12T00:00:00"},
+		{ID: 4, Name: "baseline-set", PublishedOn: "2019-05-01T00:00:00"},
+	}
+	client.EXPECT().GetGroups(gomock.Any(), gomock.Any()).Return(groups, nil).Times(2)
+
+	// the reference data is fetched once, the second run has nothing to
+	// crawl
+	client.EXPECT().GetRarities(gomock.Any(), gomock.Any()).
+		Return([]*tcgplayer.Rarity{{ID: 1, Name: "Common"}}, nil)
+	client.EXPECT().GetPrinting(gomock.Any(), gomock.Any()).
+		Return([]*tcgplayer.Printing{{ID: 1, Name: "1st Edition"}}, nil)
+	client.EXPECT().GetConditions(gomock.Any(), gomock.Any()).
+		Return([]*tcgplayer.Condition{{ID: 1, Name: "Near Mint"}}, nil)
+	client.EXPECT().GetLanguages(gomock.Any(), gomock.Any()).
+		Return([]*tcgplayer.Language{{ID: 1, Name: "English"}}, nil)
+
+	// the group that was never synced and the modified one are listed once,
+	// the group unchanged since its sync and the baseline one aren't,
+	// product 1 is already known
+	client.EXPECT().ListAllProducts(gomock.Any(), tcgplayer.ProductParams{
+		CategoryID: tcgplayer.CategoryYugioh,
+		GroupName:  "new-set",
+		Limit:      100,
+	}).Return([]*tcgplayer.Product{
+		{ID: 1, GroupID: 1, CategoryID: tcgplayer.CategoryYugioh, CleanName: "known"},
+		{ID: 2, GroupID: 1, CategoryID: tcgplayer.CategoryYugioh, CleanName: "new-card"},
+	}, nil)
+	client.EXPECT().ListAllProducts(gomock.Any(), tcgplayer.ProductParams{
+		CategoryID: tcgplayer.CategoryYugioh,
+		GroupName:  "modified-set",
+		Limit:      100,
+	}).Return([]*tcgplayer.Product{}, nil)
+	client.EXPECT().ListProductSKUs(gomock.Any(), 2).
+		Return([]*tcgplayer.SKU{{SKUID: 20, ProductID: 2, LanguageID: 1, PrintingID: 1, ConditionID: 1}}, nil)
+
+	added, err := discoverNewProducts(ctx, s, client, nil, tcgplayer.CategoryYugioh)
+	require.NoError(t, err)
+	require.Equal(t, 1, added)
+
+	// every crawled group counts as synced and the baseline one takes its
+	// current publishedOn
+	synced, err := s.SyncedGroups(ctx, []int{1, 3, 4})
+	require.NoError(t, err)
+	require.Equal(t, map[int]string{
+		1: "2023-03-10T00:00:00",
+		3: "2023-03-12T00:00:00",
+		4: "2019-05-01T00:00:00",
+	}, synced)
+
+	added, err = discoverNewProducts(ctx, s, client, nil, tcgplayer.CategoryYugioh)
+	require.NoError(t, err)
+	require.Equal(t, 0, added)
+
+	skus, err := s.ListSKUIDs(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []int{20}, skus)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"gocloud.dev/pubsub"
+
+	errors "github.com/AustinMCrane/errorutil"
+
+	// pubsub drivers supported by the topic urls
+	_ "gocloud.dev/pubsub/awssnssqs"
+	_ "gocloud.dev/pubsub/gcppubsub"
+	_ "gocloud.dev/pubsub/mempubsub"
+)
+
+const (
+	eventGroupAdded       = "catalog.group_added"
+	eventProductAdded     = "catalog.product_added"
+	eventPriceBatchIngest = "price.batch_ingested"
+	eventRunCompleted     = "run.completed"
+
+	eventMetadataTypeKey  = "type"
+	eventMetadataRunIDKey = "run_id"
+)
+
+// event is the json body of every published message
+type event struct {
+	Type  string      `json:"type"`
+	RunID string      `json:"run_id"`
+	Time  time.Time   `json:"time"`
+	Data  interface{} `json:"data"`
+}
+
+type groupAddedEvent struct {
+	GroupID    int    `json:"group_id"`
+	CategoryID int    `json:"category_id"`
+	Name       string `json:"name"`
+}
+
+type productAddedEvent struct {
+	ProductID int    `json:"product_id"`
+	GroupID   int    `json:"group_id"`
+	Name      string `json:"name"`
+}
+
+type priceBatchIngestedEvent struct {
+	Batch   int `json:"batch"`
+	Batches int `json:"batches"`
+	Prices  int `json:"prices"`
+}
+
+type runCompletedEvent struct {
+	Command         string  `json:"command"`
+	OK              bool    `json:"ok"`
+	Error           string  `json:"error,omitempty"`
+	DurationSeconds float64 `json:"duration_seconds"`
+}
+
+// eventPublisher publishes ingestion events to a topic, a nil publisher
+// drops every event so callers don't have to check if events are enabled
+type eventPublisher struct {
+	topic *pubsub.Topic
+	runID string
+}
+
+// newEventPublisher opens the topic url, an empty url disables events
+func newEventPublisher(ctx context.Context, topicURL string, runID string) (*eventPublisher, error) {
+	if topicURL == "" {
+		return nil, nil
+	}
+
+	topic, err := pubsub.OpenTopic(ctx, topicURL)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	return &eventPublisher{topic: topic, runID: runID}, nil
+}
+
+// publish sends an event, the data it describes is already committed so a
+// failure is logged rather than failing the run
+func (p *eventPublisher) publish(ctx context.Context, eventType string, data interface{}) {
+	if p == nil {
+		return
+	}
+
+	body, err := json.Marshal(event{
+		Type:  eventType,
+		RunID: p.runID,
+		Time:  time.Now().UTC(),
+		Data:  data,
+	})
+	if err != nil {
+		slog.Error("unable to encode event", "type", eventType, "error", err)
+		return
+	}
+
+	err = p.topic.Send(ctx, &pubsub.Message{
+		Body: body,
+		Metadata: map[string]string{
+			eventMetadataTypeKey:  eventType,
+			eventMetadataRunIDKey: p.runID,
+		},
+	})
+	if err != nil {
+		slog.Error("unable to publish event", "type", eventType, "error", err)
+	}
+}
+
+// Shutdown flushes the pending events and closes the topic
+func (p *eventPublisher) Shutdown(ctx context.Context) error {
+	if p == nil {
+		return nil
+	}
+
+	err := p.topic.Shutdown(ctx)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/AustinMCrane/tcgplayer"
+	"github.com/DATA-DOG/go-sqlmock"
+	gomock "github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"gocloud.dev/pubsub"
+)
+
+func TestEventPublisher_Nil(t *testing.T) {
+	events, err := newEventPublisher(context.Background(), "", "test-run")
+	require.NoError(t, err)
+	require.Nil(t, events)
+
+	// a disabled publisher drops events
+	events.publish(context.Background(), eventRunCompleted, runCompletedEvent{OK: true})
+	require.NoError(t, events.Shutdown(context.Background()))
+}
+
+func TestIngestPrice_PublishesBatchEvent(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	client := NewMockTcgplayer(ctrl)
+	dbConn, mock := GetMockDB(t)
+
+	events, err := newEventPublisher(ctx, "mem://price-events", "test-run")
+	require.NoError(t, err)
+	sub, err := pubsub.OpenSubscription(ctx, "mem://price-events")
+	require.NoError(t, err)
+	defer sub.Shutdown(ctx)
+
+	mock.ExpectQuery("SELECT \"tcgplayer_id\" FROM \"skus\"").
+		WillReturnRows(sqlmock.NewRows([]string{"tcgplayer_id"}).AddRow(1))
+	client.EXPECT().GetSKUPrices(gomock.Any(), []int{1}).
+		Return([]*tcgplayer.SKUMarketPrice{{SKUID: 1, LowPrice: 1.0}}, nil)
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO \"sku_prices\" (.+)").
+		WillReturnRows(sqlmock.NewRows([]string{"ingested_at", "id"}).AddRow(time.Now(), 1))
+	mock.ExpectCommit()
+
+	err = ingetPrices(ctx, newSQLStore(dbConn), client, events, 0, testFlushPolicy)
+	require.NoError(t, err)
+	require.NoError(t, events.Shutdown(ctx))
+
+	msg, err := sub.Receive(ctx)
+	require.NoError(t, err)
+	msg.Ack()
+	require.Equal(t, eventPriceBatchIngest, msg.Metadata[eventMetadataTypeKey])
+
+	e := struct {
+		RunID string                  `json:"run_id"`
+		Data  priceBatchIngestedEvent `json:"data"`
+	}{}
+	require.NoError(t, json.Unmarshal(msg.Body, &e))
+	require.Equal(t, "test-run", e.RunID)
+	require.Equal(t, priceBatchIngestedEvent{Batch: 0, Batches: 1, Prices: 1}, e.Data)
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xitongsys/parquet-go/writer"
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/fileblob"
+	"gorm.io/gorm"
+
+	errors "github.com/AustinMCrane/errorutil"
+
+	// blob drivers supported by the bucket urls
+	_ "gocloud.dev/blob/gcsblob"
+	_ "gocloud.dev/blob/memblob"
+	_ "gocloud.dev/blob/s3blob"
+)
+
+const (
+	commandExport = "export"
+
+	exportFormatCSV     = "csv"
+	exportFormatJSONL   = "jsonl"
+	exportFormatParquet = "parquet"
+
+	exportDataPrices   = "prices"
+	exportDataSetIndex = "set-index"
+
+	dateLayout = "2006-01-02"
+)
+
+// priceSnapshot is a single price joined with the catalog data describing
+// the sku it belongs to
+type priceSnapshot struct {
+	IngestedAt time.Time `json:"ingested_at"`
+	SKUID      int       `json:"sku_id" gorm:"column:sku_id"`
+	ProductID  int       `json:"product_id"`
+	Name       string    `json:"name"`
+	Group      string    `json:"group"`
+	Rarity     string    `json:"rarity"`
+	Condition  string    `json:"condition"`
+	Printing   string    `json:"printing"`
+	Price      cents     `json:"price"`
+	Shipping   cents     `json:"shipping"`
+	Currency   string    `json:"currency"`
+}
+
+// parquetPriceSnapshot is the parquet schema of a priceSnapshot
+type parquetPriceSnapshot struct {
+	IngestedAt int64  `parquet:"name=ingested_at, type=INT64, convertedtype=TIMESTAMP_MILLIS"`
+	SKUID      int64  `parquet:"name=sku_id, type=INT64"`
+	ProductID  int64  `parquet:"name=product_id, type=INT64"`
+	Name       string `parquet:"name=name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Group      string `parquet:"name=group, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Rarity     string `parquet:"name=rarity, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Condition  string `parquet:"name=condition, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Printing   string `parquet:"name=printing, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Price      int64  `parquet:"name=price, type=INT64, convertedtype=DECIMAL, scale=2, precision=18"`
+	Shipping   int64  `parquet:"name=shipping, type=INT64, convertedtype=DECIMAL, scale=2, precision=18"`
+	Currency   string `parquet:"name=currency, type=BYTE_ARRAY, convertedtype=UTF8"`
+}
+
+var priceSnapshotHeader = []string{
+	"ingested_at", "sku_id", "product_id", "name", "group", "rarity",
+	"condition", "printing", "price", "shipping", "currency",
+}
+
+// snapshotWriter writes price snapshots in a file format
+type snapshotWriter interface {
+	Write(row *priceSnapshot) error
+	Close() error
+}
+
+// ExecExport is the entry point of the export command, it writes the prices
+// ingested in a date range to a file in a local directory or a bucket
+func ExecExport(ctx context.Context, args []string) error {
+	fs := newCommandFlagSet(commandExport)
+	from := fs.String("from", time.Now().UTC().AddDate(0, 0, -1).Format(dateLayout),
+		"first day to export, YYYY-MM-DD")
+	to := fs.String("to", "", "day after the last day to export, YYYY-MM-DD, defaults to the day after -from")
+	format := fs.String("format", exportFormatCSV, "file format, csv, jsonl or parquet")
+	out := fs.String("out", ".", "local directory or bucket url, e.g. s3://bucket or file:///tmp/prices")
+	data := fs.String("data", exportDataPrices, "what to export, prices or set-index, "+
+		"the value and index of every group computed in the range")
+	currency := fs.String("currency", priceCurrency, "currency the prices are converted to "+
+		"at the exchange rate of the day they were ingested, the set indexes at the one of the day "+
+		"they were computed")
+	err := fs.Parse(args)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	fromDay, toDay, err := parseDateRange(*from, *to)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	dbConn, err := openDB(ctx, commandExport)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+	defer closeDB(dbConn)
+
+	bucket, err := openBucket(ctx, *out)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+	defer bucket.Close()
+
+	conv, err := newConverter(ctx, dbConn, *currency, toDay)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	dateRange := fromDay.Format(dateLayout) + "_" + toDay.Format(dateLayout)
+	switch *data {
+	case exportDataPrices:
+		key := fmt.Sprintf("sku_prices_%s.%s", dateRange, *format)
+		count, err := exportPrices(ctx, dbConn, bucket, key, *format, fromDay, toDay, conv)
+		if err != nil {
+			return errors.Wrap(err)
+		}
+
+		slog.Info("exported prices", "key", key, "rows", count)
+	case exportDataSetIndex:
+		key := fmt.Sprintf("set_indexes_%s.%s", dateRange, *format)
+		count, err := exportSetIndexes(ctx, dbConn, bucket, key, *format, fromDay, toDay, conv)
+		if err != nil {
+			return errors.Wrap(err)
+		}
+
+		slog.Info("exported set indexes", "key", key, "rows", count)
+	default:
+		return errors.New("unknown export data: " + *data)
+	}
+
+	return nil
+}
+
+// parseDateRange parses the days of a [from, to) range, to defaults to the
+// day after from
+func parseDateRange(from string, to string) (time.Time, time.Time, error) {
+	fromDay, err := time.Parse(dateLayout, from)
+	if err != nil {
+		return time.Time{}, time.Time{}, errors.Wrap(err)
+	}
+
+	if to == "" {
+		return fromDay, fromDay.AddDate(0, 0, 1), nil
+	}
+
+	toDay, err := time.Parse(dateLayout, to)
+	if err != nil {
+		return time.Time{}, time.Time{}, errors.Wrap(err)
+	}
+
+	if !toDay.After(fromDay) {
+		return time.Time{}, time.Time{}, errors.New("-to must be after -from")
+	}
+
+	return fromDay, toDay, nil
+}
+
+// openBucket opens a bucket url, a plain path is opened as a local
+// directory and created if needed
+func openBucket(ctx context.Context, location string) (*blob.Bucket, error) {
+	if !strings.Contains(location, "://") {
+		err := os.MkdirAll(location, 0o755)
+		if err != nil {
+			return nil, errors.Wrap(err)
+		}
+
+		bucket, err := fileblob.OpenBucket(location, nil)
+		if err != nil {
+			return nil, errors.Wrap(err)
+		}
+		return bucket, nil
+	}
+
+	bucket, err := blob.OpenBucket(ctx, location)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	return bucket, nil
+}
+
+// exportPrices writes the prices ingested in [from, to) to key in the bucket
+// in the currency of conv and returns how many rows were written
+func exportPrices(ctx context.Context, dbConn *gorm.DB, bucket *blob.Bucket, key string,
+	format string, from time.Time, to time.Time, conv *converter) (int, error) {
+	rows, err := dbConn.WithContext(ctx).Table("sku_prices").
+		Select("sku_prices.ingested_at, sku_prices.sku_id, products.tcgplayer_id AS product_id, "+
+			"details.name, groups.name AS \"group\", rarities.name AS rarity, "+
+			"conditions.name AS condition, printings.name AS printing, "+centsColumns).
+		Joins("JOIN skus ON skus.tcgplayer_id = sku_prices.sku_id").
+		Joins("JOIN products ON products.id = skus.product_id").
+		Joins("JOIN details ON details.id = products.detail_id").
+		Joins("JOIN groups ON groups.id = products.group_id").
+		Joins("JOIN rarities ON rarities.id = products.rarity_id").
+		Joins("JOIN conditions ON conditions.id = skus.condition_id").
+		Joins("JOIN printings ON printings.id = skus.printing_id").
+		Where("sku_prices.ingested_at >= ? AND sku_prices.ingested_at < ?", from, to).
+		Order("sku_prices.ingested_at, sku_prices.sku_id").
+		Rows()
+	if err != nil {
+		return 0, errors.Wrap(err)
+	}
+	defer rows.Close()
+
+	// cancelling the writer's context before closing it discards the
+	// object, so a failed export never leaves a truncated file behind
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	w, err := bucket.NewWriter(wctx, key, nil)
+	if err != nil {
+		return 0, errors.Wrap(err)
+	}
+
+	count, err := writeSnapshots(dbConn, rows, w, format, conv)
+	if err != nil {
+		cancel()
+		w.Close()
+		return 0, errors.Wrap(err)
+	}
+
+	err = w.Close()
+	if err != nil {
+		return 0, errors.Wrap(err)
+	}
+
+	return count, nil
+}
+
+func writeSnapshots(dbConn *gorm.DB, rows *sql.Rows, w io.Writer, format string, conv *converter) (int, error) {
+	sw, err := newSnapshotWriter(w, format)
+	if err != nil {
+		return 0, errors.Wrap(err)
+	}
+
+	count := 0
+	for rows.Next() {
+		row := priceSnapshot{}
+		err := dbConn.ScanRows(rows, &row)
+		if err != nil {
+			return 0, errors.Wrap(err)
+		}
+
+		err = conv.convertSnapshot(&row)
+		if err != nil {
+			return 0, errors.Wrap(err)
+		}
+
+		err = sw.Write(&row)
+		if err != nil {
+			return 0, errors.Wrap(err)
+		}
+		count++
+	}
+
+	if err := rows.Err(); err != nil {
+		return 0, errors.Wrap(err)
+	}
+
+	err = sw.Close()
+	if err != nil {
+		return 0, errors.Wrap(err)
+	}
+
+	return count, nil
+}
+
+func newSnapshotWriter(w io.Writer, format string) (snapshotWriter, error) {
+	switch format {
+	case exportFormatCSV:
+		cw := csv.NewWriter(w)
+		err := cw.Write(priceSnapshotHeader)
+		if err != nil {
+			return nil, errors.Wrap(err)
+		}
+		return &csvSnapshotWriter{w: cw}, nil
+	case exportFormatJSONL:
+		return &jsonlSnapshotWriter{enc: json.NewEncoder(w)}, nil
+	case exportFormatParquet:
+		pw, err := writer.NewParquetWriterFromWriter(w, new(parquetPriceSnapshot), 1)
+		if err != nil {
+			return nil, errors.Wrap(err)
+		}
+		return &parquetSnapshotWriter{w: pw}, nil
+	default:
+		return nil, errors.New("unknown export format: " + format)
+	}
+}
+
+type csvSnapshotWriter struct {
+	w *csv.Writer
+}
+
+func (c *csvSnapshotWriter) Write(row *priceSnapshot) error {
+	return c.w.Write([]string{
+		row.IngestedAt.UTC().Format(time.RFC3339),
+		strconv.Itoa(row.SKUID),
+		strconv.Itoa(row.ProductID),
+		row.Name,
+		row.Group,
+		row.Rarity,
+		row.Condition,
+		row.Printing,
+		row.Price.String(),
+		row.Shipping.String(),
+		row.Currency,
+	})
+}
+
+func (c *csvSnapshotWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type jsonlSnapshotWriter struct {
+	enc *json.Encoder
+}
+
+func (j *jsonlSnapshotWriter) Write(row *priceSnapshot) error {
+	return j.enc.Encode(row)
+}
+
+func (j *jsonlSnapshotWriter) Close() error {
+	return nil
+}
+
+type parquetSnapshotWriter struct {
+	w *writer.ParquetWriter
+}
+
+func (p *parquetSnapshotWriter) Write(row *priceSnapshot) error {
+	return p.w.Write(parquetPriceSnapshot{
+		IngestedAt: row.IngestedAt.UnixMilli(),
+		SKUID:      int64(row.SKUID),
+		ProductID:  int64(row.ProductID),
+		Name:       row.Name,
+		Group:      row.Group,
+		Rarity:     row.Rarity,
+		Condition:  row.Condition,
+		Printing:   row.Printing,
+		Price:      int64(row.Price),
+		Shipping:   int64(row.Shipping),
+		Currency:   row.Currency,
+	})
+}
+
+// Close writes the parquet footer, the underlying writer is left open
+func (p *parquetSnapshotWriter) Close() error {
+	return p.w.WriteStop()
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+	"gocloud.dev/blob"
+)
+
+func TestExportPrices_CSV(t *testing.T) {
+	ctx := context.Background()
+	dbConn, mock := GetMockDB(t)
+
+	bucket, err := blob.OpenBucket(ctx, "mem://")
+	require.NoError(t, err)
+	defer bucket.Close()
+
+	from := time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 0, 1)
+
+	mock.ExpectQuery(`SELECT (.+) FROM \"sku_prices\" JOIN skus (.+) WHERE sku_prices.ingested_at >= (.+)`).
+		WithArgs(from, to).
+		WillReturnRows(sqlmock.NewRows(priceSnapshotHeader).
+			AddRow(from.Add(time.Hour), 1, 10, "Dark Magician", "Legend of Blue Eyes",
+				"Ultra Rare", "Near Mint", "1st Edition", 1250, 99, priceCurrency))
+
+	count, err := exportPrices(ctx, dbConn, bucket, "prices.csv", exportFormatCSV, from, to,
+		&converter{currency: priceCurrency})
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+
+	data, err := bucket.ReadAll(ctx, "prices.csv")
+	require.NoError(t, err)
+	require.Equal(t, "ingested_at,sku_id,product_id,name,group,rarity,condition,printing,price,shipping,currency\n"+
+		"2023-03-01T01:00:00Z,1,10,Dark Magician,Legend of Blue Eyes,Ultra Rare,Near Mint,1st Edition,12.50,0.99,USD\n",
+		string(data))
+}
+
+func TestExportPrices_UnknownFormat(t *testing.T) {
+	ctx := context.Background()
+	dbConn, mock := GetMockDB(t)
+
+	bucket, err := blob.OpenBucket(ctx, "mem://")
+	require.NoError(t, err)
+	defer bucket.Close()
+
+	mock.ExpectQuery(`SELECT (.+) FROM \"sku_prices\"`).
+		WillReturnRows(sqlmock.NewRows(priceSnapshotHeader))
+
+	_, err = exportPrices(ctx, dbConn, bucket, "prices.xml", "xml", time.Now(), time.Now(),
+		&converter{currency: priceCurrency})
+	require.Error(t, err)
+
+	// a failed export doesn't leave a file behind
+	exists, err := bucket.Exists(ctx, "prices.xml")
+	require.NoError(t, err)
+	require.False(t, exists)
+}
+
+func TestParseDateRange(t *testing.T) {
+	from, to, err := parseDateRange("2023-03-01", "")
+	require.NoError(t, err)
+	require.Equal(t, from.AddDate(0, 0, 1), to)
+
+	_, _, err = parseDateRange("2023-03-02", "2023-03-01")
+	require.Error(t, err)
+}
+
+func TestExportPrices_Parquet(t *testing.T) {
+	ctx := context.Background()
+	dbConn, mock := GetMockDB(t)
+
+	bucket, err := blob.OpenBucket(ctx, "mem://")
+	require.NoError(t, err)
+	defer bucket.Close()
+
+	from := time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(`SELECT (.+) FROM \"sku_prices\"`).
+		WillReturnRows(sqlmock.NewRows(priceSnapshotHeader).
+			AddRow(from, 1, 10, "Dark Magician", "Legend of Blue Eyes",
+				"Ultra Rare", "Near Mint", "1st Edition", 1250, 99, priceCurrency))
+
+	count, err := exportPrices(ctx, dbConn, bucket, "prices.parquet", exportFormatParquet,
+		from, from.AddDate(0, 0, 1), &converter{currency: priceCurrency})
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+
+	data, err := bucket.ReadAll(ctx, "prices.parquet")
+	require.NoError(t, err)
+	require.Equal(t, "PAR1", string(data[:4]))
+	require.Equal(t, "PAR1", string(data[len(data)-4:]))
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"strings"
+
+	errors "github.com/AustinMCrane/errorutil"
+	"github.com/google/uuid"
+)
+
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// newRunID returns a new id used to correlate every log line of a single run
+func newRunID() string {
+	return uuid.NewString()
+}
+
+// newLogger builds a structured logger writing to w in the given format
+// (text or json) at the given level, every line is tagged with the run id
+func newLogger(w io.Writer, format string, level string, runID string) (*slog.Logger, error) {
+	var lvl slog.Level
+	err := lvl.UnmarshalText([]byte(level))
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case logFormatText:
+		handler = slog.NewTextHandler(w, opts)
+	case logFormatJSON:
+		handler = slog.NewJSONHandler(w, opts)
+	default:
+		return nil, errors.New("unknown log format: " + format)
+	}
+
+	return slog.New(handler).With("run_id", runID), nil
+}
+
+// errorMessage is the message of err without the frames errorutil adds to
+// every wrapped error
+func errorMessage(err error) string {
+	for {
+		wrapped, ok := err.(errors.WrappedError)
+		if !ok {
+			return err.Error()
+		}
+		if wrapped.Err == nil {
+			return ""
+		}
+		err = wrapped.Err
+	}
+}
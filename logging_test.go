@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	stderrors "errors"
+	"testing"
+
+	errors "github.com/AustinMCrane/errorutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewLogger_JSON(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := newLogger(&buf, logFormatJSON, "info", "test-run")
+	require.NoError(t, err)
+
+	logger.Debug("hidden")
+	logger.Info("fetched products page", "category", 2, "page", 1)
+
+	line := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &line))
+	require.Equal(t, "test-run", line["run_id"])
+	require.Equal(t, "INFO", line["level"])
+	require.EqualValues(t, 2, line["category"])
+	require.EqualValues(t, 1, line["page"])
+}
+
+func TestNewLogger_UnknownFormat(t *testing.T) {
+	_, err := newLogger(&bytes.Buffer{}, "xml", "info", "test-run")
+	require.Error(t, err)
+}
+
+func TestErrorMessage(t *testing.T) {
+	err := errors.Wrap(errors.Wrap(errors.New("rate limited")))
+	require.Contains(t, err.Error(), "\n")
+	require.Equal(t, "rate limited", errorMessage(err))
+	require.Equal(t, "plain", errorMessage(stderrors.New("plain")))
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gorm.io/gorm"
+
+	errors "github.com/AustinMCrane/errorutil"
+)
+
+const (
+	commandLookup = "lookup"
+
+	lookupFormatTable = "table"
+	lookupFormatJSON  = "json"
+)
+
+// lookupQuery is what a lookup searches the catalog for, the name is
+// matched as a prefix, or fuzzy with the words in order anywhere in the
+// name, the other fields are matched anywhere in their column, every match
+// ignores case
+type lookupQuery struct {
+	name      string
+	fuzzy     bool
+	group     string
+	condition string
+	printing  string
+	limit     int
+	// currency the prices are converted to, dollars when empty
+	currency string
+}
+
+// lookupPrice is a price of a sku at the time it was ingested
+type lookupPrice struct {
+	IngestedAt time.Time `json:"ingested_at"`
+	Price      cents     `json:"price"`
+	Shipping   cents     `json:"shipping"`
+}
+
+// storedPrice is a price of sku_prices with its exact cents
+type storedPrice struct {
+	SKUID      int `gorm:"column:sku_id"`
+	Price      cents
+	Shipping   cents
+	Currency   string
+	IngestedAt time.Time
+}
+
+// lookupSKU is a sku found by a lookup with its prices, the current price
+// is the latest one stored however old it is, the low, average and high are
+// over the prices of the history window
+type lookupSKU struct {
+	SKUID     int            `json:"sku_id" gorm:"column:sku_id"`
+	ProductID int            `json:"product_id"`
+	Name      string         `json:"name"`
+	Group     string         `json:"group"`
+	Rarity    string         `json:"rarity"`
+	Printing  string         `json:"printing"`
+	Condition string         `json:"condition"`
+	Currency  string         `json:"currency" gorm:"-"`
+	Current   *lookupPrice   `json:"current" gorm:"-"`
+	Low       cents          `json:"low" gorm:"-"`
+	Average   cents          `json:"average" gorm:"-"`
+	High      cents          `json:"high" gorm:"-"`
+	History   []*lookupPrice `json:"history" gorm:"-"`
+}
+
+// ExecLookup is the entry point of the lookup command, it prints the skus
+// of the cards matching a name with their current and recent prices
+func ExecLookup(ctx context.Context, args []string) error {
+	fs := newCommandFlagSet(commandLookup)
+	fuzzy := fs.Bool("fuzzy", false, "match the words of the name in order anywhere in the card name "+
+		"instead of as a prefix")
+	group := fs.String("group", "", "only cards of the groups whose name contains this")
+	condition := fs.String("condition", "", "only skus of the conditions whose name contains this, e.g. near mint")
+	printing := fs.String("printing", "", "only skus of the printings whose name contains this, e.g. 1st edition")
+	history := fs.Duration("history", time.Hour*24*30, "how far back prices are listed")
+	limit := fs.Int("limit", 50, "how many skus are listed at most")
+	format := fs.String("format", lookupFormatTable, "output format, table or json")
+	currency := fs.String("currency", priceCurrency, "currency the prices are converted to "+
+		"at the exchange rate of the day they were ingested")
+	setIndex := fs.Bool("set-index", false, "print the set value and index history of the groups "+
+		"whose name contains the query instead of card prices")
+	err := fs.Parse(args)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	if fs.NArg() == 0 {
+		return errors.New("usage: lookup [flags] <card or group name>")
+	}
+
+	if *format != lookupFormatTable && *format != lookupFormatJSON {
+		return errors.New("unknown lookup format: " + *format)
+	}
+
+	dbConn, err := openDB(ctx, commandLookup)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+	defer closeDB(dbConn)
+
+	if *setIndex {
+		now := time.Now()
+		conv, err := newConverter(ctx, dbConn, *currency, now)
+		if err != nil {
+			return errors.Wrap(err)
+		}
+
+		points, err := listSetIndexes(ctx, dbConn, conv, strings.Join(fs.Args(), " "), now.Add(-*history), now)
+		if err != nil {
+			return errors.Wrap(err)
+		}
+
+		if *format == lookupFormatJSON {
+			return writeLookupJSON(os.Stdout, points)
+		}
+
+		return writeSetIndexTable(os.Stdout, points)
+	}
+
+	skus, err := lookup(ctx, dbConn, lookupQuery{
+		name:      strings.Join(fs.Args(), " "),
+		fuzzy:     *fuzzy,
+		group:     *group,
+		condition: *condition,
+		printing:  *printing,
+		limit:     *limit,
+		currency:  *currency,
+	}, time.Now().Add(-*history))
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	if *format == lookupFormatJSON {
+		return writeLookupJSON(os.Stdout, skus)
+	}
+
+	return writeLookupTable(os.Stdout, skus)
+}
+
+// likeEscaper escapes the wildcards of user input matched with LIKE, the
+// patterns are used with ESCAPE '!' since sqlite has no default escape
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+// containsPattern is the LIKE pattern of lowercased text containing s
+func containsPattern(s string) string {
+	return "%" + likeEscaper.Replace(strings.ToLower(s)) + "%"
+}
+
+// namePattern is the LIKE pattern the lowercased card name has to match
+func (q lookupQuery) namePattern() string {
+	name := likeEscaper.Replace(strings.ToLower(strings.TrimSpace(q.name)))
+	if q.fuzzy {
+		return "%" + strings.Join(strings.Fields(name), "%") + "%"
+	}
+
+	return name + "%"
+}
+
+// lookup returns the skus matching the query with the prices they had since
+// the given time
+func lookup(ctx context.Context, dbConn *gorm.DB, q lookupQuery, since time.Time) ([]*lookupSKU, error) {
+	currency := q.currency
+	if currency == "" {
+		currency = priceCurrency
+	}
+
+	conv, err := newConverter(ctx, dbConn, currency, time.Now())
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	tx := dbConn.WithContext(ctx).Table("skus").
+		Select("skus.tcgplayer_id AS sku_id, products.tcgplayer_id AS product_id, "+
+			"details.name, groups.name AS \"group\", rarities.name AS rarity, "+
+			"printings.name AS printing, conditions.name AS condition").
+		Joins("JOIN products ON products.id = skus.product_id").
+		Joins("JOIN details ON details.id = products.detail_id").
+		Joins("JOIN groups ON groups.id = products.group_id").
+		Joins("JOIN rarities ON rarities.id = products.rarity_id").
+		Joins("JOIN conditions ON conditions.id = skus.condition_id").
+		Joins("JOIN printings ON printings.id = skus.printing_id").
+		Where("lower(details.name) LIKE ? ESCAPE '!'", q.namePattern())
+	if q.group != "" {
+		tx = tx.Where("lower(groups.name) LIKE ? ESCAPE '!'", containsPattern(q.group))
+	}
+	if q.condition != "" {
+		tx = tx.Where("lower(conditions.name) LIKE ? ESCAPE '!'", containsPattern(q.condition))
+	}
+	if q.printing != "" {
+		tx = tx.Where("lower(printings.name) LIKE ? ESCAPE '!'", containsPattern(q.printing))
+	}
+
+	skus := []*lookupSKU{}
+	err = tx.Order("details.name, groups.name, printings.name, conditions.name, skus.tcgplayer_id").
+		Limit(q.limit).Scan(&skus).Error
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	if len(skus) == 0 {
+		return skus, nil
+	}
+
+	ids := []int{}
+	byID := map[int]*lookupSKU{}
+	for _, s := range skus {
+		ids = append(ids, s.SKUID)
+		byID[s.SKUID] = s
+	}
+
+	prices := []storedPrice{}
+	err = dbConn.WithContext(ctx).Table("sku_prices").
+		Select("sku_prices.sku_id, sku_prices.ingested_at, "+centsColumns).
+		Where("sku_prices.sku_id IN ? AND sku_prices.ingested_at >= ?", ids, since).
+		Order("sku_prices.ingested_at").Scan(&prices).Error
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	for _, p := range prices {
+		price, err := convertLookupPrice(conv, p)
+		if err != nil {
+			return nil, errors.Wrap(err)
+		}
+
+		s := byID[p.SKUID]
+		s.History = append(s.History, price)
+	}
+
+	latestAt := dbConn.Table("sku_prices").Select("sku_id, max(ingested_at) AS ingested_at").
+		Where("sku_id IN ?", ids).Group("sku_id")
+	latest := []storedPrice{}
+	err = dbConn.WithContext(ctx).Table("sku_prices").
+		Select("sku_prices.sku_id, sku_prices.ingested_at, "+centsColumns).
+		Joins("JOIN (?) latest ON latest.sku_id = sku_prices.sku_id AND latest.ingested_at = sku_prices.ingested_at",
+			latestAt).
+		Scan(&latest).Error
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	for _, p := range latest {
+		byID[p.SKUID].Current, err = convertLookupPrice(conv, p)
+		if err != nil {
+			return nil, errors.Wrap(err)
+		}
+	}
+
+	for _, s := range skus {
+		s.Currency = conv.currency
+		summarizePrices(s)
+	}
+
+	return skus, nil
+}
+
+// convertLookupPrice converts a stored price to the currency of conv
+func convertLookupPrice(conv *converter, p storedPrice) (*lookupPrice, error) {
+	price, err := conv.convert(p.Price, p.Currency, p.IngestedAt)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	shipping, err := conv.convert(p.Shipping, p.Currency, p.IngestedAt)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	return &lookupPrice{IngestedAt: p.IngestedAt, Price: price, Shipping: shipping}, nil
+}
+
+// summarizePrices sets the low, average and high price of a sku from its
+// history
+func summarizePrices(s *lookupSKU) {
+	if len(s.History) == 0 {
+		return
+	}
+
+	var sum cents
+	s.Low, s.High = s.History[0].Price, s.History[0].Price
+	for _, p := range s.History {
+		sum += p.Price
+		s.Low = min(s.Low, p.Price)
+		s.High = max(s.High, p.Price)
+	}
+
+	// the average is rounded half away from zero to the nearest cent
+	n := cents(len(s.History))
+	if sum < 0 {
+		s.Average = (sum - n/2) / n
+	} else {
+		s.Average = (sum + n/2) / n
+	}
+}
+
+// writeLookupTable prints a line per sku
+func writeLookupTable(w io.Writer, skus []*lookupSKU) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tGROUP\tRARITY\tPRINTING\tCONDITION\tSKU\tCURRENCY\tCURRENT\tSHIPPING\tLOW\tAVG\tHIGH\tAS OF")
+	for _, s := range skus {
+		current, shipping, asOf := "-", "-", "-"
+		if s.Current != nil {
+			current = s.Current.Price.String()
+			shipping = s.Current.Shipping.String()
+			asOf = s.Current.IngestedAt.UTC().Format(time.RFC3339)
+		}
+
+		// a sku can have a current price without any in the history window
+		low, average, high := "-", "-", "-"
+		if len(s.History) > 0 {
+			low, average, high = s.Low.String(), s.Average.String(), s.High.String()
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", s.Name, s.Group, s.Rarity,
+			s.Printing, s.Condition, s.SKUID, s.Currency, current, shipping, low, average, high, asOf)
+	}
+
+	err := tw.Flush()
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
+
+// writeLookupJSON prints the skus with their whole history, or the set
+// index points
+func writeLookupJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	err := enc.Encode(v)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLookupQuery_NamePattern(t *testing.T) {
+	require.Equal(t, "dark magician%", lookupQuery{name: " Dark Magician"}.namePattern())
+	require.Equal(t, "%dark%girl%", lookupQuery{name: "dark  girl", fuzzy: true}.namePattern())
+	require.Equal(t, "100!% pure!_!!%", lookupQuery{name: "100% Pure_!"}.namePattern())
+	require.Equal(t, "%a!_b%", containsPattern("A_b"))
+}
+
+func TestLookup(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+
+	dbConn, err := getDBConnection(dbDriverSQLite, "", "", "", "", filepath.Join(t.TempDir(), "dev.db"))
+	require.NoError(t, err)
+	require.NoError(t, migrate(ctx, dbConn))
+	s := newSQLStore(dbConn)
+	writeRetentionFixture(t, s, now)
+	require.NoError(t, s.InsertPrices(ctx, []skuPrice{
+		{SKUID: 10, PriceCents: 3000, ShippingCents: 100, IngestedAt: now.Add(-time.Hour * 48)},
+		{SKUID: 10, PriceCents: 2000, ShippingCents: 100, IngestedAt: now.Add(-time.Hour)},
+	}))
+
+	skus, err := lookup(ctx, dbConn, lookupQuery{name: "TE", group: "test-1", limit: 10}, now.AddDate(0, 0, -5))
+	require.NoError(t, err)
+	require.Len(t, skus, 2)
+
+	// the fixture prices ingested 10 days ago are out of the window
+	secret := skus[0]
+	require.Equal(t, 10, secret.SKUID)
+	require.Equal(t, "Secret Rare", secret.Rarity)
+	require.Equal(t, "1st Edition", secret.Printing)
+	require.Equal(t, "Near Mint", secret.Condition)
+	require.Len(t, secret.History, 2)
+	require.Equal(t, cents(2000), secret.Current.Price)
+	require.True(t, now.Add(-time.Hour).Equal(secret.Current.IngestedAt))
+	require.Equal(t, cents(2000), secret.Low)
+	require.Equal(t, cents(2500), secret.Average)
+	require.Equal(t, cents(3000), secret.High)
+
+	// the current price is the latest one even when it is older than the
+	// window, which only bounds the history
+	require.Equal(t, 20, skus[1].SKUID)
+	require.Empty(t, skus[1].History)
+	require.NotNil(t, skus[1].Current)
+	require.True(t, now.AddDate(0, 0, -10).Equal(skus[1].Current.IngestedAt))
+
+	out := bytes.Buffer{}
+	require.NoError(t, writeLookupTable(&out, skus))
+	require.Contains(t, out.String(), "test  test-1  Secret Rare")
+	require.Contains(t, out.String(), "20.00    1.00      20.00  25.00  30.00")
+
+	out.Reset()
+	require.NoError(t, writeLookupJSON(&out, skus))
+	decoded := []*lookupSKU{}
+	require.NoError(t, json.Unmarshal(out.Bytes(), &decoded))
+	require.Len(t, decoded[0].History, 2)
+
+	// fuzzy matches words anywhere in the name, the other filters narrow it
+	skus, err = lookup(ctx, dbConn, lookupQuery{name: "e t", fuzzy: true, condition: "near", printing: "1st",
+		limit: 10}, now.AddDate(0, 0, -5))
+	require.NoError(t, err)
+	require.Len(t, skus, 3)
+
+	skus, err = lookup(ctx, dbConn, lookupQuery{name: "est", limit: 10}, now)
+	require.NoError(t, err)
+	require.Empty(t, skus)
+
+	// wildcards in the query match themselves
+	skus, err = lookup(ctx, dbConn, lookupQuery{name: "t_st", group: "test%", limit: 10}, now)
+	require.NoError(t, err)
+	require.Empty(t, skus)
+}
+
+func TestLookup_PricesWithoutCents(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+
+	dbConn, err := getDBConnection(dbDriverSQLite, "", "", "", "", filepath.Join(t.TempDir(), "dev.db"))
+	require.NoError(t, err)
+	require.NoError(t, migrate(ctx, dbConn))
+	writeRetentionFixture(t, newSQLStore(dbConn), now)
+
+	// prices ingested before the cents columns were added are rounded from
+	// the float columns
+	require.NoError(t, dbConn.Exec("INSERT INTO sku_prices (sku_id, price, shipping, ingested_at) VALUES (?, ?, ?, ?)",
+		20, float32(4.99), float32(0.5), now.Add(-time.Hour)).Error)
+
+	skus, err := lookup(ctx, dbConn, lookupQuery{name: "test", group: "test-1", limit: 10}, now.Add(-time.Hour*2))
+	require.NoError(t, err)
+	require.Len(t, skus, 2)
+	require.Equal(t, 20, skus[1].SKUID)
+	require.Equal(t, priceCurrency, skus[1].Currency)
+	require.Equal(t, cents(499), skus[1].Current.Price)
+	require.Equal(t, cents(50), skus[1].Current.Shipping)
+}
@@ -1,15 +1,22 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
+	"github.com/glebarez/sqlite"
 	_ "github.com/lib/pq"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
 	errors "github.com/AustinMCrane/errorutil"
 	"github.com/AustinMCrane/tcg-market-watch-api/pkg/store"
@@ -17,28 +24,99 @@ import (
 )
 
 var (
-	dbHost      = flag.String("db-host", "localhost", "database host")
-	dbPort      = flag.String("db-port", "5432", "database port")
-	dbUser      = flag.String("db-user", "postgres", "database user")
-	dbPassword  = flag.String("db-password", "password", "database password")
-	dbName      = flag.String("db-name", "postgres", "database name")
-	ingestPrice = flag.Bool("ingest-price", false, "should just ingest pricing")
+	dbDriver   = flag.String("db-driver", dbDriverPostgres, "database driver, postgres or sqlite")
+	dbHost     = flag.String("db-host", "localhost", "database host")
+	dbPort     = flag.String("db-port", "5432", "database port")
+	dbUser     = flag.String("db-user", "postgres", "database user")
+	dbPassword = flag.String("db-password", "password", "database password")
+	dbName     = flag.String("db-name", "postgres", "database name, the database file with sqlite")
+	dryRun     = flag.Bool("dry-run", false, "keep everything in memory instead of writing to the database, "+
+		"the commands querying the database refuse it")
 
 	publicKey  = flag.String("public-key", "", "public tcgplayer api key")
 	privateKey = flag.String("private-key", "", "private tcgplayer api key")
-	devMode    = flag.Bool("dev", true, "dev flag, only ingest a few products")
+	timeout    = flag.Duration("timeout", 0, "overall deadline for the run, 0 means no deadline")
+
+	logFormat = flag.String("log-format", logFormatText, "log output format, text or json")
+	logLevel  = flag.String("log-level", "info", "minimum log level, debug, info, warn or error")
+
+	eventsTopic = flag.String("events-topic", "", "pubsub topic url ingestion events are published to, "+
+		"e.g. mem://events, events are disabled when empty")
+	archiveURL = flag.String("archive", "", "local directory or bucket url raw api responses are archived to, "+
+		"archiving is disabled when empty")
 
 	defaultRarityName = "Unconfirmed"
 
 	// rarityNameCommon is the name of the common rarity it is not just called
 	// Common
 	rarityNameCommon = "Common / Short Print"
+
+	// devGroupLimit is how many groups are sampled in dev mode
+	devGroupLimit = 5
 )
 
 func main() {
+	flag.Usage = usage
 	flag.Parse()
-	if err := Exec(); err != nil {
-		fmt.Println(err)
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	runID := newRunID()
+	logger, err := newLogger(os.Stderr, *logFormat, *logLevel, runID)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	slog.SetDefault(logger)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	args := flag.Args()[1:]
+	switch flag.Arg(0) {
+	case commandSyncCategories:
+		err = ExecSyncCategories(ctx, runID, args)
+	case commandSyncCatalog:
+		err = ExecSyncCatalog(ctx, runID, args)
+	case commandIngestPrices:
+		err = ExecIngestPrices(ctx, runID, args)
+	case commandTrim:
+		err = ExecTrim(ctx, runID, args)
+	case commandSyncRates:
+		err = ExecSyncRates(ctx, runID, args)
+	case commandVerify:
+		err = ExecVerify(ctx, args)
+	case commandExport:
+		err = ExecExport(ctx, args)
+	case commandLookup:
+		err = ExecLookup(ctx, args)
+	case commandServe:
+		err = ExecServe(ctx, args)
+	case commandDaemon:
+		err = ExecDaemon(ctx, args)
+	case commandRuns:
+		err = ExecRuns(ctx, args)
+	case commandWatch:
+		err = ExecWatch(ctx, args)
+	case commandRepairSKUs:
+		err = ExecRepairSKUs(ctx, args)
+	case commandReprocess:
+		err = ExecReprocess(ctx, runID, args)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		slog.Error("run failed", "error", err)
 		os.Exit(1)
 	}
 }
@@ -47,203 +125,385 @@ func main() {
 //
 //go:generate mockgen -destination mock_main_test.go -package main -source main.go Tcgplayer
 type Tcgplayer interface {
-	GetCategories() ([]*tcgplayer.Category, error)
-	GetGroups(tcgplayer.GroupParams) ([]*tcgplayer.Group, error)
-	GetRarities(params *tcgplayer.RarityParams) ([]*tcgplayer.Rarity, error)
-	GetPrinting(params tcgplayer.PrintingParams) ([]*tcgplayer.Printing, error)
-	GetConditions(params *tcgplayer.ConditionParams) ([]*tcgplayer.Condition, error)
-	GetLanguages(params *tcgplayer.LanguageParams) ([]*tcgplayer.Language, error)
-	ListAllProducts(params tcgplayer.ProductParams) ([]*tcgplayer.Product, error)
-	ListProductSKUs(skuID int) ([]*tcgplayer.SKU, error)
-	GetSKUPrices(skus []int) ([]*tcgplayer.SKUMarketPrice, error)
+	GetCategories(ctx context.Context) ([]*tcgplayer.Category, error)
+	GetGroups(ctx context.Context, params tcgplayer.GroupParams) ([]*tcgplayer.Group, error)
+	GetRarities(ctx context.Context, params *tcgplayer.RarityParams) ([]*tcgplayer.Rarity, error)
+	GetPrinting(ctx context.Context, params tcgplayer.PrintingParams) ([]*tcgplayer.Printing, error)
+	GetConditions(ctx context.Context, params *tcgplayer.ConditionParams) ([]*tcgplayer.Condition, error)
+	GetLanguages(ctx context.Context, params *tcgplayer.LanguageParams) ([]*tcgplayer.Language, error)
+	ListAllProducts(ctx context.Context, params tcgplayer.ProductParams) ([]*tcgplayer.Product, error)
+	ListProductSKUs(ctx context.Context, skuID int) ([]*tcgplayer.SKU, error)
+	GetSKUPrices(ctx context.Context, skus []int) ([]*tcgplayer.SKUMarketPrice, error)
 }
 
-// Exec is the main entry point for the program
-func Exec() error {
-	dbConn, err := getDBConnection(*dbHost, *dbPort, *dbUser, *dbPassword, *dbName)
+// ingetPrices fetches prices for every sku in batches
+func ingetPrices(ctx context.Context, s Store, client Tcgplayer, events *eventPublisher,
+	sleepDuration time.Duration, flush flushPolicy) error {
+	skus, err := s.ListSKUIDs(ctx)
 	if err != nil {
 		return errors.Wrap(err)
 	}
 
-	client, err := tcgplayer.New(*publicKey, *privateKey)
-	if err != nil {
-		return errors.Wrap(err)
+	return ingestSKUPrices(ctx, s, client, events, skus, sleepDuration, flush)
+}
+
+// ingestSKUPrices fetches prices for the skus in batches, when ctx is done it
+// stops before the next batch but always finishes writing the current one
+func ingestSKUPrices(ctx context.Context, s Store, client Tcgplayer, events *eventPublisher, skus []int,
+	sleepDuration time.Duration, flush flushPolicy) (err error) {
+	skuGroups := [][]int{}
+	for start := 0; start < len(skus); start += priceBatchSize {
+		skuGroups = append(skuGroups, skus[start:min(start+priceBatchSize, len(skus))])
 	}
 
-	if *ingestPrice == false {
-		categories, err := getCategories(client)
+	buffer := newPriceBuffer(s, events, flush)
+	defer func() {
+		// the buffered batches have already been fetched so write them even
+		// if ctx was cancelled in the meantime
+		flushErr := buffer.Flush(context.WithoutCancel(ctx))
+		if err == nil && flushErr != nil {
+			err = errors.Wrap(flushErr)
+		}
+	}()
+
+	for i, skuGroup := range skuGroups {
+		reportProgress(ctx, "price batch", i+1, len(skuGroups))
+		prices, err := client.GetSKUPrices(ctx, skuGroup)
 		if err != nil {
 			return errors.Wrap(err)
 		}
 
-		_, err = syncCategories(dbConn, categories)
+		// insert tcgplayer prices, they are stamped when written
+		pricesToCreate := []skuPrice{}
+		for _, p := range prices {
+			pricesToCreate = append(pricesToCreate, newSKUPrice(p, time.Time{}))
+		}
+
+		slog.Debug("fetched price batch", "batch", i, "batches", len(skuGroups),
+			"prices", len(pricesToCreate))
+		err = buffer.Add(ctx, priceBatchIngestedEvent{
+			Batch:   i,
+			Batches: len(skuGroups),
+			Prices:  len(pricesToCreate),
+		}, pricesToCreate)
 		if err != nil {
 			return errors.Wrap(err)
 		}
 
-		err = updateImmutableDataTcgPlayer(dbConn, client, tcgplayer.CategoryYugioh)
+		err = sleepContext(ctx, sleepDuration)
 		if err != nil {
 			return errors.Wrap(err)
 		}
-		return nil
 	}
+	return nil
+}
 
-	// 2 months
-	err = trimOldPriceData(dbConn, time.Duration(time.Hour*24*60))
+// catalogOptions controls which groups a catalog sync touches
+type catalogOptions struct {
+	// groupIDs limits the sync to these tcgplayer group ids, all groups of
+	// the category are synced when empty
+	groupIDs []int
+	// fullRefresh truncates the catalog and crawls every group again, even
+	// the ones that haven't changed
+	fullRefresh bool
+	// recrawl crawls the groups again even if they haven't changed, without
+	// truncating the catalog
+	recrawl bool
+	// sample limits the catalog to a few groups, every group is synced when
+	// nil
+	sample *groupSample
+}
+
+func updateImmutableDataTcgPlayer(ctx context.Context, s Store, client Tcgplayer, events *eventPublisher,
+	categoryID int, opts catalogOptions) error {
+	groups, err := getGroups(ctx, client, categoryID)
 	if err != nil {
 		return errors.Wrap(err)
 	}
 
-	err = ingetPrices(dbConn, client, time.Millisecond*100)
+	groups, err = filterGroups(groups, opts.groupIDs)
 	if err != nil {
 		return errors.Wrap(err)
 	}
 
-	return nil
-}
+	// only the sampled groups are written so their products never reference
+	// a group the database doesn't hold
+	if opts.sample != nil {
+		groups, err = opts.sample.pick(groups)
+		if err != nil {
+			return errors.Wrap(err)
+		}
+	}
 
-func ingetPrices(dbConn *gorm.DB, client Tcgplayer, sleepDuration time.Duration) error {
-	skus := []store.SKU{}
-	err := dbConn.Select("tcgplayer_id").Find(&skus).Error
+	changed, err := changedGroups(ctx, s, groups, opts.fullRefresh || opts.recrawl)
 	if err != nil {
 		return errors.Wrap(err)
 	}
 
-	skuGroups := [][]int{}
-	currentGroup := []int{}
-	count := 0
-	maxCount := 100
-	for _, s := range skus {
-		if count < maxCount {
-			currentGroup = append(currentGroup, s.TCGPlayerID)
-			count++
+	if len(changed) == 0 {
+		slog.Info("data already exists", "category", categoryID)
+		return nil
+	}
 
-		} else {
-			skuGroups = append(skuGroups, currentGroup)
-			currentGroup = []int{}
-			count = 0
+	// fetch everything from the api before touching the database so a
+	// failing request can't leave a half written catalog behind
+	c, err := fetchCatalog(ctx, client, categoryID, groups, changed)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	// a full refresh truncates every group so it can't skip the failed ones
+	if opts.fullRefresh && len(c.failed) > 0 {
+		return errors.New(fmt.Sprintf("unable to crawl %d groups, not refreshing catalog", len(c.failed)))
+	}
+
+	// the truncate and all inserts run in a single transaction so readers
+	// either see the old catalog or the new one, never a partial one
+	var changes *catalogChanges
+	err = s.Transaction(ctx, func(tx Store) error {
+		if opts.fullRefresh {
+			err := tx.Truncate(ctx)
+			if err != nil {
+				return errors.Wrap(err)
+			}
 		}
+
+		var err error
+		changes, err = writeCatalog(ctx, tx, c)
+		return err
+	})
+	if err != nil {
+		return errors.Wrap(err)
 	}
 
-	if len(currentGroup) > 0 {
-		skuGroups = append(skuGroups, currentGroup)
+	// only announce what was added once it is committed
+	for _, g := range changes.groups {
+		events.publish(ctx, eventGroupAdded, groupAddedEvent{
+			GroupID:    g.ID,
+			CategoryID: categoryID,
+			Name:       g.Name,
+		})
+	}
+	for _, p := range changes.products {
+		events.publish(ctx, eventProductAdded, productAddedEvent{
+			ProductID: p.ID,
+			GroupID:   p.GroupID,
+			Name:      p.Name,
+		})
 	}
 
-	for _, skuGroup := range skuGroups {
-		prices, err := client.GetSKUPrices(skuGroup)
-		if err != nil {
-			return errors.Wrap(err)
+	if len(c.failed) > 0 {
+		ids := []int{}
+		for _, g := range c.failed {
+			ids = append(ids, g.ID)
 		}
+		return errors.New(fmt.Sprintf("unable to crawl groups %v", ids))
+	}
 
-		// insert tcgplayer prices
-		pricesToCreate := []store.SKUPrice{}
-		for _, p := range prices {
-			pricesToCreate = append(pricesToCreate, store.SKUPrice{
-				SKUID:    p.SKUID,
-				Price:    float32(p.LowPrice),
-				Shipping: float32(p.LowestShipping),
-			})
+	return nil
+}
+
+// filterGroups returns the groups with the given tcgplayer ids, every group
+// when ids is empty
+func filterGroups(groups []*tcgplayer.Group, ids []int) ([]*tcgplayer.Group, error) {
+	if len(ids) == 0 {
+		return groups, nil
+	}
+
+	filtered := []*tcgplayer.Group{}
+	for _, id := range ids {
+		found := false
+		for _, g := range groups {
+			if g.ID == id {
+				filtered = append(filtered, g)
+				found = true
+				break
+			}
 		}
 
-		err = dbConn.Create(&pricesToCreate).Error
-		if err != nil {
-			return errors.Wrap(err)
+		if !found {
+			return nil, errors.New(fmt.Sprintf("unable to find group %d", id))
 		}
-		time.Sleep(sleepDuration)
 	}
-	return nil
+
+	return filtered, nil
 }
 
-func updateImmutableDataTcgPlayer(dbConn *gorm.DB, client Tcgplayer, categoryID int) error {
-	// check if groups changed from tcgplayer
-	groups, err := getGroups(client, categoryID)
-	if err != nil {
-		return errors.Wrap(err)
+// changedGroups returns the groups that need to be crawled again, the
+// tcgplayer client doesn't decode a group's modifiedOn so publishedOn is
+// compared with the value recorded on the last crawl
+func changedGroups(ctx context.Context, s Store, groups []*tcgplayer.Group,
+	all bool) ([]*tcgplayer.Group, error) {
+	if all {
+		return groups, nil
 	}
 
-	currentGroupIDs := []int{}
-	err = dbConn.Model(&store.Group{}).Select("tcgplayer_id").
-		Find(&currentGroupIDs).Error
+	ids := []int{}
+	for _, g := range groups {
+		ids = append(ids, g.ID)
+	}
+
+	synced, err := s.SyncedGroups(ctx, ids)
 	if err != nil {
-		return errors.Wrap(err)
+		return nil, errors.Wrap(err)
 	}
 
-	needUpdate := false
+	changed := []*tcgplayer.Group{}
 	for _, g := range groups {
-		for _, id := range currentGroupIDs {
-			if id == g.ID {
-				needUpdate = true
-				break
-			}
+		publishedOn, ok := synced[g.ID]
+		if !ok || publishedOn != g.PublishedOn {
+			changed = append(changed, g)
 		}
 	}
 
-	if needUpdate == true {
-		err := dropData(dbConn)
-		if err != nil {
-			return errors.Wrap(err)
-		}
-	} else if len(currentGroupIDs) > 0 {
-		log.Println("data already exists")
-		return nil
-	}
+	return changed, nil
+}
 
-	createdGroups, err := syncGroups(dbConn, groups)
+// catalog is the immutable catalog data for a category as returned by the
+// tcgplayer api
+type catalog struct {
+	groups     []*tcgplayer.Group
+	rarities   []*tcgplayer.Rarity
+	printings  []*tcgplayer.Printing
+	conditions []*tcgplayer.Condition
+	languages  []*tcgplayer.Language
+
+	// crawled are the groups whose products were fetched, their products
+	// replace the ones currently stored
+	crawled  []*tcgplayer.Group
+	products []*tcgplayer.Product
+
+	// failed are the groups whose products couldn't be fetched, they are
+	// left untouched
+	failed []*tcgplayer.Group
+}
+
+func fetchCatalog(ctx context.Context, client Tcgplayer, categoryID int,
+	groups []*tcgplayer.Group, changed []*tcgplayer.Group) (*catalog, error) {
+	rarities, err := getRarities(ctx, client, categoryID)
 	if err != nil {
-		return errors.Wrap(err)
+		return nil, errors.Wrap(err)
 	}
 
-	rarities, err := getRarities(client, categoryID)
+	printings, err := getPrintings(ctx, client, categoryID)
 	if err != nil {
-		return errors.Wrap(err)
+		return nil, errors.Wrap(err)
 	}
 
-	createdRarities, err := syncRarities(dbConn, rarities)
+	conditions, err := getConditions(ctx, client, categoryID)
 	if err != nil {
-		return errors.Wrap(err)
+		return nil, errors.Wrap(err)
 	}
 
-	printings, err := getPrintings(client, categoryID)
+	languages, err := getLanguages(ctx, client, categoryID)
 	if err != nil {
-		return errors.Wrap(err)
+		return nil, errors.Wrap(err)
+	}
+
+	c := &catalog{
+		groups:     groups,
+		rarities:   rarities,
+		printings:  printings,
+		conditions: conditions,
+		languages:  languages,
+	}
+
+	for i, g := range changed {
+		reportProgress(ctx, "group", i+1, len(changed))
+		products, err := getGroupProducts(ctx, client, categoryID, g, time.Millisecond*200)
+		if err != nil {
+			// a cancelled run isn't a failing group
+			if ctx.Err() != nil {
+				return nil, errors.Wrap(ctx.Err())
+			}
+
+			slog.Warn("unable to crawl group", "category", categoryID, "group", g.ID,
+				"error", err)
+			c.failed = append(c.failed, g)
+			continue
+		}
+
+		slog.Info("crawled group", "category", categoryID, "group", g.ID,
+			"products", len(products), "groups_done", i+1, "groups", len(changed))
+		c.crawled = append(c.crawled, g)
+		c.products = append(c.products, products...)
 	}
 
-	createdPrintings, err := syncPrintings(dbConn, printings)
+	return c, nil
+}
+
+// catalogChanges are the groups and products a catalog write added
+type catalogChanges struct {
+	groups   []*tcgplayer.Group
+	products []*tcgplayer.Product
+}
+
+// writeCatalog writes the catalog, the products and skus of every crawled
+// group are replaced, s is expected to be a transaction
+func writeCatalog(ctx context.Context, s Store, c *catalog) (*catalogChanges, error) {
+	changes := &catalogChanges{}
+
+	var err error
+	changes.groups, err = s.UpsertGroups(ctx, c.groups)
 	if err != nil {
-		return errors.Wrap(err)
+		return nil, errors.Wrap(err)
 	}
 
-	conditions, err := getConditions(client, categoryID)
+	err = s.UpsertRarities(ctx, c.rarities)
 	if err != nil {
-		return errors.Wrap(err)
+		return nil, errors.Wrap(err)
 	}
 
-	createdConditions, err := syncConditions(dbConn, conditions)
+	err = s.UpsertPrintings(ctx, c.printings)
 	if err != nil {
-		return errors.Wrap(err)
+		return nil, errors.Wrap(err)
 	}
 
-	languages, err := getLanguages(client, categoryID)
+	err = s.UpsertConditions(ctx, c.conditions)
 	if err != nil {
-		return errors.Wrap(err)
+		return nil, errors.Wrap(err)
 	}
 
-	createdLanguages, err := syncLanguages(dbConn, languages)
+	err = s.UpsertLanguages(ctx, c.languages)
 	if err != nil {
-		return errors.Wrap(err)
+		return nil, errors.Wrap(err)
+	}
+
+	if len(c.crawled) == 0 {
+		return changes, nil
+	}
+
+	crawledIDs := []int{}
+	for _, g := range c.crawled {
+		crawledIDs = append(crawledIDs, g.ID)
 	}
 
-	products, err := getProducts(client, categoryID, time.Millisecond*200)
+	changes.products, err = s.UpsertProducts(ctx, crawledIDs, c.products)
 	if err != nil {
-		return errors.Wrap(err)
+		return nil, errors.Wrap(err)
 	}
 
-	createdProducts, err := syncProducts(dbConn, createdGroups, createdRarities, products)
+	err = s.MarkGroupsSynced(ctx, c.crawled)
 	if err != nil {
-		return errors.Wrap(err)
+		return nil, errors.Wrap(err)
+	}
+
+	return changes, nil
+}
+
+// markGroupsSynced records the publishedOn each group was crawled at
+func markGroupsSynced(ctx context.Context, dbConn *gorm.DB, groups []*tcgplayer.Group) error {
+	synced := []groupSync{}
+	for _, g := range groups {
+		synced = append(synced, groupSync{
+			TCGPlayerID: g.ID,
+			PublishedOn: g.PublishedOn,
+			SyncedAt:    time.Now(),
+		})
 	}
 
-	err = syncSKUs(dbConn, createdLanguages, createdConditions, createdPrintings, createdProducts, products)
+	err := dbConn.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).
+		Create(&synced).Error
 	if err != nil {
 		return errors.Wrap(err)
 	}
@@ -251,7 +511,7 @@ func updateImmutableDataTcgPlayer(dbConn *gorm.DB, client Tcgplayer, categoryID
 	return nil
 }
 
-func syncSKUs(dbConn *gorm.DB, languages []*store.Language, conditions []*store.Condition,
+func syncSKUs(ctx context.Context, dbConn *gorm.DB, languages []*store.Language, conditions []*store.Condition,
 	printings []*store.Printing, products []*store.Product, productsTCG []*tcgplayer.Product) error {
 	p := []*store.SKU{}
 	for _, prod := range productsTCG {
@@ -288,7 +548,7 @@ func syncSKUs(dbConn *gorm.DB, languages []*store.Language, conditions []*store.
 			}
 			// NOTE: only care about english
 			if !isEnglish {
-				break
+				continue
 			}
 
 			group := store.SKU{
@@ -302,7 +562,11 @@ func syncSKUs(dbConn *gorm.DB, languages []*store.Language, conditions []*store.
 		}
 	}
 
-	err := dbConn.CreateInBatches(&p, 3000).Error
+	if len(p) == 0 {
+		return nil
+	}
+
+	err := dbConn.WithContext(ctx).CreateInBatches(&p, 3000).Error
 	if err != nil {
 		return errors.Wrap(err)
 	}
@@ -310,9 +574,32 @@ func syncSKUs(dbConn *gorm.DB, languages []*store.Language, conditions []*store.
 	return nil
 }
 
-func syncGroups(dbConn *gorm.DB, groups []*tcgplayer.Group) ([]*store.Group, error) {
+// syncGroups creates the groups that don't exist yet and returns all of them
+func syncGroups(ctx context.Context, dbConn *gorm.DB, groups []*tcgplayer.Group) ([]*store.Group, error) {
+	ids := []int{}
+	for _, g := range groups {
+		ids = append(ids, g.ID)
+	}
+
+	existing := []*store.Group{}
+	err := dbConn.WithContext(ctx).Where("tcgplayer_id IN ?", ids).Find(&existing).Error
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
 	p := []*store.Group{}
 	for _, g := range groups {
+		found := false
+		for _, e := range existing {
+			if e.TCGPlayerID == g.ID {
+				found = true
+				break
+			}
+		}
+		if found {
+			continue
+		}
+
 		group := store.Group{
 			Name:        g.Name,
 			TCGPlayerID: g.ID,
@@ -321,17 +608,43 @@ func syncGroups(dbConn *gorm.DB, groups []*tcgplayer.Group) ([]*store.Group, err
 		p = append(p, &group)
 	}
 
-	err := dbConn.Create(&p).Error
-	if err != nil {
-		return nil, errors.Wrap(err)
+	if len(p) > 0 {
+		err = dbConn.WithContext(ctx).Create(&p).Error
+		if err != nil {
+			return nil, errors.Wrap(err)
+		}
 	}
 
-	return p, nil
+	return append(existing, p...), nil
 }
 
-func syncConditions(dbConn *gorm.DB, conditions []*tcgplayer.Condition) ([]*store.Condition, error) {
+// syncConditions creates the conditions that don't exist yet and returns all
+// of them
+func syncConditions(ctx context.Context, dbConn *gorm.DB, conditions []*tcgplayer.Condition) ([]*store.Condition, error) {
+	ids := []int{}
+	for _, c := range conditions {
+		ids = append(ids, c.ID)
+	}
+
+	existing := []*store.Condition{}
+	err := dbConn.WithContext(ctx).Where("tcgplayer_id IN ?", ids).Find(&existing).Error
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
 	p := []*store.Condition{}
 	for _, g := range conditions {
+		found := false
+		for _, e := range existing {
+			if e.TCGPlayerID == g.ID {
+				found = true
+				break
+			}
+		}
+		if found {
+			continue
+		}
+
 		condition := store.Condition{
 			Name:         g.Name,
 			Abbreviation: g.Abbreviation,
@@ -340,17 +653,43 @@ func syncConditions(dbConn *gorm.DB, conditions []*tcgplayer.Condition) ([]*stor
 		p = append(p, &condition)
 	}
 
-	err := dbConn.Create(&p).Error
-	if err != nil {
-		return nil, errors.Wrap(err)
+	if len(p) > 0 {
+		err = dbConn.WithContext(ctx).Create(&p).Error
+		if err != nil {
+			return nil, errors.Wrap(err)
+		}
 	}
 
-	return p, nil
+	return append(existing, p...), nil
 }
 
-func syncLanguages(dbConn *gorm.DB, languages []*tcgplayer.Language) ([]*store.Language, error) {
+// syncLanguages creates the languages that don't exist yet and returns all of
+// them
+func syncLanguages(ctx context.Context, dbConn *gorm.DB, languages []*tcgplayer.Language) ([]*store.Language, error) {
+	ids := []int{}
+	for _, l := range languages {
+		ids = append(ids, l.ID)
+	}
+
+	existing := []*store.Language{}
+	err := dbConn.WithContext(ctx).Where("tcgplayer_id IN ?", ids).Find(&existing).Error
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
 	p := []*store.Language{}
 	for _, g := range languages {
+		found := false
+		for _, e := range existing {
+			if e.TCGPlayerID == g.ID {
+				found = true
+				break
+			}
+		}
+		if found {
+			continue
+		}
+
 		language := store.Language{
 			Name:        g.Name,
 			TCGPlayerID: g.ID,
@@ -358,16 +697,43 @@ func syncLanguages(dbConn *gorm.DB, languages []*tcgplayer.Language) ([]*store.L
 		p = append(p, &language)
 	}
 
-	err := dbConn.Create(&p).Error
+	if len(p) > 0 {
+		err = dbConn.WithContext(ctx).Create(&p).Error
+		if err != nil {
+			return nil, errors.Wrap(err)
+		}
+	}
+
+	return append(existing, p...), nil
+}
+
+// syncPrintings creates the printings that don't exist yet and returns all of
+// them
+func syncPrintings(ctx context.Context, dbConn *gorm.DB, printings []*tcgplayer.Printing) ([]*store.Printing, error) {
+	ids := []int{}
+	for _, p := range printings {
+		ids = append(ids, p.ID)
+	}
+
+	existing := []*store.Printing{}
+	err := dbConn.WithContext(ctx).Where("tcgplayer_id IN ?", ids).Find(&existing).Error
 	if err != nil {
 		return nil, errors.Wrap(err)
 	}
 
-	return p, nil
-}
-func syncPrintings(dbConn *gorm.DB, printings []*tcgplayer.Printing) ([]*store.Printing, error) {
 	p := []*store.Printing{}
 	for _, g := range printings {
+		found := false
+		for _, e := range existing {
+			if e.TCGPlayerID == g.ID {
+				found = true
+				break
+			}
+		}
+		if found {
+			continue
+		}
+
 		printing := store.Printing{
 			Name:        g.Name,
 			TCGPlayerID: g.ID,
@@ -375,18 +741,20 @@ func syncPrintings(dbConn *gorm.DB, printings []*tcgplayer.Printing) ([]*store.P
 		p = append(p, &printing)
 	}
 
-	err := dbConn.Create(&p).Error
-	if err != nil {
-		return nil, errors.Wrap(err)
+	if len(p) > 0 {
+		err = dbConn.WithContext(ctx).Create(&p).Error
+		if err != nil {
+			return nil, errors.Wrap(err)
+		}
 	}
 
-	return p, nil
+	return append(existing, p...), nil
 }
 
-func syncCategories(dbConn *gorm.DB, categories []*tcgplayer.Category) ([]*store.Category, error) {
+func syncCategories(ctx context.Context, dbConn *gorm.DB, categories []*tcgplayer.Category) ([]*store.Category, error) {
 	p := []*store.Category{}
 	for _, c := range categories {
-		err := dbConn.First(&store.Category{ID: c.ID}).Error
+		err := dbConn.WithContext(ctx).First(&store.Category{ID: c.ID}).Error
 		if err == nil {
 			continue
 		}
@@ -404,7 +772,7 @@ func syncCategories(dbConn *gorm.DB, categories []*tcgplayer.Category) ([]*store
 		return nil, nil
 	}
 
-	err := dbConn.Create(&p).Error
+	err := dbConn.WithContext(ctx).Create(&p).Error
 	if err != nil {
 		return nil, errors.Wrap(err)
 	}
@@ -412,9 +780,33 @@ func syncCategories(dbConn *gorm.DB, categories []*tcgplayer.Category) ([]*store
 	return p, nil
 }
 
-func syncRarities(dbConn *gorm.DB, rarities []*tcgplayer.Rarity) ([]*store.Rarity, error) {
+// syncRarities creates the rarities that don't exist yet and returns all of
+// them
+func syncRarities(ctx context.Context, dbConn *gorm.DB, rarities []*tcgplayer.Rarity) ([]*store.Rarity, error) {
+	ids := []int{}
+	for _, r := range rarities {
+		ids = append(ids, r.ID)
+	}
+
+	existing := []*store.Rarity{}
+	err := dbConn.WithContext(ctx).Where("tcgplayer_id IN ?", ids).Find(&existing).Error
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
 	p := []*store.Rarity{}
 	for _, r := range rarities {
+		found := false
+		for _, e := range existing {
+			if e.TCGPlayerID == r.ID {
+				found = true
+				break
+			}
+		}
+		if found {
+			continue
+		}
+
 		rarity := store.Rarity{
 			Name:        r.Name,
 			TCGPlayerID: r.ID,
@@ -422,17 +814,23 @@ func syncRarities(dbConn *gorm.DB, rarities []*tcgplayer.Rarity) ([]*store.Rarit
 		p = append(p, &rarity)
 	}
 
-	err := dbConn.Create(&p).Error
-	if err != nil {
-		return nil, errors.Wrap(err)
+	if len(p) > 0 {
+		err = dbConn.WithContext(ctx).Create(&p).Error
+		if err != nil {
+			return nil, errors.Wrap(err)
+		}
 	}
 
-	return p, nil
+	return append(existing, p...), nil
 }
 
-func syncProducts(dbConn *gorm.DB, groups []*store.Group, rarities []*store.Rarity,
+func syncProducts(ctx context.Context, dbConn *gorm.DB, groups []*store.Group, rarities []*store.Rarity,
 	products []*tcgplayer.Product) ([]*store.Product, error) {
 	a := []*store.Product{}
+	if len(products) == 0 {
+		return a, nil
+	}
+
 	details := []*store.Detail{}
 	for _, p := range products {
 		// check if name exists in detailNames
@@ -448,18 +846,18 @@ func syncProducts(dbConn *gorm.DB, groups []*store.Group, rarities []*store.Rari
 		}
 	}
 
-	createdDetails, err := syncDetails(dbConn, details)
+	createdDetails, err := syncDetails(ctx, dbConn, details)
 	if err != nil {
 		return nil, errors.Wrap(err)
 	}
 
 	var defaultRarity store.Rarity
-	err = dbConn.Where("name = ?", "Unconfirmed").First(&defaultRarity).Error
+	err = dbConn.WithContext(ctx).Where("name = ?", "Unconfirmed").First(&defaultRarity).Error
 	if err != nil {
 		return nil, errors.Wrap(err)
 	}
 	commonRarity := store.Rarity{}
-	err = dbConn.Where("name = ?", rarityNameCommon).
+	err = dbConn.WithContext(ctx).Where("name = ?", rarityNameCommon).
 		First(&commonRarity).Error
 	if err != nil {
 		return nil, errors.Wrap(err)
@@ -477,7 +875,8 @@ func syncProducts(dbConn *gorm.DB, groups []*store.Group, rarities []*store.Rari
 
 		rare, err := p.GetExtendedData("Rarity")
 		if err != nil {
-			log.Println("unable to find rarity for product: ", p.Name)
+			slog.Warn("unable to find rarity for product",
+				"category", p.CategoryID, "group", p.GroupID, "product", p.Name)
 			rarityID = defaultRarity.ID
 		} else {
 			found := false
@@ -524,7 +923,11 @@ func syncProducts(dbConn *gorm.DB, groups []*store.Group, rarities []*store.Rari
 		a = append(a, &product)
 	}
 
-	err = dbConn.CreateInBatches(&a, 1000).Error
+	if len(a) == 0 {
+		return a, nil
+	}
+
+	err = dbConn.WithContext(ctx).CreateInBatches(&a, 1000).Error
 	if err != nil {
 		return nil, errors.Wrap(err)
 	}
@@ -532,15 +935,32 @@ func syncProducts(dbConn *gorm.DB, groups []*store.Group, rarities []*store.Rari
 	return a, nil
 }
 
-func syncDetails(dbConn *gorm.DB, details []*store.Detail) ([]*store.Detail, error) {
+func syncDetails(ctx context.Context, dbConn *gorm.DB, details []*store.Detail) ([]*store.Detail, error) {
+	names := []string{}
+	for _, d := range details {
+		names = append(names, d.Name)
+	}
+
+	existing := []*store.Detail{}
+	err := dbConn.WithContext(ctx).Where("name IN ?", names).Find(&existing).Error
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
 	// create each detail if it doesn't exist
 	createdDetails := []*store.Detail{}
 	for _, d := range details {
 		found := false
+		for _, c := range existing {
+			if c.Name == d.Name {
+				found = true
+				break
+			}
+		}
 		for _, c := range createdDetails {
 			if c.Name == d.Name {
 				found = true
-				continue
+				break
 			}
 		}
 
@@ -549,15 +969,17 @@ func syncDetails(dbConn *gorm.DB, details []*store.Detail) ([]*store.Detail, err
 		}
 	}
 
-	err := dbConn.CreateInBatches(&createdDetails, 1000).Error
-	if err != nil {
-		return nil, errors.Wrap(err)
+	if len(createdDetails) > 0 {
+		err = dbConn.WithContext(ctx).CreateInBatches(&createdDetails, 1000).Error
+		if err != nil {
+			return nil, errors.Wrap(err)
+		}
 	}
 
-	return createdDetails, nil
+	return append(existing, createdDetails...), nil
 }
 
-func getGroups(client Tcgplayer, categoryID int) ([]*tcgplayer.Group, error) {
+func getGroups(ctx context.Context, client Tcgplayer, categoryID int) ([]*tcgplayer.Group, error) {
 	limit := 100
 	page := 0
 	groups := []*tcgplayer.Group{}
@@ -568,7 +990,7 @@ func getGroups(client Tcgplayer, categoryID int) ([]*tcgplayer.Group, error) {
 			Offset:     limit * page,
 		}
 
-		p, err := client.GetGroups(params)
+		p, err := client.GetGroups(ctx, params)
 		if err != nil {
 			return nil, errors.Wrap(err)
 		}
@@ -581,7 +1003,8 @@ func getGroups(client Tcgplayer, categoryID int) ([]*tcgplayer.Group, error) {
 	}
 }
 
-func getProducts(client Tcgplayer, categoryID int,
+// getGroupProducts pages through the products of a single group
+func getGroupProducts(ctx context.Context, client Tcgplayer, categoryID int, group *tcgplayer.Group,
 	sleepDuration time.Duration) ([]*tcgplayer.Product, error) {
 	limit := 100
 	page := 0
@@ -589,30 +1012,39 @@ func getProducts(client Tcgplayer, categoryID int,
 	for {
 		params := tcgplayer.ProductParams{
 			CategoryID: categoryID,
+			GroupName:  group.Name,
 			Limit:      limit,
 			Offset:     limit * page,
 		}
 
-		p, err := client.ListAllProducts(params)
+		p, err := client.ListAllProducts(ctx, params)
 		if err != nil {
 			return nil, errors.Wrap(err)
 		}
-		products = append(products, p...)
+
+		// the api filters on the group name, drop products of other groups
+		// sharing it
+		for _, prod := range p {
+			if prod.GroupID == group.ID {
+				products = append(products, prod)
+			}
+		}
 		if len(p) < limit {
 			return products, nil
 		}
 
 		page++
-		time.Sleep(sleepDuration)
-		log.Println("PAGE:", page)
-		if *devMode && page > 20 {
-			return products, nil
+		slog.Debug("fetched products page", "category", categoryID, "group", group.ID,
+			"page", page, "products", len(products))
+		err = sleepContext(ctx, sleepDuration)
+		if err != nil {
+			return nil, errors.Wrap(err)
 		}
 	}
 }
 
-func getCategories(client Tcgplayer) ([]*tcgplayer.Category, error) {
-	categories, err := client.GetCategories()
+func getCategories(ctx context.Context, client Tcgplayer) ([]*tcgplayer.Category, error) {
+	categories, err := client.GetCategories(ctx)
 	if err != nil {
 		return nil, errors.Wrap(err)
 	}
@@ -620,12 +1052,12 @@ func getCategories(client Tcgplayer) ([]*tcgplayer.Category, error) {
 	return categories, nil
 }
 
-func getRarities(client Tcgplayer, categoryID int) ([]*tcgplayer.Rarity, error) {
+func getRarities(ctx context.Context, client Tcgplayer, categoryID int) ([]*tcgplayer.Rarity, error) {
 	params := &tcgplayer.RarityParams{
 		CategoryID: categoryID,
 	}
 
-	rarities, err := client.GetRarities(params)
+	rarities, err := client.GetRarities(ctx, params)
 	if err != nil {
 		return nil, errors.Wrap(err)
 	}
@@ -633,12 +1065,12 @@ func getRarities(client Tcgplayer, categoryID int) ([]*tcgplayer.Rarity, error)
 	return rarities, nil
 }
 
-func getConditions(client Tcgplayer, categoryID int) ([]*tcgplayer.Condition, error) {
+func getConditions(ctx context.Context, client Tcgplayer, categoryID int) ([]*tcgplayer.Condition, error) {
 	params := &tcgplayer.ConditionParams{
 		CategoryID: categoryID,
 	}
 
-	conditions, err := client.GetConditions(params)
+	conditions, err := client.GetConditions(ctx, params)
 	if err != nil {
 		return nil, errors.Wrap(err)
 	}
@@ -646,12 +1078,12 @@ func getConditions(client Tcgplayer, categoryID int) ([]*tcgplayer.Condition, er
 	return conditions, nil
 }
 
-func getLanguages(client Tcgplayer, categoryID int) ([]*tcgplayer.Language, error) {
+func getLanguages(ctx context.Context, client Tcgplayer, categoryID int) ([]*tcgplayer.Language, error) {
 	params := &tcgplayer.LanguageParams{
 		CategoryID: categoryID,
 	}
 
-	languages, err := client.GetLanguages(params)
+	languages, err := client.GetLanguages(ctx, params)
 	if err != nil {
 		return nil, errors.Wrap(err)
 	}
@@ -659,12 +1091,12 @@ func getLanguages(client Tcgplayer, categoryID int) ([]*tcgplayer.Language, erro
 	return languages, nil
 }
 
-func getPrintings(client Tcgplayer, categoryID int) ([]*tcgplayer.Printing, error) {
+func getPrintings(ctx context.Context, client Tcgplayer, categoryID int) ([]*tcgplayer.Printing, error) {
 	params := tcgplayer.PrintingParams{
 		CategoryID: categoryID,
 	}
 
-	printings, err := client.GetPrinting(params)
+	printings, err := client.GetPrinting(ctx, params)
 	if err != nil {
 		return nil, errors.Wrap(err)
 	}
@@ -672,12 +1104,23 @@ func getPrintings(client Tcgplayer, categoryID int) ([]*tcgplayer.Printing, erro
 	return printings, nil
 }
 
-func getDBConnection(dbHost string, dbPort string, dbUser string, dbPassword string, dbName string) (*gorm.DB, error) {
-	postgresqlDbInfo := fmt.Sprintf("host=%s port=%s user=%s "+
-		"password=%s dbname=%s sslmode=disable",
-		dbHost, dbPort, dbUser, dbPassword, dbName)
-
-	db, err := gorm.Open(postgres.Open(postgresqlDbInfo), &gorm.Config{})
+func getDBConnection(dbDriver string, dbHost string, dbPort string, dbUser string, dbPassword string,
+	dbName string) (*gorm.DB, error) {
+	var dialector gorm.Dialector
+	switch dbDriver {
+	case dbDriverPostgres:
+		postgresqlDbInfo := fmt.Sprintf("host=%s port=%s user=%s "+
+			"password=%s dbname=%s sslmode=disable",
+			dbHost, dbPort, dbUser, dbPassword, dbName)
+		dialector = postgres.Open(postgresqlDbInfo)
+	case dbDriverSQLite:
+		// wait on a locked database instead of failing right away
+		dialector = sqlite.Open(dbName + "?_pragma=busy_timeout(5000)")
+	default:
+		return nil, errors.New("unknown database driver: " + dbDriver)
+	}
+
+	db, err := gorm.Open(dialector, &gorm.Config{})
 	if err != nil {
 		return nil, errors.Wrap(err)
 	}
@@ -685,19 +1128,29 @@ func getDBConnection(dbHost string, dbPort string, dbUser string, dbPassword str
 	return db, nil
 }
 
-func dropData(dbConn *gorm.DB) error {
-	// truncate all tables
-	err := dbConn.Exec("TRUNCATE TABLE products, details, groups, rarities, conditions, languages, printings CASCADE").Error
-	if err != nil {
-		return errors.Wrap(err)
+// dropData removes the catalog, deleting children before their parents so
+// it works without TRUNCATE ... CASCADE, inside a transaction readers keep
+// seeing the old catalog until commit
+func dropData(ctx context.Context, dbConn *gorm.DB) error {
+	tables := []interface{}{
+		&store.SKU{}, &store.Product{}, &store.Detail{}, &store.Group{},
+		&store.Rarity{}, &store.Condition{}, &store.Language{}, &store.Printing{},
+	}
+
+	for _, t := range tables {
+		err := dbConn.WithContext(ctx).Session(&gorm.Session{AllowGlobalUpdate: true}).
+			Delete(t).Error
+		if err != nil {
+			return errors.Wrap(err)
+		}
 	}
 
 	return nil
 }
 
-func getDetailID(dbConn *gorm.DB, name string) (int, error) {
+func getDetailID(ctx context.Context, dbConn *gorm.DB, name string) (int, error) {
 	var detail store.Detail
-	err := dbConn.Where("name = ?", name).First(&detail).Error
+	err := dbConn.WithContext(ctx).Where("name = ?", name).First(&detail).Error
 	if err != nil {
 		return 0, errors.Wrap(err)
 	}
@@ -705,12 +1158,33 @@ func getDetailID(dbConn *gorm.DB, name string) (int, error) {
 	return detail.ID, nil
 }
 
-func trimOldPriceData(dbConn *gorm.DB, since time.Duration) error {
-	err := dbConn.Delete(&store.SKUPrice{}, "ingested_at < ?",
-		time.Now().Add(-since)).Error
-	if err != nil {
-		return errors.Wrap(err)
+// parseIDs parses a comma separated list of ids, an empty string is an empty
+// list
+func parseIDs(s string) ([]int, error) {
+	ids := []int{}
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+
+		id, err := strconv.Atoi(f)
+		if err != nil {
+			return nil, errors.Wrap(err)
+		}
+		ids = append(ids, id)
 	}
 
-	return nil
+	return ids, nil
+}
+
+// containsID reports whether id is in ids
+func containsID(ids []int, id int) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+
+	return false
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"testing"
@@ -41,13 +42,13 @@ func TestGetGroups(t *testing.T) {
 
 	client := NewMockTcgplayer(ctrl)
 
-	client.EXPECT().GetGroups(tcgplayer.GroupParams{
+	client.EXPECT().GetGroups(gomock.Any(), tcgplayer.GroupParams{
 		CategoryID: tcgplayer.CategoryYugioh,
 		Limit:      100,
 		Offset:     0,
 	}).Return([]*tcgplayer.Group{{Name: "test-group"}}, nil)
 
-	groups, err := getGroups(client, tcgplayer.CategoryYugioh)
+	groups, err := getGroups(context.Background(), client, tcgplayer.CategoryYugioh)
 	require.NoError(t, err)
 	require.Len(t, groups, 1)
 }
@@ -56,6 +57,9 @@ func TestSyncDetails(t *testing.T) {
 	dbConn, mock := GetMockDB(t)
 
 	detail := []*store.Detail{{Name: "test"}}
+	mock.ExpectQuery(`SELECT (.+) FROM \"details\"`).
+		WithArgs("test").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}))
 	mock.ExpectBegin()
 	mock.ExpectQuery("INSERT INTO \"details\" (.+) RETURNING \"id\"").
 		WithArgs("test").
@@ -63,7 +67,7 @@ func TestSyncDetails(t *testing.T) {
 			AddRow(1))
 	mock.ExpectCommit()
 
-	created, err := syncDetails(dbConn, detail)
+	created, err := syncDetails(context.Background(), dbConn, detail)
 	require.NoError(t, err)
 	require.Len(t, created, len(detail))
 }
@@ -80,13 +84,16 @@ func TestSyncGroups(t *testing.T) {
 			Name: "test-2",
 		},
 	}
+	mock.ExpectQuery(`SELECT (.+) FROM \"groups\"`).
+		WithArgs(1, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
 	mock.ExpectBegin()
 	mock.ExpectQuery(`INSERT INTO \"groups\" (.+) VALUES (.+)`).
 		WithArgs("test-1", 1, "test-2", 2).
 		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
 	mock.ExpectCommit()
 
-	created, err := syncGroups(dbConn, groups)
+	created, err := syncGroups(context.Background(), dbConn, groups)
 	require.NoError(t, err)
 	require.Len(t, created, len(groups))
 }
@@ -166,89 +173,115 @@ func TestUpdateImmutableDataTcgPlayer(t *testing.T) {
 		},
 	}
 
-	mock.ExpectQuery(`SELECT \"tcgplayer_id\" FROM \"groups\"`).WillReturnRows(sqlmock.NewRows([]string{"tcgplayer_id"}).
-		AddRow(1))
+	// Get Groups
+	client.EXPECT().GetGroups(gomock.Any(), tcgplayer.GroupParams{
+		CategoryID: tcgplayer.CategoryYugioh,
+		Limit:      100,
+		Offset:     0,
+	}).Return(tcgGroups, nil)
+
+	// neither group has been crawled before
+	mock.ExpectQuery(`SELECT (.+) FROM \"ingest_group_syncs\"`).
+		WithArgs(1, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"tcgplayer_id", "published_on"}))
 
-	// truncate the tables
-	mock.ExpectExec(`TRUNCATE TABLE products, details, groups, rarities, conditions, languages, printings CASCADE`).
-		WillReturnResult(sqlmock.NewResult(0, 0))
+	client.EXPECT().GetRarities(gomock.Any(), &tcgplayer.RarityParams{
+		CategoryID: tcgplayer.CategoryYugioh,
+	}).Return(rarities, nil)
+	client.EXPECT().GetPrinting(gomock.Any(), tcgplayer.PrintingParams{
+		CategoryID: tcgplayer.CategoryYugioh,
+	}).Return(printings, nil)
+	client.EXPECT().GetConditions(gomock.Any(), &tcgplayer.ConditionParams{
+		CategoryID: tcgplayer.CategoryYugioh,
+	}).Return(conditions, nil)
+	client.EXPECT().GetLanguages(gomock.Any(), &tcgplayer.LanguageParams{
+		CategoryID: tcgplayer.CategoryYugioh,
+	}).Return(languages, nil)
 
-	// Get Groups and Sync
-	client.EXPECT().GetGroups(tcgplayer.GroupParams{
+	// Get products, one group at a time
+	client.EXPECT().ListAllProducts(gomock.Any(), tcgplayer.ProductParams{
 		CategoryID: tcgplayer.CategoryYugioh,
+		GroupName:  "test-1",
 		Limit:      100,
 		Offset:     0,
-	}).Return(tcgGroups, nil)
+	}).Return(products, nil)
+	client.EXPECT().ListAllProducts(gomock.Any(), tcgplayer.ProductParams{
+		CategoryID: tcgplayer.CategoryYugioh,
+		GroupName:  "test-2",
+		Limit:      100,
+		Offset:     0,
+	}).Return([]*tcgplayer.Product{}, nil)
 
-	// inserts the groups
+	// every write happens in one transaction
 	mock.ExpectBegin()
+
+	// neither group is known yet
+	mock.ExpectQuery(`SELECT \"tcgplayer_id\" FROM \"groups\"`).
+		WithArgs(1, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"tcgplayer_id"}))
+
+	// inserts the groups
+	mock.ExpectQuery(`SELECT (.+) FROM \"groups\"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
 	mock.ExpectQuery(`INSERT INTO \"groups\" (.+)`).
 		WithArgs("test-1", 1, "test-2", 2).
 		WillReturnRows(sqlmock.NewRows([]string{"id"}).
 			AddRow(1).
 			AddRow(2))
-	mock.ExpectCommit()
-
-	// Get rarities and sync
-	client.EXPECT().GetRarities(&tcgplayer.RarityParams{
-		CategoryID: tcgplayer.CategoryYugioh,
-	}).Return(rarities, nil)
 
 	// insert the rarities
-	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT (.+) FROM \"rarities\"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
 	mock.ExpectQuery(`INSERT INTO \"rarities\" (.+)`).
 		WithArgs("Common", 1).
 		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
-	mock.ExpectCommit()
-
-	// Get printings and sync
-	client.EXPECT().GetPrinting(tcgplayer.PrintingParams{
-		CategoryID: tcgplayer.CategoryYugioh,
-	}).Return(printings, nil)
 
 	// insert the printings
-	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT (.+) FROM \"printings\"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
 	mock.ExpectQuery(`INSERT INTO \"printings\" (.+)`).
 		WithArgs("1st Edition", 1).
 		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
-	mock.ExpectCommit()
-
-	// Get conditions and sync
-	client.EXPECT().GetConditions(&tcgplayer.ConditionParams{
-		CategoryID: tcgplayer.CategoryYugioh,
-	}).Return(conditions, nil)
 
 	// insert the conditions
-	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT (.+) FROM \"conditions\"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
 	mock.ExpectQuery(`INSERT INTO \"conditions\" (.+)`).
 		WithArgs("Near Mint", "NM", 1).
 		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
-	mock.ExpectCommit()
-
-	// Get languages and sync
-	client.EXPECT().GetLanguages(&tcgplayer.LanguageParams{
-		CategoryID: tcgplayer.CategoryYugioh,
-	}).Return(languages, nil)
 
-	// insert the conditions
-	mock.ExpectBegin()
+	// insert the languages
+	mock.ExpectQuery(`SELECT (.+) FROM \"languages\"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
 	mock.ExpectQuery(`INSERT INTO \"languages\" (.+)`).
 		WithArgs("English", 1).
 		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
-	mock.ExpectCommit()
 
-	// Get products and sync
-	client.EXPECT().ListAllProducts(tcgplayer.ProductParams{
-		CategoryID: tcgplayer.CategoryYugioh,
-		Limit:      100,
-		Offset:     0,
-	}).Return(products, nil)
+	mock.ExpectQuery(`SELECT \"tcgplayer_id\" FROM \"products\"`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"tcgplayer_id"}))
 
-	mock.ExpectBegin()
-	mock.ExpectQuery("(.+)").
+	// replace the products of the crawled groups
+	mock.ExpectExec(`DELETE FROM \"skus\" WHERE product_id IN \(SELECT \"id\" FROM \"products\" WHERE group_id IN `+
+		`\(SELECT \"id\" FROM \"groups\" WHERE tcgplayer_id IN (.+)\) OR tcgplayer_id IN (.+)\)`).
+		WithArgs(1, 2, 1).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`DELETE FROM \"products\" WHERE group_id IN \(SELECT \"id\" FROM \"groups\" (.+)\) OR tcgplayer_id IN (.+)`).
+		WithArgs(1, 2, 1).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	mock.ExpectQuery(`SELECT (.+) FROM \"groups\"`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "tcgplayer_id"}).AddRow(1, 1))
+	mock.ExpectQuery(`SELECT (.+) FROM \"rarities\"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "tcgplayer_id"}).AddRow(1, "Common", 1))
+
+	mock.ExpectQuery(`SELECT (.+) FROM \"details\"`).
+		WithArgs("test-name").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}))
+	mock.ExpectQuery(`INSERT INTO \"details\" (.+)`).
 		WithArgs("test-name").
 		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
-	mock.ExpectCommit()
 
 	mock.ExpectQuery(`SELECT (.+) FROM \"rarities\"`).
 		WithArgs(defaultRarityName).
@@ -259,21 +292,30 @@ func TestUpdateImmutableDataTcgPlayer(t *testing.T) {
 		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
 
 	// insert the products
-	mock.ExpectBegin()
 	mock.ExpectQuery(`INSERT INTO \"products\" (.+)`).
 		WithArgs(tcgplayer.CategoryYugioh, 1, 1, 1, "test-image-url", 1, "test-url").
 		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
-	mock.ExpectCommit()
 
 	// insert the skus
-	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT (.+) FROM \"languages\"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "tcgplayer_id"}).AddRow(1, 1))
+	mock.ExpectQuery(`SELECT (.+) FROM \"conditions\"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "tcgplayer_id"}).AddRow(1, 1))
+	mock.ExpectQuery(`SELECT (.+) FROM \"printings\"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "tcgplayer_id"}).AddRow(1, 1))
 	mock.ExpectQuery(`INSERT INTO \"skus\" (.+)`).
 		WithArgs(1, 1, 1, 1, 1).
 		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+
+	// remember both groups were crawled
+	mock.ExpectExec(`INSERT INTO \"ingest_group_syncs\" (.+) ON CONFLICT`).
+		WillReturnResult(sqlmock.NewResult(0, 2))
 	mock.ExpectCommit()
 
-	err := updateImmutableDataTcgPlayer(dbConn, client, tcgplayer.CategoryYugioh)
+	err := updateImmutableDataTcgPlayer(context.Background(), newSQLStore(dbConn), client, nil, tcgplayer.CategoryYugioh,
+		catalogOptions{})
 	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
 }
 
 func TestIngestPrice(t *testing.T) {
@@ -287,21 +329,55 @@ func TestIngestPrice(t *testing.T) {
 
 	mock.ExpectQuery("SELECT \"tcgplayer_id\" FROM \"skus\"").
 		WillReturnRows(sqlmock.NewRows([]string{"tcgplayer_id"}).AddRow(1))
-	client.EXPECT().GetSKUPrices([]int{skuID}).
+	client.EXPECT().GetSKUPrices(gomock.Any(), []int{skuID}).
 		Return([]*tcgplayer.SKUMarketPrice{
 			{SKUID: skuID, LowPrice: 1.0, LowestShipping: 0.1},
 		}, nil)
 
 	mock.ExpectBegin()
 	mock.ExpectQuery("INSERT INTO \"sku_prices\" (.+)").
-		WithArgs(skuID, price, shipping).WillReturnRows(sqlmock.NewRows([]string{"ingested_at", "id"}).AddRow(time.Now(), 1))
+		WithArgs(skuID, price, shipping, int64(100), int64(10), priceCurrency).WillReturnRows(sqlmock.NewRows([]string{"ingested_at", "id"}).AddRow(time.Now(), 1))
 	mock.ExpectCommit()
 
-	err := ingetPrices(dbConn, client, 0)
+	err := ingetPrices(context.Background(), newSQLStore(dbConn), client, nil, 0, testFlushPolicy)
 	require.NoError(t, err)
 
 }
 
+func TestIngestSKUPrices_Batches(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	client := NewMockTcgplayer(ctrl)
+	s := newMemoryStore()
+
+	skus := []int{}
+	for i := 1; i <= priceBatchSize*2+50; i++ {
+		skus = append(skus, i)
+	}
+
+	// every sku is requested once, in full batches but the last
+	requested := map[int]int{}
+	sizes := []int{}
+	client.EXPECT().GetSKUPrices(gomock.Any(), gomock.Any()).Times(3).
+		DoAndReturn(func(ctx context.Context, batch []int) ([]*tcgplayer.SKUMarketPrice, error) {
+			sizes = append(sizes, len(batch))
+			prices := []*tcgplayer.SKUMarketPrice{}
+			for _, skuID := range batch {
+				requested[skuID]++
+				prices = append(prices, &tcgplayer.SKUMarketPrice{SKUID: skuID, LowPrice: 1})
+			}
+			return prices, nil
+		})
+
+	err := ingestSKUPrices(context.Background(), s, client, nil, skus, 0, testFlushPolicy)
+	require.NoError(t, err)
+	require.Equal(t, []int{priceBatchSize, priceBatchSize, 50}, sizes)
+	require.Len(t, requested, len(skus))
+	for skuID, times := range requested {
+		require.Equal(t, 1, times, skuID)
+	}
+	require.Len(t, s.prices, len(skus))
+}
+
 func TestIngestPrice_ClientError(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	client := NewMockTcgplayer(ctrl)
@@ -311,9 +387,128 @@ func TestIngestPrice_ClientError(t *testing.T) {
 
 	mock.ExpectQuery("SELECT \"tcgplayer_id\" FROM \"skus\"").
 		WillReturnRows(sqlmock.NewRows([]string{"tcgplayer_id"}).AddRow(skuID))
-	client.EXPECT().GetSKUPrices([]int{skuID}).
+	client.EXPECT().GetSKUPrices(gomock.Any(), []int{skuID}).
 		Return(nil, errors.New("unable to get prices"))
 
-	err := ingetPrices(dbConn, client, 0)
+	err := ingetPrices(context.Background(), newSQLStore(dbConn), client, nil, 0, testFlushPolicy)
+	require.Error(t, err)
+}
+
+func TestIngestPrice_Cancelled(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	client := NewMockTcgplayer(ctrl)
+	dbConn, mock := GetMockDB(t)
+
+	skuID := 1
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mock.ExpectQuery("SELECT \"tcgplayer_id\" FROM \"skus\"").
+		WillReturnRows(sqlmock.NewRows([]string{"tcgplayer_id"}).AddRow(skuID))
+
+	// cancel while the batch is in flight, it should still be written
+	client.EXPECT().GetSKUPrices(gomock.Any(), []int{skuID}).
+		DoAndReturn(func(context.Context, []int) ([]*tcgplayer.SKUMarketPrice, error) {
+			cancel()
+			return []*tcgplayer.SKUMarketPrice{
+				{SKUID: skuID, LowPrice: 1.0, LowestShipping: 0.1},
+			}, nil
+		})
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO \"sku_prices\" (.+)").
+		WillReturnRows(sqlmock.NewRows([]string{"ingested_at", "id"}).AddRow(time.Now(), 1))
+	mock.ExpectCommit()
+
+	err := ingetPrices(ctx, newSQLStore(dbConn), client, nil, time.Second, testFlushPolicy)
 	require.Error(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateImmutableDataTcgPlayer_FetchErrorLeavesCatalog(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	client := NewMockTcgplayer(ctrl)
+	dbConn, mock := GetMockDB(t)
+
+	client.EXPECT().GetGroups(gomock.Any(), gomock.Any()).
+		Return([]*tcgplayer.Group{{ID: 1, Name: "test-1"}}, nil)
+	mock.ExpectQuery(`SELECT (.+) FROM \"ingest_group_syncs\"`).
+		WillReturnRows(sqlmock.NewRows([]string{"tcgplayer_id", "published_on"}))
+
+	client.EXPECT().GetRarities(gomock.Any(), gomock.Any()).
+		Return(nil, errors.New("unable to get rarities"))
+
+	// nothing is written when the api fails
+	err := updateImmutableDataTcgPlayer(context.Background(), newSQLStore(dbConn), client, nil, tcgplayer.CategoryYugioh,
+		catalogOptions{})
+	require.Error(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateImmutableDataTcgPlayer_SkipsUnchangedGroups(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	client := NewMockTcgplayer(ctrl)
+	dbConn, mock := GetMockDB(t)
+
+	client.EXPECT().GetGroups(gomock.Any(), gomock.Any()).
+		Return([]*tcgplayer.Group{
+			{ID: 1, Name: "test-1", PublishedOn: "2023-01-01"},
+			{ID: 2, Name: "test-2", PublishedOn: "2023-02-01"},
+		}, nil)
+
+	// only group 2 is targeted and it was crawled at the same publishedOn
+	mock.ExpectQuery(`SELECT (.+) FROM \"ingest_group_syncs\"`).
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"tcgplayer_id", "published_on"}).
+			AddRow(2, "2023-02-01"))
+
+	err := updateImmutableDataTcgPlayer(context.Background(), newSQLStore(dbConn), client, nil, tcgplayer.CategoryYugioh,
+		catalogOptions{groupIDs: []int{2}})
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateImmutableDataTcgPlayer_GroupFailureIsolated(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	client := NewMockTcgplayer(ctrl)
+	s := newMemoryStore()
+
+	_, err := s.UpsertProducts(ctx, nil, []*tcgplayer.Product{
+		{ID: 10, GroupID: 1},
+		{ID: 20, GroupID: 2},
+	})
+	require.NoError(t, err)
+
+	client.EXPECT().GetGroups(gomock.Any(), gomock.Any()).
+		Return([]*tcgplayer.Group{{ID: 1, Name: "test-1"}, {ID: 2, Name: "test-2"}}, nil)
+
+	client.EXPECT().GetRarities(gomock.Any(), gomock.Any()).Return(nil, nil)
+	client.EXPECT().GetPrinting(gomock.Any(), gomock.Any()).Return(nil, nil)
+	client.EXPECT().GetConditions(gomock.Any(), gomock.Any()).Return(nil, nil)
+	client.EXPECT().GetLanguages(gomock.Any(), gomock.Any()).Return(nil, nil)
+
+	client.EXPECT().ListAllProducts(gomock.Any(), tcgplayer.ProductParams{
+		CategoryID: tcgplayer.CategoryYugioh,
+		GroupName:  "test-1",
+		Limit:      100,
+	}).Return([]*tcgplayer.Product{{ID: 11, GroupID: 1}}, nil)
+	client.EXPECT().ListAllProducts(gomock.Any(), tcgplayer.ProductParams{
+		CategoryID: tcgplayer.CategoryYugioh,
+		GroupName:  "test-2",
+		Limit:      100,
+	}).Return(nil, errors.New("unable to list products"))
+
+	err = updateImmutableDataTcgPlayer(ctx, s, client, nil, tcgplayer.CategoryYugioh, catalogOptions{})
+	require.Error(t, err)
+
+	// only the products of group 1 are replaced
+	known, err := s.KnownProductIDs(ctx, []int{10, 11, 20})
+	require.NoError(t, err)
+	require.ElementsMatch(t, []int{11, 20}, known)
+
+	// group 2 is crawled again on the next run
+	synced, err := s.SyncedGroups(ctx, []int{1, 2})
+	require.NoError(t, err)
+	require.Equal(t, map[int]string{1: ""}, synced)
 }
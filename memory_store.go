@@ -0,0 +1,487 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/AustinMCrane/tcgplayer"
+)
+
+// englishLanguageID is the tcgplayer id of english, the only language whose
+// skus are kept
+const englishLanguageID = 1
+
+// memoryStore keeps the catalog and prices in memory, it backs dry runs and
+// tests
+type memoryStore struct {
+	mu sync.Mutex
+
+	categories map[int]*tcgplayer.Category
+	groups     map[int]*tcgplayer.Group
+	rarities   map[int]*tcgplayer.Rarity
+	printings  map[int]*tcgplayer.Printing
+	conditions map[int]*tcgplayer.Condition
+	languages  map[int]*tcgplayer.Language
+	products   map[int]*tcgplayer.Product
+	syncs      map[int]string
+	watchlist  []watchEntry
+	prices     []skuPrice
+	runs       []*ingestRun
+
+	productPrices []*productPrice
+	groupValues   []*groupValue
+	// rates are keyed by day and currency
+	rates map[string]*exchangeRate
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		categories: map[int]*tcgplayer.Category{},
+		groups:     map[int]*tcgplayer.Group{},
+		rarities:   map[int]*tcgplayer.Rarity{},
+		printings:  map[int]*tcgplayer.Printing{},
+		conditions: map[int]*tcgplayer.Condition{},
+		languages:  map[int]*tcgplayer.Language{},
+		products:   map[int]*tcgplayer.Product{},
+		syncs:      map[int]string{},
+		rates:      map[string]*exchangeRate{},
+	}
+}
+
+func copyMap[V any](m map[int]V) map[int]V {
+	c := make(map[int]V, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+
+	return c
+}
+
+// clone returns a copy of the store, its maps and slices are copied so
+// writes to one don't show in the other, the stored values are shared
+func (m *memoryStore) clone() *memoryStore {
+	rates := make(map[string]*exchangeRate, len(m.rates))
+	for k, v := range m.rates {
+		rates[k] = v
+	}
+
+	return &memoryStore{
+		categories:    copyMap(m.categories),
+		groups:        copyMap(m.groups),
+		rarities:      copyMap(m.rarities),
+		printings:     copyMap(m.printings),
+		conditions:    copyMap(m.conditions),
+		languages:     copyMap(m.languages),
+		products:      copyMap(m.products),
+		syncs:         copyMap(m.syncs),
+		watchlist:     append([]watchEntry{}, m.watchlist...),
+		prices:        append([]skuPrice{}, m.prices...),
+		runs:          append([]*ingestRun{}, m.runs...),
+		productPrices: append([]*productPrice{}, m.productPrices...),
+		groupValues:   append([]*groupValue{}, m.groupValues...),
+		rates:         rates,
+	}
+}
+
+// Transaction runs fn against a copy of the store, the copy replaces the
+// store once fn succeeds
+func (m *memoryStore) Transaction(ctx context.Context, fn func(s Store) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tx := m.clone()
+	err := fn(tx)
+	if err != nil {
+		return err
+	}
+
+	m.categories = tx.categories
+	m.groups = tx.groups
+	m.rarities = tx.rarities
+	m.printings = tx.printings
+	m.conditions = tx.conditions
+	m.languages = tx.languages
+	m.products = tx.products
+	m.syncs = tx.syncs
+	m.watchlist = tx.watchlist
+	m.prices = tx.prices
+	m.runs = tx.runs
+	m.productPrices = tx.productPrices
+	m.groupValues = tx.groupValues
+	m.rates = tx.rates
+	return nil
+}
+
+func (m *memoryStore) Truncate(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.groups = map[int]*tcgplayer.Group{}
+	m.rarities = map[int]*tcgplayer.Rarity{}
+	m.printings = map[int]*tcgplayer.Printing{}
+	m.conditions = map[int]*tcgplayer.Condition{}
+	m.languages = map[int]*tcgplayer.Language{}
+	m.products = map[int]*tcgplayer.Product{}
+	return nil
+}
+
+func (m *memoryStore) UpsertCategories(ctx context.Context, categories []*tcgplayer.Category) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, c := range categories {
+		m.categories[c.ID] = c
+	}
+
+	return nil
+}
+
+func (m *memoryStore) UpsertGroups(ctx context.Context, groups []*tcgplayer.Group) ([]*tcgplayer.Group, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	added := []*tcgplayer.Group{}
+	for _, g := range groups {
+		if _, ok := m.groups[g.ID]; !ok {
+			added = append(added, g)
+		}
+		m.groups[g.ID] = g
+	}
+
+	return added, nil
+}
+
+func (m *memoryStore) UpsertRarities(ctx context.Context, rarities []*tcgplayer.Rarity) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, r := range rarities {
+		m.rarities[r.ID] = r
+	}
+
+	return nil
+}
+
+func (m *memoryStore) UpsertPrintings(ctx context.Context, printings []*tcgplayer.Printing) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, p := range printings {
+		m.printings[p.ID] = p
+	}
+
+	return nil
+}
+
+func (m *memoryStore) UpsertConditions(ctx context.Context, conditions []*tcgplayer.Condition) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, c := range conditions {
+		m.conditions[c.ID] = c
+	}
+
+	return nil
+}
+
+func (m *memoryStore) UpsertLanguages(ctx context.Context, languages []*tcgplayer.Language) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, l := range languages {
+		m.languages[l.ID] = l
+	}
+
+	return nil
+}
+
+func (m *memoryStore) UpsertProducts(ctx context.Context, groupIDs []int,
+	products []*tcgplayer.Product) ([]*tcgplayer.Product, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	added := []*tcgplayer.Product{}
+	for _, p := range products {
+		if _, ok := m.products[p.ID]; !ok {
+			added = append(added, p)
+		}
+	}
+
+	for id, p := range m.products {
+		if containsID(groupIDs, p.GroupID) {
+			delete(m.products, id)
+		}
+	}
+
+	for _, p := range products {
+		m.products[p.ID] = p
+	}
+
+	return added, nil
+}
+
+func (m *memoryStore) KnownProductIDs(ctx context.Context, ids []int) ([]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	known := []int{}
+	for _, id := range ids {
+		if _, ok := m.products[id]; ok {
+			known = append(known, id)
+		}
+	}
+
+	return known, nil
+}
+
+func (m *memoryStore) SyncedGroups(ctx context.Context, ids []int) (map[int]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	publishedOn := map[int]string{}
+	for _, id := range ids {
+		if p, ok := m.syncs[id]; ok {
+			publishedOn[id] = p
+		}
+	}
+
+	return publishedOn, nil
+}
+
+func (m *memoryStore) MarkGroupsSynced(ctx context.Context, groups []*tcgplayer.Group) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, g := range groups {
+		m.syncs[g.ID] = g.PublishedOn
+	}
+
+	return nil
+}
+
+// ListSKUIDs returns the ids of the english skus, the only ones the sql store
+// keeps
+func (m *memoryStore) ListSKUIDs(ctx context.Context) ([]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ids := []int{}
+	for _, p := range m.products {
+		for _, s := range p.SKUS {
+			if s.LanguageID == englishLanguageID {
+				ids = append(ids, s.SKUID)
+			}
+		}
+	}
+	sort.Ints(ids)
+
+	return ids, nil
+}
+
+func (m *memoryStore) WatchedSKUIDs(ctx context.Context) ([]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ids := []int{}
+	for id := range m.watched() {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	return ids, nil
+}
+
+// watched returns the english skus any watchlist entry matches, m.mu must
+// be held
+func (m *memoryStore) watched() map[int]bool {
+	watched := map[int]bool{}
+	for _, p := range m.products {
+		rarity := m.storedRarity(p)
+		for _, s := range p.SKUS {
+			if s.LanguageID != englishLanguageID {
+				continue
+			}
+			for _, e := range m.watchlist {
+				if e.watches(p, rarity, s.SKUID) {
+					watched[s.SKUID] = true
+					break
+				}
+			}
+		}
+	}
+
+	return watched
+}
+
+func (m *memoryStore) InsertPrices(ctx context.Context, prices []skuPrice) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, p := range prices {
+		if p.IngestedAt.IsZero() {
+			p.IngestedAt = time.Now()
+		}
+		if p.Currency == "" {
+			p.Currency = priceCurrency
+		}
+		m.prices = append(m.prices, p)
+	}
+
+	return nil
+}
+
+func (m *memoryStore) PricedSKUIDs(ctx context.Context, ingestedAt time.Time, skuIDs []int) ([]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	priced := []int{}
+	for _, p := range m.prices {
+		if p.IngestedAt.Equal(ingestedAt) && containsID(skuIDs, p.SKUID) && !containsID(priced, p.SKUID) {
+			priced = append(priced, p.SKUID)
+		}
+	}
+
+	return priced, nil
+}
+
+func (m *memoryStore) LatestPrices(ctx context.Context) ([]latestPrice, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	latest := map[int]skuPrice{}
+	for _, p := range m.prices {
+		if l, ok := latest[p.SKUID]; !ok || p.IngestedAt.After(l.IngestedAt) {
+			latest[p.SKUID] = p
+		}
+	}
+
+	prices := []latestPrice{}
+	for _, p := range m.products {
+		for _, s := range p.SKUS {
+			price, ok := latest[s.SKUID]
+			if !ok {
+				continue
+			}
+
+			condition := ""
+			if c, ok := m.conditions[s.ConditionID]; ok {
+				condition = c.Name
+			}
+			prices = append(prices, latestPrice{SKUID: s.SKUID, ProductID: p.ID, GroupID: p.GroupID,
+				Rarity: m.storedRarity(p), Condition: condition, Price: price.PriceCents,
+				Currency: price.Currency, IngestedAt: price.IngestedAt})
+		}
+	}
+
+	return prices, nil
+}
+
+func (m *memoryStore) WriteAggregates(ctx context.Context, products []*productPrice, groups []*groupValue) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.productPrices = products
+	m.groupValues = append(m.groupValues, groups...)
+	return nil
+}
+
+func (m *memoryStore) UpsertExchangeRates(ctx context.Context, rates []*exchangeRate) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, r := range rates {
+		m.rates[r.Day+" "+r.Currency] = r
+	}
+
+	return nil
+}
+
+func (m *memoryStore) RecordRun(ctx context.Context, run *ingestRun) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.runs = append(m.runs, run)
+	return nil
+}
+
+func (m *memoryStore) PriceStats(ctx context.Context, since time.Time) (map[int]priceStats, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := map[int]priceStats{}
+	for _, p := range m.prices {
+		if p.IngestedAt.Before(since) {
+			continue
+		}
+
+		s := stats[p.SKUID]
+		price := float64(p.Price)
+		s.SKUID = p.SKUID
+		s.Mean = (s.Mean*float64(s.Samples) + price) / float64(s.Samples+1)
+		s.MeanSquare = (s.MeanSquare*float64(s.Samples) + price*price) / float64(s.Samples+1)
+		s.Samples++
+		if p.IngestedAt.After(s.LastIngestedAt) {
+			s.LastIngestedAt = p.IngestedAt
+		}
+		stats[p.SKUID] = s
+	}
+
+	return stats, nil
+}
+
+// storedRarity is the name of the rarity the sql store links the product
+// to, rarities it doesn't know are stored as defaultRarityName
+func (m *memoryStore) storedRarity(p *tcgplayer.Product) string {
+	rarity := productRarity(p)
+	for _, r := range m.rarities {
+		if r.Name == rarity {
+			return rarity
+		}
+	}
+
+	return defaultRarityName
+}
+
+func (m *memoryStore) TrimPrices(ctx context.Context, policy *retentionPolicy,
+	now time.Time) ([]retentionResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	watched := m.watched()
+	products := map[int]*tcgplayer.Product{}
+	for _, p := range m.products {
+		for _, s := range p.SKUS {
+			products[s.SKUID] = p
+		}
+	}
+
+	results := make([]retentionResult, len(policy.Rules)+1)
+	for i, r := range policy.Rules {
+		results[i].Rule = r.Name
+	}
+	results[len(policy.Rules)].Rule = defaultRetentionRule
+
+	kept := []skuPrice{}
+	for _, price := range m.prices {
+		i, days := len(policy.Rules), policy.DefaultDays
+		if p, ok := products[price.SKUID]; ok {
+			for j, r := range policy.Rules {
+				if r.matches(p, m.storedRarity(p), watched[price.SKUID]) {
+					i, days = j, r.Days
+					break
+				}
+			}
+		}
+
+		if days == 0 || !price.IngestedAt.Before(cutoff(now, days)) {
+			kept = append(kept, price)
+			continue
+		}
+		results[i].Trimmed++
+	}
+
+	m.prices = kept
+	return results, nil
+}
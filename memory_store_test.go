@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/AustinMCrane/tcgplayer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryStore_TransactionRollback(t *testing.T) {
+	ctx := context.Background()
+	s := newMemoryStore()
+
+	err := s.Transaction(ctx, func(tx Store) error {
+		_, err := tx.UpsertGroups(ctx, []*tcgplayer.Group{{ID: 1}})
+		require.NoError(t, err)
+		return errors.New("rollback")
+	})
+	require.Error(t, err)
+
+	added, err := s.UpsertGroups(ctx, []*tcgplayer.Group{{ID: 1}})
+	require.NoError(t, err)
+	require.Len(t, added, 1)
+}
+
+func TestMemoryStore_TransactionKeepsEveryWrite(t *testing.T) {
+	ctx := context.Background()
+	s := newMemoryStore()
+	s.watchlist = []watchEntry{{Kind: watchKindSKU, TCGPlayerID: 10}}
+
+	// a rolled back transaction leaves nothing behind
+	err := s.Transaction(ctx, func(tx Store) error {
+		require.NoError(t, tx.RecordRun(ctx, &ingestRun{RunID: "run-1"}))
+		require.NoError(t, tx.UpsertExchangeRates(ctx, []*exchangeRate{{Day: "2024-01-02", Currency: "EUR"}}))
+		require.NoError(t, tx.WriteAggregates(ctx, []*productPrice{{ProductID: 1}}, []*groupValue{{GroupID: 1}}))
+		return errors.New("rollback")
+	})
+	require.Error(t, err)
+	require.Empty(t, s.runs)
+	require.Empty(t, s.rates)
+	require.Empty(t, s.productPrices)
+	require.Empty(t, s.groupValues)
+
+	// a committed one keeps every write and the state it didn't touch
+	err = s.Transaction(ctx, func(tx Store) error {
+		require.NoError(t, tx.RecordRun(ctx, &ingestRun{RunID: "run-2"}))
+		require.NoError(t, tx.UpsertExchangeRates(ctx, []*exchangeRate{{Day: "2024-01-02", Currency: "EUR"}}))
+		return tx.WriteAggregates(ctx, []*productPrice{{ProductID: 1}}, []*groupValue{{GroupID: 1}})
+	})
+	require.NoError(t, err)
+	require.Len(t, s.runs, 1)
+	require.Len(t, s.rates, 1)
+	require.Len(t, s.productPrices, 1)
+	require.Len(t, s.groupValues, 1)
+	require.Len(t, s.watchlist, 1)
+}
+
+func TestMemoryStore_UpsertProducts(t *testing.T) {
+	ctx := context.Background()
+	s := newMemoryStore()
+
+	added, err := s.UpsertProducts(ctx, nil, []*tcgplayer.Product{
+		{ID: 1, GroupID: 1, SKUS: []tcgplayer.SKU{{SKUID: 10, LanguageID: englishLanguageID}}},
+		{ID: 2, GroupID: 1, SKUS: []tcgplayer.SKU{{SKUID: 20, LanguageID: 2}}},
+		{ID: 3, GroupID: 2, SKUS: []tcgplayer.SKU{{SKUID: 30, LanguageID: englishLanguageID}}},
+	})
+	require.NoError(t, err)
+	require.Len(t, added, 3)
+
+	// group 1 is replaced, product 2 is no longer listed
+	added, err = s.UpsertProducts(ctx, []int{1}, []*tcgplayer.Product{
+		{ID: 1, GroupID: 1, SKUS: []tcgplayer.SKU{{SKUID: 11, LanguageID: englishLanguageID}}},
+		{ID: 4, GroupID: 1},
+	})
+	require.NoError(t, err)
+	require.Len(t, added, 1)
+	require.Equal(t, 4, added[0].ID)
+
+	known, err := s.KnownProductIDs(ctx, []int{1, 2, 3, 4})
+	require.NoError(t, err)
+	require.Equal(t, []int{1, 3, 4}, known)
+
+	skus, err := s.ListSKUIDs(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []int{11, 30}, skus)
+}
+
+func TestMemoryStore_TrimPrices(t *testing.T) {
+	ctx := context.Background()
+	s := newMemoryStore()
+	now := time.Now()
+
+	err := s.InsertPrices(ctx, []skuPrice{
+		{SKUID: 1, IngestedAt: now.Add(-time.Hour * 48)},
+		{SKUID: 1, IngestedAt: now},
+		{SKUID: 2},
+	})
+	require.NoError(t, err)
+
+	trimmed, err := s.TrimPrices(ctx, &retentionPolicy{DefaultDays: 1}, now)
+	require.NoError(t, err)
+	require.Equal(t, []retentionResult{{Rule: defaultRetentionRule, Trimmed: 1}}, trimmed)
+	require.Len(t, s.prices, 2)
+}
@@ -5,6 +5,7 @@
 package main
 
 import (
+	context "context"
 	reflect "reflect"
 
 	tcgplayer "github.com/AustinMCrane/tcgplayer"
@@ -35,136 +36,136 @@ func (m *MockTcgplayer) EXPECT() *MockTcgplayerMockRecorder {
 }
 
 // GetCategories mocks base method.
-func (m *MockTcgplayer) GetCategories() ([]*tcgplayer.Category, error) {
+func (m *MockTcgplayer) GetCategories(ctx context.Context) ([]*tcgplayer.Category, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetCategories")
+	ret := m.ctrl.Call(m, "GetCategories", ctx)
 	ret0, _ := ret[0].([]*tcgplayer.Category)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetCategories indicates an expected call of GetCategories.
-func (mr *MockTcgplayerMockRecorder) GetCategories() *gomock.Call {
+func (mr *MockTcgplayerMockRecorder) GetCategories(ctx interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCategories", reflect.TypeOf((*MockTcgplayer)(nil).GetCategories))
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCategories", reflect.TypeOf((*MockTcgplayer)(nil).GetCategories), ctx)
 }
 
 // GetConditions mocks base method.
-func (m *MockTcgplayer) GetConditions(params *tcgplayer.ConditionParams) ([]*tcgplayer.Condition, error) {
+func (m *MockTcgplayer) GetConditions(ctx context.Context, params *tcgplayer.ConditionParams) ([]*tcgplayer.Condition, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetConditions", params)
+	ret := m.ctrl.Call(m, "GetConditions", ctx, params)
 	ret0, _ := ret[0].([]*tcgplayer.Condition)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetConditions indicates an expected call of GetConditions.
-func (mr *MockTcgplayerMockRecorder) GetConditions(params interface{}) *gomock.Call {
+func (mr *MockTcgplayerMockRecorder) GetConditions(ctx, params interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetConditions", reflect.TypeOf((*MockTcgplayer)(nil).GetConditions), params)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetConditions", reflect.TypeOf((*MockTcgplayer)(nil).GetConditions), ctx, params)
 }
 
 // GetGroups mocks base method.
-func (m *MockTcgplayer) GetGroups(arg0 tcgplayer.GroupParams) ([]*tcgplayer.Group, error) {
+func (m *MockTcgplayer) GetGroups(ctx context.Context, params tcgplayer.GroupParams) ([]*tcgplayer.Group, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetGroups", arg0)
+	ret := m.ctrl.Call(m, "GetGroups", ctx, params)
 	ret0, _ := ret[0].([]*tcgplayer.Group)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetGroups indicates an expected call of GetGroups.
-func (mr *MockTcgplayerMockRecorder) GetGroups(arg0 interface{}) *gomock.Call {
+func (mr *MockTcgplayerMockRecorder) GetGroups(ctx, params interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetGroups", reflect.TypeOf((*MockTcgplayer)(nil).GetGroups), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetGroups", reflect.TypeOf((*MockTcgplayer)(nil).GetGroups), ctx, params)
 }
 
 // GetLanguages mocks base method.
-func (m *MockTcgplayer) GetLanguages(params *tcgplayer.LanguageParams) ([]*tcgplayer.Language, error) {
+func (m *MockTcgplayer) GetLanguages(ctx context.Context, params *tcgplayer.LanguageParams) ([]*tcgplayer.Language, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetLanguages", params)
+	ret := m.ctrl.Call(m, "GetLanguages", ctx, params)
 	ret0, _ := ret[0].([]*tcgplayer.Language)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetLanguages indicates an expected call of GetLanguages.
-func (mr *MockTcgplayerMockRecorder) GetLanguages(params interface{}) *gomock.Call {
+func (mr *MockTcgplayerMockRecorder) GetLanguages(ctx, params interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetLanguages", reflect.TypeOf((*MockTcgplayer)(nil).GetLanguages), params)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetLanguages", reflect.TypeOf((*MockTcgplayer)(nil).GetLanguages), ctx, params)
 }
 
 // GetPrinting mocks base method.
-func (m *MockTcgplayer) GetPrinting(params tcgplayer.PrintingParams) ([]*tcgplayer.Printing, error) {
+func (m *MockTcgplayer) GetPrinting(ctx context.Context, params tcgplayer.PrintingParams) ([]*tcgplayer.Printing, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetPrinting", params)
+	ret := m.ctrl.Call(m, "GetPrinting", ctx, params)
 	ret0, _ := ret[0].([]*tcgplayer.Printing)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetPrinting indicates an expected call of GetPrinting.
-func (mr *MockTcgplayerMockRecorder) GetPrinting(params interface{}) *gomock.Call {
+func (mr *MockTcgplayerMockRecorder) GetPrinting(ctx, params interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPrinting", reflect.TypeOf((*MockTcgplayer)(nil).GetPrinting), params)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPrinting", reflect.TypeOf((*MockTcgplayer)(nil).GetPrinting), ctx, params)
 }
 
 // GetRarities mocks base method.
-func (m *MockTcgplayer) GetRarities(params *tcgplayer.RarityParams) ([]*tcgplayer.Rarity, error) {
+func (m *MockTcgplayer) GetRarities(ctx context.Context, params *tcgplayer.RarityParams) ([]*tcgplayer.Rarity, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetRarities", params)
+	ret := m.ctrl.Call(m, "GetRarities", ctx, params)
 	ret0, _ := ret[0].([]*tcgplayer.Rarity)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetRarities indicates an expected call of GetRarities.
-func (mr *MockTcgplayerMockRecorder) GetRarities(params interface{}) *gomock.Call {
+func (mr *MockTcgplayerMockRecorder) GetRarities(ctx, params interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRarities", reflect.TypeOf((*MockTcgplayer)(nil).GetRarities), params)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRarities", reflect.TypeOf((*MockTcgplayer)(nil).GetRarities), ctx, params)
 }
 
 // GetSKUPrices mocks base method.
-func (m *MockTcgplayer) GetSKUPrices(skus []int) ([]*tcgplayer.SKUMarketPrice, error) {
+func (m *MockTcgplayer) GetSKUPrices(ctx context.Context, skus []int) ([]*tcgplayer.SKUMarketPrice, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetSKUPrices", skus)
+	ret := m.ctrl.Call(m, "GetSKUPrices", ctx, skus)
 	ret0, _ := ret[0].([]*tcgplayer.SKUMarketPrice)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetSKUPrices indicates an expected call of GetSKUPrices.
-func (mr *MockTcgplayerMockRecorder) GetSKUPrices(skus interface{}) *gomock.Call {
+func (mr *MockTcgplayerMockRecorder) GetSKUPrices(ctx, skus interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetSKUPrices", reflect.TypeOf((*MockTcgplayer)(nil).GetSKUPrices), skus)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetSKUPrices", reflect.TypeOf((*MockTcgplayer)(nil).GetSKUPrices), ctx, skus)
 }
 
 // ListAllProducts mocks base method.
-func (m *MockTcgplayer) ListAllProducts(params tcgplayer.ProductParams) ([]*tcgplayer.Product, error) {
+func (m *MockTcgplayer) ListAllProducts(ctx context.Context, params tcgplayer.ProductParams) ([]*tcgplayer.Product, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "ListAllProducts", params)
+	ret := m.ctrl.Call(m, "ListAllProducts", ctx, params)
 	ret0, _ := ret[0].([]*tcgplayer.Product)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // ListAllProducts indicates an expected call of ListAllProducts.
-func (mr *MockTcgplayerMockRecorder) ListAllProducts(params interface{}) *gomock.Call {
+func (mr *MockTcgplayerMockRecorder) ListAllProducts(ctx, params interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListAllProducts", reflect.TypeOf((*MockTcgplayer)(nil).ListAllProducts), params)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListAllProducts", reflect.TypeOf((*MockTcgplayer)(nil).ListAllProducts), ctx, params)
 }
 
 // ListProductSKUs mocks base method.
-func (m *MockTcgplayer) ListProductSKUs(skuID int) ([]*tcgplayer.SKU, error) {
+func (m *MockTcgplayer) ListProductSKUs(ctx context.Context, skuID int) ([]*tcgplayer.SKU, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "ListProductSKUs", skuID)
+	ret := m.ctrl.Call(m, "ListProductSKUs", ctx, skuID)
 	ret0, _ := ret[0].([]*tcgplayer.SKU)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // ListProductSKUs indicates an expected call of ListProductSKUs.
-func (mr *MockTcgplayerMockRecorder) ListProductSKUs(skuID interface{}) *gomock.Call {
+func (mr *MockTcgplayerMockRecorder) ListProductSKUs(ctx, skuID interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListProductSKUs", reflect.TypeOf((*MockTcgplayer)(nil).ListProductSKUs), skuID)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListProductSKUs", reflect.TypeOf((*MockTcgplayer)(nil).ListProductSKUs), ctx, skuID)
 }
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"time"
+
+	errors "github.com/AustinMCrane/errorutil"
+	"gorm.io/gorm"
+
+	"github.com/AustinMCrane/tcg-market-watch-api/pkg/store"
+	"github.com/AustinMCrane/tcgplayer"
+)
+
+const (
+	dbDriverPostgres = "postgres"
+	dbDriverSQLite   = "sqlite"
+)
+
+// groupSync records the last time a group's products were crawled, the
+// tables of the api are owned by tcg-market-watch-api, this one belongs to
+// the ingester
+type groupSync struct {
+	TCGPlayerID int `gorm:"column:tcgplayer_id;primaryKey;autoIncrement:false"`
+	PublishedOn string
+	SyncedAt    time.Time
+}
+
+func (groupSync) TableName() string {
+	return "ingest_group_syncs"
+}
+
+// skuPrice is a row of sku_prices, store.SKUPrice with the exact cents and
+// currency columns the ingester adds, the float price and shipping are kept
+// for the readers of tcg-market-watch-api. It is the table on sqlite too,
+// store.SKUPrice defaults ingested_at to now() which sqlite doesn't have
+type skuPrice struct {
+	ID            int
+	SKUID         int `gorm:"column:sku_id"`
+	Price         float32
+	Shipping      float32
+	PriceCents    cents
+	ShippingCents cents
+	Currency      string    `gorm:"default:USD"`
+	IngestedAt    time.Time `gorm:"default:CURRENT_TIMESTAMP"`
+}
+
+func (skuPrice) TableName() string {
+	return "sku_prices"
+}
+
+// newSKUPrice returns the row of a tcgplayer price ingested at the given
+// time, the cents are rounded from the dollars the api reports before they
+// are narrowed to the float columns
+func newSKUPrice(p *tcgplayer.SKUMarketPrice, ingestedAt time.Time) skuPrice {
+	return skuPrice{
+		SKUID:         p.SKUID,
+		Price:         float32(p.LowPrice),
+		Shipping:      float32(p.LowestShipping),
+		PriceCents:    toCents(p.LowPrice),
+		ShippingCents: toCents(p.LowestShipping),
+		Currency:      priceCurrency,
+		IngestedAt:    ingestedAt,
+	}
+}
+
+// centsColumns selects the exact price_cents and shipping_cents of sku_prices
+// as price and shipping with their currency, rows ingested before the
+// columns were added only have the float columns and are rounded to the
+// nearest cent
+const centsColumns = "COALESCE(sku_prices.price_cents, CAST(ROUND(sku_prices.price * 100) AS BIGINT)) AS price, " +
+	"COALESCE(sku_prices.shipping_cents, CAST(ROUND(sku_prices.shipping * 100) AS BIGINT)) AS shipping, " +
+	"sku_prices.currency"
+
+// addCentsColumns adds the cents and currency columns to the sku_prices of
+// tcg-market-watch-api, the columns of a partitioned table are added to
+// every partition
+const addCentsColumns = "ALTER TABLE IF EXISTS sku_prices " +
+	"ADD COLUMN IF NOT EXISTS price_cents bigint, " +
+	"ADD COLUMN IF NOT EXISTS shipping_cents bigint, " +
+	"ADD COLUMN IF NOT EXISTS currency text NOT NULL DEFAULT 'USD'"
+
+// sqliteTimeLayouts are the layouts times are stored with on sqlite, by the
+// driver and by CURRENT_TIMESTAMP
+var sqliteTimeLayouts = []string{"2006-01-02 15:04:05.999999999-07:00", time.RFC3339Nano, "2006-01-02 15:04:05"}
+
+// scannedTime is a time that can be scanned from text, sqlite returns the
+// result of an aggregate like max(ingested_at) as the text it was stored as
+type scannedTime struct {
+	time.Time
+}
+
+func (t *scannedTime) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		t.Time = time.Time{}
+		return nil
+	case time.Time:
+		t.Time = v
+		return nil
+	case []byte:
+		return t.Scan(string(v))
+	case string:
+		for _, layout := range sqliteTimeLayouts {
+			parsed, err := time.Parse(layout, v)
+			if err == nil {
+				t.Time = parsed
+				return nil
+			}
+		}
+		return errors.New("unable to parse time: " + v)
+	default:
+		return errors.New(fmt.Sprintf("unable to scan %T into a time", value))
+	}
+}
+
+func (t scannedTime) Value() (driver.Value, error) {
+	return t.Time, nil
+}
+
+// migrate creates or updates the tables owned by the ingester and adds its
+// columns to sku_prices, on sqlite there is no tcg-market-watch-api to own
+// the catalog so its tables are created too
+func migrate(ctx context.Context, dbConn *gorm.DB) error {
+	tables := []interface{}{&groupSync{}, &watchEntry{}, &ingestRun{}, &productPrice{}, &groupValue{},
+		&exchangeRate{}}
+	if dbConn.Dialector.Name() == dbDriverSQLite {
+		tables = append(tables, &store.Category{}, &store.Group{}, &store.Rarity{},
+			&store.Printing{}, &store.Condition{}, &store.Language{}, &store.Detail{},
+			&store.Product{}, &store.SKU{}, &skuPrice{})
+	}
+
+	// the groups already in the catalog when the sync table is created count
+	// as synced with an unknown publishedOn, discovery adopts the current
+	// one instead of crawling every group, the catalog sync crawls them once
+	baseline := !dbConn.Migrator().HasTable(&groupSync{})
+
+	err := dbConn.WithContext(ctx).AutoMigrate(tables...)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	if baseline && dbConn.Migrator().HasTable(&store.Group{}) {
+		err := dbConn.WithContext(ctx).Exec("INSERT INTO ingest_group_syncs (tcgplayer_id, published_on, synced_at) "+
+			"SELECT tcgplayer_id, '', ? FROM groups", time.Now()).Error
+		if err != nil {
+			return errors.Wrap(err)
+		}
+	}
+
+	if dbConn.Dialector.Name() == dbDriverPostgres {
+		err := dbConn.WithContext(ctx).Exec(addCentsColumns).Error
+		if err != nil {
+			return errors.Wrap(err)
+		}
+	}
+
+	return nil
+}
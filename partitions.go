@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	errors "github.com/AustinMCrane/errorutil"
+)
+
+const (
+	partitionDay   = "day"
+	partitionMonth = "month"
+
+	pricesTable           = "sku_prices"
+	pricePartitionPrefix  = pricesTable + "_p"
+	partitionDayLayout    = "20060102"
+	partitionMonthLayout  = "200601"
+	partitionBoundsLayout = "2006-01-02"
+)
+
+// pricePartitioner is implemented by stores that can split sku_prices into
+// time based partitions
+type pricePartitioner interface {
+	// CreatePricePartitions creates the partition holding from and the
+	// following ahead ones, it returns how many were created
+	CreatePricePartitions(ctx context.Context, interval string, from time.Time, ahead int) (int, error)
+}
+
+// partitionRange returns the [start, end) range of the partition holding t
+func partitionRange(interval string, t time.Time) (time.Time, time.Time, error) {
+	t = t.UTC()
+	switch interval {
+	case partitionDay:
+		start := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(0, 0, 1), nil
+	case partitionMonth:
+		start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(0, 1, 0), nil
+	default:
+		return time.Time{}, time.Time{}, errors.New("unknown partition interval: " + interval)
+	}
+}
+
+// partitionName is the name of the partition starting at start,
+// sku_prices_p20230301 for a day or sku_prices_p202303 for a month
+func partitionName(interval string, start time.Time) string {
+	if interval == partitionMonth {
+		return pricePartitionPrefix + start.Format(partitionMonthLayout)
+	}
+
+	return pricePartitionPrefix + start.Format(partitionDayLayout)
+}
+
+// parsePartitionName returns the range of a partition named by
+// partitionName, partitions named any other way aren't managed by the
+// ingester and are reported as not ok
+func parsePartitionName(name string) (time.Time, time.Time, bool) {
+	suffix, found := strings.CutPrefix(name, pricePartitionPrefix)
+	if !found {
+		return time.Time{}, time.Time{}, false
+	}
+
+	if len(suffix) == len(partitionDayLayout) {
+		start, err := time.Parse(partitionDayLayout, suffix)
+		if err == nil {
+			return start, start.AddDate(0, 0, 1), true
+		}
+	}
+
+	if len(suffix) == len(partitionMonthLayout) {
+		start, err := time.Parse(partitionMonthLayout, suffix)
+		if err == nil {
+			return start, start.AddDate(0, 1, 0), true
+		}
+	}
+
+	return time.Time{}, time.Time{}, false
+}
+
+// pricesPartitioned reports whether sku_prices is a partitioned table
+func pricesPartitioned(ctx context.Context, dbConn *gorm.DB) (bool, error) {
+	if dbConn.Dialector.Name() != dbDriverPostgres {
+		return false, nil
+	}
+
+	partitioned := false
+	err := dbConn.WithContext(ctx).Raw("SELECT EXISTS (SELECT 1 FROM pg_partitioned_table pt "+
+		"JOIN pg_class c ON c.oid = pt.partrelid WHERE c.relname = ?)", pricesTable).
+		Scan(&partitioned).Error
+	if err != nil {
+		return false, errors.Wrap(err)
+	}
+
+	return partitioned, nil
+}
+
+// listPricePartitions returns the names of the partitions of sku_prices
+func listPricePartitions(ctx context.Context, dbConn *gorm.DB) ([]string, error) {
+	names := []string{}
+	err := dbConn.WithContext(ctx).Raw("SELECT c.relname FROM pg_inherits i "+
+		"JOIN pg_class c ON c.oid = i.inhrelid JOIN pg_class p ON p.oid = i.inhparent "+
+		"WHERE p.relname = ? ORDER BY c.relname", pricesTable).
+		Scan(&names).Error
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	return names, nil
+}
+
+func (s *sqlStore) CreatePricePartitions(ctx context.Context, interval string, from time.Time,
+	ahead int) (int, error) {
+	partitioned, err := pricesPartitioned(ctx, s.db)
+	if err != nil {
+		return 0, errors.Wrap(err)
+	}
+	if !partitioned {
+		slog.Debug("sku_prices isn't partitioned, not creating partitions")
+		return 0, nil
+	}
+
+	existing, err := listPricePartitions(ctx, s.db)
+	if err != nil {
+		return 0, errors.Wrap(err)
+	}
+
+	created := 0
+	t := from
+	for i := 0; i <= ahead; i++ {
+		start, end, err := partitionRange(interval, t)
+		if err != nil {
+			return 0, errors.Wrap(err)
+		}
+		t = end
+
+		name := partitionName(interval, start)
+		if containsName(existing, name) {
+			continue
+		}
+
+		err = s.db.WithContext(ctx).Exec(fmt.Sprintf("CREATE TABLE %s PARTITION OF %s FOR VALUES FROM ('%s') TO ('%s')",
+			name, pricesTable, start.Format(partitionBoundsLayout), end.Format(partitionBoundsLayout))).Error
+		if err != nil {
+			return 0, errors.Wrap(err)
+		}
+
+		slog.Info("created price partition", "partition", name)
+		created++
+	}
+
+	return created, nil
+}
+
+// dropExpiredPartitions drops the partitions whose whole range is before
+// the given time and returns how many prices they held, the partition
+// holding before is kept so a little more than the retention is kept, as is
+// every partition holding a price matching kept when it isn't nil
+func dropExpiredPartitions(ctx context.Context, dbConn *gorm.DB, before time.Time,
+	kept *gorm.DB) (int64, error) {
+	names, err := listPricePartitions(ctx, dbConn)
+	if err != nil {
+		return 0, errors.Wrap(err)
+	}
+
+	var dropped int64
+	for _, name := range names {
+		_, end, ok := parsePartitionName(name)
+		if !ok || end.After(before) {
+			continue
+		}
+
+		if kept != nil {
+			// EXISTS stops at the first row kept instead of counting the
+			// whole partition
+			keeping := false
+			err := dbConn.WithContext(ctx).Raw("SELECT EXISTS (?)",
+				dbConn.Table(name).Select("1").Where(kept)).Scan(&keeping).Error
+			if err != nil {
+				return 0, errors.Wrap(err)
+			}
+
+			// its other prices are deleted by the rules like the
+			// prices of an unpartitioned table
+			if keeping {
+				slog.Debug("keeping price partition with prices kept forever", "partition", name)
+				continue
+			}
+		}
+
+		var count int64
+		err := dbConn.WithContext(ctx).Table(name).Count(&count).Error
+		if err != nil {
+			return 0, errors.Wrap(err)
+		}
+
+		// detaching only locks sku_prices for as long as it takes to
+		// unlink the partition, the drop then only locks the partition
+		err = dbConn.WithContext(ctx).Exec(fmt.Sprintf("ALTER TABLE %s DETACH PARTITION %s", pricesTable, name)).Error
+		if err != nil {
+			return 0, errors.Wrap(err)
+		}
+
+		err = dbConn.WithContext(ctx).Exec(fmt.Sprintf("DROP TABLE %s", name)).Error
+		if err != nil {
+			return 0, errors.Wrap(err)
+		}
+
+		slog.Info("dropped price partition", "partition", name, "prices", count)
+		dropped += count
+	}
+
+	return dropped, nil
+}
+
+// containsName reports whether name is in names
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+
+	return false
+}
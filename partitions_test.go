@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPartitionNames(t *testing.T) {
+	at := time.Date(2023, 3, 14, 15, 0, 0, 0, time.UTC)
+
+	start, end, err := partitionRange(partitionDay, at)
+	require.NoError(t, err)
+	require.Equal(t, "sku_prices_p20230314", partitionName(partitionDay, start))
+	require.Equal(t, time.Date(2023, 3, 15, 0, 0, 0, 0, time.UTC), end)
+
+	start, end, err = partitionRange(partitionMonth, at)
+	require.NoError(t, err)
+	require.Equal(t, "sku_prices_p202303", partitionName(partitionMonth, start))
+	require.Equal(t, time.Date(2023, 4, 1, 0, 0, 0, 0, time.UTC), end)
+
+	_, _, err = partitionRange("week", at)
+	require.Error(t, err)
+
+	parsedStart, parsedEnd, ok := parsePartitionName("sku_prices_p202303")
+	require.True(t, ok)
+	require.Equal(t, start, parsedStart)
+	require.Equal(t, end, parsedEnd)
+
+	_, _, ok = parsePartitionName("sku_prices_default")
+	require.False(t, ok)
+}
+
+func TestTrimPrices_DropsExpiredPartitions(t *testing.T) {
+	dbConn, mock := GetMockDB(t)
+
+	mock.ExpectQuery(`SELECT EXISTS \(SELECT 1 FROM pg_partitioned_table`).
+		WithArgs(pricesTable).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery(`SELECT c.relname FROM pg_inherits`).
+		WithArgs(pricesTable).
+		WillReturnRows(sqlmock.NewRows([]string{"relname"}).
+			AddRow("sku_prices_default").
+			AddRow("sku_prices_p20230301").
+			AddRow("sku_prices_p20230302").
+			AddRow("sku_prices_p20230303"))
+
+	// only the partitions ending before the cutoff are dropped
+	mock.ExpectQuery(`SELECT count\(\*\) FROM \"sku_prices_p20230301\"`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(10))
+	mock.ExpectExec(`ALTER TABLE sku_prices DETACH PARTITION sku_prices_p20230301`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`DROP TABLE sku_prices_p20230301`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT count\(\*\) FROM \"sku_prices_p20230302\"`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(5))
+	mock.ExpectExec(`ALTER TABLE sku_prices DETACH PARTITION sku_prices_p20230302`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`DROP TABLE sku_prices_p20230302`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	trimmed, err := newSQLStore(dbConn).TrimPrices(context.Background(),
+		&retentionPolicy{DefaultDays: 1}, time.Date(2023, 3, 4, 12, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	require.Equal(t, []retentionResult{{Rule: defaultRetentionRule, Trimmed: 15}}, trimmed)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDropExpiredPartitions_KeptForever(t *testing.T) {
+	dbConn, mock := GetMockDB(t)
+
+	mock.ExpectQuery(`SELECT c.relname FROM pg_inherits`).
+		WillReturnRows(sqlmock.NewRows([]string{"relname"}).
+			AddRow("sku_prices_p20230301").
+			AddRow("sku_prices_p20230302"))
+
+	// the first partition holds prices of the group kept forever, the
+	// second is dropped once its prices have expired under every other rule
+	mock.ExpectQuery(`SELECT EXISTS \(SELECT 1 FROM "sku_prices_p20230301" WHERE \(sku_id IN \(SELECT skus.tcgplayer_id `+
+		`FROM "skus" JOIN products .+ JOIN groups .+ WHERE groups.tcgplayer_id IN \(\$1\)\) AND `+
+		`sku_id NOT IN \(SELECT .+ WHERE products.category_id IN \(\$2\)\)\)\)`).
+		WithArgs(23395, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery(`SELECT EXISTS \(SELECT 1 FROM "sku_prices_p20230302" WHERE`).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "sku_prices_p20230302"$`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(5))
+	mock.ExpectExec(`ALTER TABLE sku_prices DETACH PARTITION sku_prices_p20230302`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`DROP TABLE sku_prices_p20230302`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	dropped, err := dropExpiredPartitions(context.Background(), dbConn,
+		time.Date(2023, 3, 3, 12, 0, 0, 0, time.UTC), (&retentionPolicy{DefaultDays: 1, Rules: []retentionRule{
+			{Name: "other games", Days: 1, Categories: []int{3}},
+			{Name: "sets", Groups: []int{23395}},
+		}}).keptForever(dbConn))
+	require.NoError(t, err)
+	require.Equal(t, int64(5), dropped)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTrimPrices_Unpartitioned(t *testing.T) {
+	dbConn, mock := GetMockDB(t)
+	before := time.Date(2023, 3, 3, 0, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery(`SELECT EXISTS \(SELECT 1 FROM pg_partitioned_table`).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM \"sku_prices\" WHERE ingested_at < \$1`).
+		WithArgs(before).
+		WillReturnResult(sqlmock.NewResult(0, 7))
+	mock.ExpectCommit()
+
+	trimmed, err := newSQLStore(dbConn).TrimPrices(context.Background(),
+		&retentionPolicy{DefaultDays: 1}, before.AddDate(0, 0, 1))
+	require.NoError(t, err)
+	require.Equal(t, []retentionResult{{Rule: defaultRetentionRule, Trimmed: 7}}, trimmed)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreatePricePartitions(t *testing.T) {
+	dbConn, mock := GetMockDB(t)
+
+	mock.ExpectQuery(`SELECT EXISTS \(SELECT 1 FROM pg_partitioned_table`).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery(`SELECT c.relname FROM pg_inherits`).
+		WillReturnRows(sqlmock.NewRows([]string{"relname"}).AddRow("sku_prices_p202303"))
+	mock.ExpectExec(`CREATE TABLE sku_prices_p202304 PARTITION OF sku_prices ` +
+		`FOR VALUES FROM \('2023-04-01'\) TO \('2023-05-01'\)`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	created, err := newSQLStore(dbConn).CreatePricePartitions(context.Background(), partitionMonth,
+		time.Date(2023, 3, 14, 0, 0, 0, 0, time.UTC), 1)
+	require.NoError(t, err)
+	require.Equal(t, 1, created)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"sort"
+	"time"
+
+	errors "github.com/AustinMCrane/errorutil"
+)
+
+const (
+	// priceBatchSize is how many skus a price request fetches
+	priceBatchSize = 100
+
+	// volatilityWindow is how far back prices are looked at to rank skus
+	volatilityWindow = time.Hour * 24 * 7
+
+	// volatilityWeight is how much more often a sku whose price deviates by
+	// its whole mean is refreshed than a sku whose price never moves
+	volatilityWeight = 10
+)
+
+// priceStats summarizes the recent prices of a sku
+type priceStats struct {
+	SKUID          int
+	Samples        int
+	Mean           float64
+	MeanSquare     float64
+	LastIngestedAt time.Time
+}
+
+// volatility is the coefficient of variation of the prices, how much they
+// deviate relative to their mean
+func (p priceStats) volatility() float64 {
+	if p.Mean <= 0 {
+		return 0
+	}
+
+	variance := p.MeanSquare - p.Mean*p.Mean
+	if variance <= 0 {
+		return 0
+	}
+
+	return math.Sqrt(variance) / p.Mean
+}
+
+// priority ranks a sku for the next price run, the longer since its last
+// price and the more its price moves the higher it ranks
+func (p priceStats) priority(now time.Time) float64 {
+	return now.Sub(p.LastIngestedAt).Hours() * (1 + volatilityWeight*p.volatility())
+}
+
+// selectSKUs returns the limit skus that most need a refresh, highest
+// priority first, skus without recent prices come before every other one
+func selectSKUs(skus []int, stats map[int]priceStats, now time.Time, limit int) []int {
+	priorities := make(map[int]float64, len(skus))
+	for _, id := range skus {
+		s, ok := stats[id]
+		if !ok {
+			priorities[id] = math.Inf(1)
+			continue
+		}
+		priorities[id] = s.priority(now)
+	}
+
+	selected := append([]int{}, skus...)
+	sort.SliceStable(selected, func(i, j int) bool {
+		pi, pj := priorities[selected[i]], priorities[selected[j]]
+		if pi == pj {
+			return selected[i] < selected[j]
+		}
+		return pi > pj
+	})
+
+	if len(selected) > limit {
+		selected = selected[:limit]
+	}
+
+	return selected
+}
+
+// ingestPricesWithinBudget fetches prices for the skus that most need a
+// refresh, at most budget requests are made
+func ingestPricesWithinBudget(ctx context.Context, s Store, client Tcgplayer, events *eventPublisher,
+	budget int, sleepDuration time.Duration, flush flushPolicy) error {
+	skus, err := s.ListSKUIDs(ctx)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	now := time.Now()
+	stats, err := s.PriceStats(ctx, now.Add(-volatilityWindow))
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	selected := selectSKUs(skus, stats, now, budget*priceBatchSize)
+	slog.Info("selected skus within price budget", "skus", len(selected), "of", len(skus),
+		"budget", budget)
+
+	return ingestSKUPrices(ctx, s, client, events, selected, sleepDuration, flush)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPriceStats_Volatility(t *testing.T) {
+	// prices of 1 and 3 deviate by 1 from their mean of 2
+	require.InDelta(t, 0.5, priceStats{Mean: 2, MeanSquare: 5}.volatility(), 0.0001)
+	require.Zero(t, priceStats{Mean: 2, MeanSquare: 4}.volatility())
+	require.Zero(t, priceStats{}.volatility())
+}
+
+func TestSelectSKUs(t *testing.T) {
+	now := time.Date(2023, 3, 14, 12, 0, 0, 0, time.UTC)
+	stats := map[int]priceStats{
+		// stable and refreshed an hour ago
+		1: {SKUID: 1, Mean: 2, MeanSquare: 4, LastIngestedAt: now.Add(-time.Hour)},
+		// stable but a day old
+		2: {SKUID: 2, Mean: 2, MeanSquare: 4, LastIngestedAt: now.Add(-time.Hour * 24)},
+		// volatile and refreshed six hours ago
+		3: {SKUID: 3, Mean: 2, MeanSquare: 5, LastIngestedAt: now.Add(-time.Hour * 6)},
+	}
+
+	// sku 4 has no recent price so it comes first, the volatile sku 3
+	// outranks the older but stable sku 2
+	require.Equal(t, []int{4, 3, 2, 1}, selectSKUs([]int{1, 2, 3, 4}, stats, now, 10))
+	require.Equal(t, []int{4, 3}, selectSKUs([]int{1, 2, 3, 4}, stats, now, 2))
+	require.Empty(t, selectSKUs([]int{1, 2, 3, 4}, stats, now, 0))
+}
+
+func TestPriceStats(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+
+	dbConn, err := getDBConnection(dbDriverSQLite, "", "", "", "", filepath.Join(t.TempDir(), "dev.db"))
+	require.NoError(t, err)
+	require.NoError(t, migrate(ctx, dbConn))
+
+	for name, s := range map[string]Store{"memory": newMemoryStore(), "sqlite": newSQLStore(dbConn)} {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, s.InsertPrices(ctx, []skuPrice{
+				{SKUID: 10, Price: 1, IngestedAt: now.Add(-time.Hour * 2)},
+				{SKUID: 10, Price: 3, IngestedAt: now.Add(-time.Hour)},
+				{SKUID: 20, Price: 5, IngestedAt: now.Add(-time.Hour * 24 * 30)},
+			}))
+
+			stats, err := s.PriceStats(ctx, now.Add(-volatilityWindow))
+			require.NoError(t, err)
+			require.Len(t, stats, 1)
+			require.Equal(t, 2, stats[10].Samples)
+			require.InDelta(t, 2, stats[10].Mean, 0.0001)
+			require.InDelta(t, 5, stats[10].MeanSquare, 0.0001)
+			require.True(t, now.Add(-time.Hour).Equal(stats[10].LastIngestedAt))
+		})
+	}
+}
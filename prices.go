@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"gorm.io/gorm"
+
+	errors "github.com/AustinMCrane/errorutil"
+)
+
+// flushPolicy is when buffered prices are written, whichever comes first
+type flushPolicy struct {
+	// size is how many prices are buffered before they are written
+	size int
+	// interval is how long prices are buffered before they are written
+	interval time.Duration
+}
+
+// priceBuffer collects the prices of several api batches so they are
+// written in fewer, larger loads
+type priceBuffer struct {
+	s      Store
+	events *eventPublisher
+	policy flushPolicy
+
+	prices    []skuPrice
+	batches   []priceBatchIngestedEvent
+	lastFlush time.Time
+}
+
+func newPriceBuffer(s Store, events *eventPublisher, policy flushPolicy) *priceBuffer {
+	return &priceBuffer{
+		s:         s,
+		events:    events,
+		policy:    policy,
+		lastFlush: time.Now(),
+	}
+}
+
+// Add buffers the prices of an api batch and writes the buffer once it is
+// full or old enough
+func (b *priceBuffer) Add(ctx context.Context, batch priceBatchIngestedEvent, prices []skuPrice) error {
+	b.prices = append(b.prices, prices...)
+	b.batches = append(b.batches, batch)
+
+	if len(b.prices) < b.policy.size && time.Since(b.lastFlush) < b.policy.interval {
+		return nil
+	}
+
+	return b.Flush(ctx)
+}
+
+// Flush writes the buffered prices, the batches they came from are only
+// announced once they are stored
+func (b *priceBuffer) Flush(ctx context.Context) error {
+	b.lastFlush = time.Now()
+	if len(b.batches) == 0 {
+		return nil
+	}
+
+	err := b.s.InsertPrices(ctx, b.prices)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	slog.Debug("flushed prices", "prices", len(b.prices), "batches", len(b.batches))
+	for _, batch := range b.batches {
+		b.events.publish(ctx, eventPriceBatchIngest, batch)
+	}
+
+	b.prices = nil
+	b.batches = nil
+	return nil
+}
+
+// copyPrices loads prices with COPY FROM STDIN, it reports false without
+// writing anything when dbConn isn't backed by a pgx connection pool, e.g.
+// inside a transaction or in tests, so the caller can insert them instead
+func copyPrices(ctx context.Context, dbConn *gorm.DB, prices []skuPrice) (bool, error) {
+	sqlDB, ok := dbConn.Statement.ConnPool.(*sql.DB)
+	if !ok {
+		return false, nil
+	}
+
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return false, errors.Wrap(err)
+	}
+	defer conn.Close()
+
+	copied := false
+	err = conn.Raw(func(driverConn interface{}) error {
+		pgxConn, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			return nil
+		}
+		copied = true
+
+		return copyPriceRows(ctx, pgxConn.Conn(), prices)
+	})
+	if err != nil {
+		return copied, errors.Wrap(err)
+	}
+
+	return copied, nil
+}
+
+// priceCopier is the part of a pgx connection copyPriceRows uses
+type priceCopier interface {
+	CopyFrom(ctx context.Context, tableName pgx.Identifier, columnNames []string,
+		rowSrc pgx.CopyFromSource) (int64, error)
+}
+
+// copyPriceRows copies the prices into sku_prices over conn
+func copyPriceRows(ctx context.Context, conn priceCopier, prices []skuPrice) error {
+	// ingested_at and currency are copied columns so their defaults don't
+	// apply, prices without them get the current time like now() would and
+	// are in dollars
+	now := time.Now()
+	rows := make([][]interface{}, 0, len(prices))
+	for _, row := range prices {
+		if row.IngestedAt.IsZero() {
+			row.IngestedAt = now
+		}
+		if row.Currency == "" {
+			row.Currency = priceCurrency
+		}
+		rows = append(rows, []interface{}{row.SKUID, row.Price, row.Shipping, int64(row.PriceCents),
+			int64(row.ShippingCents), row.Currency, row.IngestedAt})
+	}
+
+	_, err := conn.CopyFrom(ctx, pgx.Identifier{"sku_prices"},
+		[]string{"sku_id", "price", "shipping", "price_cents", "shipping_cents", "currency", "ingested_at"},
+		pgx.CopyFromRows(rows))
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/AustinMCrane/tcgplayer"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/require"
+)
+
+// testFlushPolicy writes prices when ingetPrices returns
+var testFlushPolicy = flushPolicy{size: 1000, interval: time.Hour}
+
+func TestPriceBuffer_FlushBySize(t *testing.T) {
+	ctx := context.Background()
+	s := newMemoryStore()
+	b := newPriceBuffer(s, nil, flushPolicy{size: 3, interval: time.Hour})
+
+	err := b.Add(ctx, priceBatchIngestedEvent{Batch: 0}, []skuPrice{{SKUID: 1}, {SKUID: 2}})
+	require.NoError(t, err)
+	require.Empty(t, s.prices)
+
+	err = b.Add(ctx, priceBatchIngestedEvent{Batch: 1}, []skuPrice{{SKUID: 3}})
+	require.NoError(t, err)
+	require.Len(t, s.prices, 3)
+	require.Empty(t, b.prices)
+	require.Empty(t, b.batches)
+}
+
+func TestPriceBuffer_FlushByInterval(t *testing.T) {
+	ctx := context.Background()
+	s := newMemoryStore()
+	b := newPriceBuffer(s, nil, flushPolicy{size: 1000, interval: time.Millisecond})
+
+	time.Sleep(time.Millisecond * 2)
+	err := b.Add(ctx, priceBatchIngestedEvent{Batch: 0}, []skuPrice{{SKUID: 1}})
+	require.NoError(t, err)
+	require.Len(t, s.prices, 1)
+}
+
+func TestCopyPrices_FallsBackWithoutPgx(t *testing.T) {
+	dbConn, mock := GetMockDB(t)
+
+	copied, err := copyPrices(context.Background(), dbConn, []skuPrice{{SKUID: 1}})
+	require.NoError(t, err)
+	require.False(t, copied)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+// fakeCopier keeps the rows copied into it
+type fakeCopier struct {
+	table   pgx.Identifier
+	columns []string
+	rows    [][]interface{}
+}
+
+func (c *fakeCopier) CopyFrom(ctx context.Context, tableName pgx.Identifier, columnNames []string,
+	rowSrc pgx.CopyFromSource) (int64, error) {
+	c.table, c.columns = tableName, columnNames
+	for rowSrc.Next() {
+		values, err := rowSrc.Values()
+		if err != nil {
+			return 0, err
+		}
+		c.rows = append(c.rows, values)
+	}
+
+	return int64(len(c.rows)), rowSrc.Err()
+}
+
+func TestCopyPriceRows(t *testing.T) {
+	ingestedAt := time.Now().Add(-time.Hour)
+	conn := &fakeCopier{}
+
+	err := copyPriceRows(context.Background(), conn, []skuPrice{
+		newSKUPrice(&tcgplayer.SKUMarketPrice{SKUID: 1, LowPrice: 1.5, LowestShipping: 0.99}, ingestedAt),
+		{SKUID: 2, Price: 3, PriceCents: 300},
+	})
+	require.NoError(t, err)
+	require.Equal(t, pgx.Identifier{"sku_prices"}, conn.table)
+	require.Equal(t, []string{"sku_id", "price", "shipping", "price_cents", "shipping_cents", "currency",
+		"ingested_at"}, conn.columns)
+	require.Len(t, conn.rows, 2)
+	require.Equal(t, []interface{}{1, float32(1.5), float32(0.99), int64(150), int64(99), priceCurrency, ingestedAt},
+		conn.rows[0])
+
+	// prices without a time get the time of the copy and are in dollars
+	require.False(t, conn.rows[1][6].(time.Time).IsZero())
+	require.Equal(t, priceCurrency, conn.rows[1][5])
+}
+
+func TestNewSKUPrice(t *testing.T) {
+	// the cents are exact even when the float column can't hold the price
+	p := newSKUPrice(&tcgplayer.SKUMarketPrice{SKUID: 1, LowPrice: 1234567.89, LowestShipping: 0.29}, time.Time{})
+	require.Equal(t, cents(123456789), p.PriceCents)
+	require.Equal(t, cents(29), p.ShippingCents)
+	require.NotEqual(t, toCents(float64(p.Price)), p.PriceCents)
+	require.Equal(t, priceCurrency, p.Currency)
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"gorm.io/gorm"
+
+	errors "github.com/AustinMCrane/errorutil"
+	"github.com/AustinMCrane/tcg-market-watch-api/pkg/store"
+	"github.com/AustinMCrane/tcgplayer"
+)
+
+const commandRepairSKUs = "repair-skus"
+
+// skuRepair is what a repair-skus run found and fixed
+type skuRepair struct {
+	// Products are the products found with missing or broken skus
+	Products int
+	// Repaired are the products that have skus again after their skus were
+	// refetched, a product tcgplayer lists no english skus for isn't
+	Repaired int64
+	// SKUs are the skus written for the repaired products
+	SKUs int64
+	// Orphans are the skus without a product that were deleted, they can't
+	// be refetched without a product so they aren't repaired
+	Orphans int64
+	// Failed are the tcgplayer ids of the products whose skus couldn't be
+	// fetched, they are left as they were
+	Failed []int
+}
+
+// ExecRepairSKUs is the entry point of the repair-skus command, it refetches
+// the skus of products that have none or that reference missing rows
+func ExecRepairSKUs(ctx context.Context, args []string) error {
+	fs := newCommandFlagSet(commandRepairSKUs)
+	err := fs.Parse(args)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	dbConn, err := openDB(ctx, commandRepairSKUs)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+	defer closeDB(dbConn)
+
+	client, err := newTcgplayerClient(*publicKey, *privateKey)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	repair, err := repairSKUs(ctx, dbConn, client, tcgplayer.CategoryYugioh, time.Millisecond*100)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	fmt.Fprintf(os.Stdout, "products with broken skus: %d\n", repair.Products)
+	fmt.Fprintf(os.Stdout, "products repaired:         %d\n", repair.Repaired)
+	fmt.Fprintf(os.Stdout, "skus written:              %d\n", repair.SKUs)
+	fmt.Fprintf(os.Stdout, "orphaned skus deleted:     %d\n", repair.Orphans)
+	if len(repair.Failed) > 0 {
+		fmt.Fprintf(os.Stdout, "products not repaired:     %v\n", repair.Failed)
+	}
+
+	return nil
+}
+
+// brokenSKUProducts returns the products without skus and the products with
+// a sku referencing a printing, condition or language that wasn't resolved
+func brokenSKUProducts(ctx context.Context, dbConn *gorm.DB) ([]*store.Product, error) {
+	withSKUs := dbConn.Model(&store.SKU{}).Select("product_id")
+	brokenSKUs := dbConn.Model(&store.SKU{}).Select("product_id").
+		Where("printing_id = 0 OR condition_id = 0 OR language_id = 0")
+
+	products := []*store.Product{}
+	err := dbConn.WithContext(ctx).Where("id NOT IN (?) OR id IN (?)", withSKUs, brokenSKUs).
+		Order("id").Find(&products).Error
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	return products, nil
+}
+
+// repairSKUs refetches the skus of every broken product one product at a
+// time and replaces the stored ones, skus left without a product are
+// removed, a product that can't be fetched is skipped and reported
+func repairSKUs(ctx context.Context, dbConn *gorm.DB, client Tcgplayer, categoryID int,
+	sleep time.Duration) (*skuRepair, error) {
+	products, err := brokenSKUProducts(ctx, dbConn)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	repair := &skuRepair{Products: len(products)}
+	slog.Info("repairing skus", "products", len(products))
+
+	// skus pointing at 0 usually mean the lookup tables were missing a
+	// value when the catalog was synced, so they are refreshed first
+	printings, err := getPrintings(ctx, client, categoryID)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	conditions, err := getConditions(ctx, client, categoryID)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	languages, err := getLanguages(ctx, client, categoryID)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	fetched := []*store.Product{}
+	productsTCG := []*tcgplayer.Product{}
+	skuIDs := []int{}
+	for i, p := range products {
+		skus, err := client.ListProductSKUs(ctx, p.TCGPlayerID)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, errors.Wrap(ctx.Err())
+			}
+
+			slog.Warn("unable to list product skus", "product", p.TCGPlayerID, "error", err)
+			repair.Failed = append(repair.Failed, p.TCGPlayerID)
+			continue
+		}
+
+		productTCG := &tcgplayer.Product{ID: p.TCGPlayerID, SKUS: []tcgplayer.SKU{}}
+		for _, s := range skus {
+			productTCG.SKUS = append(productTCG.SKUS, *s)
+			skuIDs = append(skuIDs, s.SKUID)
+		}
+		fetched = append(fetched, p)
+		productsTCG = append(productsTCG, productTCG)
+
+		if i < len(products)-1 {
+			err = sleepContext(ctx, sleep)
+			if err != nil {
+				return nil, errors.Wrap(err)
+			}
+		}
+	}
+	err = dbConn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		tx = tx.Session(&gorm.Session{SkipDefaultTransaction: true})
+
+		storePrintings, err := syncPrintings(ctx, tx, printings)
+		if err != nil {
+			return errors.Wrap(err)
+		}
+
+		storeConditions, err := syncConditions(ctx, tx, conditions)
+		if err != nil {
+			return errors.Wrap(err)
+		}
+
+		storeLanguages, err := syncLanguages(ctx, tx, languages)
+		if err != nil {
+			return errors.Wrap(err)
+		}
+
+		if len(fetched) > 0 {
+			productIDs := []int{}
+			for _, p := range fetched {
+				productIDs = append(productIDs, p.ID)
+			}
+
+			// the refetched skus may already be stored against product 0
+			err = tx.WithContext(ctx).Where("product_id IN ? OR tcgplayer_id IN ?", productIDs, skuIDs).
+				Delete(&store.SKU{}).Error
+			if err != nil {
+				return errors.Wrap(err)
+			}
+
+			err = syncSKUs(ctx, tx, storeLanguages, storeConditions, storePrintings, fetched, productsTCG)
+			if err != nil {
+				return errors.Wrap(err)
+			}
+
+			err = tx.WithContext(ctx).Model(&store.SKU{}).Where("product_id IN ?", productIDs).
+				Count(&repair.SKUs).Error
+			if err != nil {
+				return errors.Wrap(err)
+			}
+
+			// only the products that got skus written count as repaired
+			err = tx.WithContext(ctx).Model(&store.SKU{}).Where("product_id IN ?", productIDs).
+				Distinct("product_id").Count(&repair.Repaired).Error
+			if err != nil {
+				return errors.Wrap(err)
+			}
+		}
+
+		orphans := tx.WithContext(ctx).Where("product_id = 0").Delete(&store.SKU{})
+		if orphans.Error != nil {
+			return errors.Wrap(orphans.Error)
+		}
+		repair.Orphans = orphans.RowsAffected
+
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	slog.Info("repaired skus", "products", repair.Products, "repaired", repair.Repaired,
+		"skus", repair.SKUs, "orphans", repair.Orphans, "failed", len(repair.Failed))
+	return repair, nil
+}
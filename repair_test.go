@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/AustinMCrane/tcgplayer"
+	"github.com/DATA-DOG/go-sqlmock"
+	gomock "github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepairSKUs(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	client := NewMockTcgplayer(ctrl)
+	dbConn, mock := GetMockDB(t)
+
+	mock.ExpectQuery(`SELECT (.+) FROM \"products\" WHERE id NOT IN \(SELECT \"product_id\" FROM \"skus\"\) ` +
+		`OR id IN \(SELECT \"product_id\" FROM \"skus\" WHERE printing_id = 0 OR condition_id = 0 OR language_id = 0\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "tcgplayer_id"}).AddRow(7, 2).AddRow(8, 3).AddRow(9, 4))
+
+	client.EXPECT().GetPrinting(gomock.Any(), gomock.Any()).
+		Return([]*tcgplayer.Printing{{ID: 1, Name: "1st Edition"}}, nil)
+	client.EXPECT().GetConditions(gomock.Any(), gomock.Any()).
+		Return([]*tcgplayer.Condition{{ID: 1, Name: "Near Mint"}}, nil)
+	client.EXPECT().GetLanguages(gomock.Any(), gomock.Any()).
+		Return([]*tcgplayer.Language{{ID: 1, Name: "English"}, {ID: 2, Name: "Japanese"}}, nil)
+
+	// the english sku after a japanese one is still written
+	client.EXPECT().ListProductSKUs(gomock.Any(), 2).
+		Return([]*tcgplayer.SKU{
+			{SKUID: 21, ProductID: 2, LanguageID: 2, PrintingID: 1, ConditionID: 1},
+			{SKUID: 20, ProductID: 2, LanguageID: 1, PrintingID: 1, ConditionID: 1},
+		}, nil)
+	// a failing product is reported and left as it was
+	client.EXPECT().ListProductSKUs(gomock.Any(), 3).Return(nil, errors.New("unable to list skus"))
+	// a product without english skus isn't repaired
+	client.EXPECT().ListProductSKUs(gomock.Any(), 4).
+		Return([]*tcgplayer.SKU{{SKUID: 40, ProductID: 4, LanguageID: 2, PrintingID: 1, ConditionID: 1}}, nil)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT (.+) FROM \"printings\"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "tcgplayer_id"}).AddRow(1, 1))
+	mock.ExpectQuery(`SELECT (.+) FROM \"conditions\"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "tcgplayer_id"}).AddRow(1, 1))
+	mock.ExpectQuery(`SELECT (.+) FROM \"languages\"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "tcgplayer_id"}).AddRow(1, 1).AddRow(2, 2))
+	mock.ExpectExec(`DELETE FROM \"skus\" WHERE product_id IN \(\$1,\$2\) OR tcgplayer_id IN \(\$3,\$4,\$5\)`).
+		WithArgs(7, 9, 21, 20, 40).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`INSERT INTO \"skus\" (.+)`).
+		WithArgs(20, 7, 1, 1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectQuery(`SELECT count\(\*\) FROM \"skus\"`).
+		WithArgs(7, 9).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(`SELECT COUNT\(DISTINCT\(\"product_id\"\)\) FROM \"skus\"`).
+		WithArgs(7, 9).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectExec(`DELETE FROM \"skus\" WHERE product_id = 0`).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	repair, err := repairSKUs(ctx, dbConn, client, tcgplayer.CategoryYugioh, 0)
+	require.NoError(t, err)
+	require.Equal(t, &skuRepair{
+		Products: 3,
+		Repaired: 1,
+		SKUs:     1,
+		Orphans:  2,
+		Failed:   []int{3},
+	}, repair)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"gorm.io/gorm"
+
+	errors "github.com/AustinMCrane/errorutil"
+	"github.com/AustinMCrane/tcg-market-watch-api/pkg/store"
+	"github.com/AustinMCrane/tcgplayer"
+)
+
+const (
+	// defaultRetentionDays is how long prices are kept without a config
+	defaultRetentionDays = 60
+
+	// defaultRetentionRule is the name prices matching no rule are
+	// reported under
+	defaultRetentionRule = "default"
+
+	// retentionPartitions is the name the prices of dropped partitions are
+	// reported under, no rule keeps them anymore
+	retentionPartitions = "partitions"
+)
+
+// retentionPolicy is how long prices are kept, the first rule matching a
+// sku decides how long its prices are kept, skus matching no rule keep them
+// for DefaultDays
+type retentionPolicy struct {
+	DefaultDays int             `json:"default_days"`
+	Rules       []retentionRule `json:"rules"`
+}
+
+// retentionRule matches skus by the category, group and rarity of their
+// product and by whether they are watchlisted, every criteria that is set has
+// to match, a rule without criteria matches every sku
+type retentionRule struct {
+	Name string `json:"name"`
+	// Days is how long prices are kept, 0 keeps them forever
+	Days       int      `json:"days"`
+	Categories []int    `json:"categories"`
+	Groups     []int    `json:"groups"`
+	Rarities   []string `json:"rarities"`
+	// Watchlisted only matches the skus on the watchlist
+	Watchlisted bool `json:"watchlisted"`
+}
+
+// retentionResult is how many prices were trimmed under a rule
+type retentionResult struct {
+	Rule    string
+	Trimmed int64
+}
+
+// loadRetentionPolicy reads a json retention config, an empty path keeps
+// every price for defaultRetentionDays
+//
+//	{
+//	  "default_days": 30,
+//	  "rules": [
+//	    {"name": "chase", "days": 730, "rarities": ["Secret Rare"]},
+//	    {"name": "watchlist", "days": 0, "watchlisted": true},
+//	    {"name": "sets", "days": 0, "groups": [23395]}
+//	  ]
+//	}
+func loadRetentionPolicy(path string) (*retentionPolicy, error) {
+	policy := &retentionPolicy{DefaultDays: defaultRetentionDays}
+	if path == "" {
+		return policy, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	err = json.Unmarshal(b, policy)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	if policy.DefaultDays <= 0 {
+		return nil, errors.New("default_days must be positive")
+	}
+
+	for _, r := range policy.Rules {
+		if r.Name == "" || r.Name == defaultRetentionRule || r.Name == retentionPartitions {
+			return nil, errors.New(fmt.Sprintf("retention rules need a name other than %s or %s",
+				defaultRetentionRule, retentionPartitions))
+		}
+		if r.Days < 0 {
+			return nil, errors.New("days of retention rule " + r.Name + " can't be negative")
+		}
+	}
+
+	return policy, nil
+}
+
+// longestDays returns the longest retention of the policy, the rules
+// keeping prices forever are left out, see keptForever
+func (p *retentionPolicy) longestDays() int {
+	longest := p.DefaultDays
+	for _, r := range p.Rules {
+		if r.Days > longest {
+			longest = r.Days
+		}
+	}
+
+	return longest
+}
+
+// keptForever is the condition on sku_id of the prices a rule keeping
+// prices forever applies to, nil when no rule does
+func (p *retentionPolicy) keptForever(dbConn *gorm.DB) *gorm.DB {
+	var cond *gorm.DB
+	for i, r := range p.Rules {
+		if r.Days != 0 {
+			continue
+		}
+
+		// the rule only applies to the skus no earlier rule matched
+		q := dbConn.Where("sku_id IN (?)", r.skuIDs(dbConn))
+		for _, e := range p.Rules[:i] {
+			q = q.Where("sku_id NOT IN (?)", e.skuIDs(dbConn))
+		}
+
+		if cond == nil {
+			cond = dbConn.Where(q)
+		} else {
+			cond = cond.Or(q)
+		}
+	}
+
+	return cond
+}
+
+// cutoff is the time prices kept for days are trimmed before
+func cutoff(now time.Time, days int) time.Time {
+	return now.AddDate(0, 0, -days)
+}
+
+// matches reports whether the rule applies to a sku of the product, rarity
+// is the name of the rarity the product is stored with like skuIDs matches
+func (r retentionRule) matches(p *tcgplayer.Product, rarity string, watched bool) bool {
+	if r.Watchlisted && !watched {
+		return false
+	}
+
+	if len(r.Categories) > 0 && !containsID(r.Categories, p.CategoryID) {
+		return false
+	}
+
+	if len(r.Groups) > 0 && !containsID(r.Groups, p.GroupID) {
+		return false
+	}
+
+	if len(r.Rarities) > 0 && !containsName(r.Rarities, rarity) {
+		return false
+	}
+
+	return true
+}
+
+// skuIDs selects the tcgplayer ids of the skus the rule applies to
+func (r retentionRule) skuIDs(dbConn *gorm.DB) *gorm.DB {
+	q := dbConn.Model(&store.SKU{}).Select("skus.tcgplayer_id").
+		Joins("JOIN products ON products.id = skus.product_id")
+	if len(r.Categories) > 0 {
+		q = q.Where("products.category_id IN ?", r.Categories)
+	}
+
+	if len(r.Groups) > 0 {
+		q = q.Joins("JOIN groups ON groups.id = products.group_id").
+			Where("groups.tcgplayer_id IN ?", r.Groups)
+	}
+
+	if len(r.Rarities) > 0 {
+		q = q.Joins("JOIN rarities ON rarities.id = products.rarity_id").
+			Where("rarities.name IN ?", r.Rarities)
+	}
+
+	if r.Watchlisted {
+		q = q.Where("skus.tcgplayer_id IN (?)", watchedSKUIDs(dbConn))
+	}
+
+	return q
+}
+
+// trimPricesByPolicy deletes the expired prices of every rule, a rule only
+// trims the skus no earlier rule matched
+func trimPricesByPolicy(ctx context.Context, dbConn *gorm.DB, policy *retentionPolicy,
+	now time.Time) ([]retentionResult, error) {
+	results := []retentionResult{}
+
+	trim := func(name string, days int, rule *retentionRule, earlier []retentionRule) error {
+		q := dbConn.WithContext(ctx).Where("ingested_at < ?", cutoff(now, days))
+		if rule != nil {
+			q = q.Where("sku_id IN (?)", rule.skuIDs(dbConn))
+		}
+		for _, e := range earlier {
+			q = q.Where("sku_id NOT IN (?)", e.skuIDs(dbConn))
+		}
+
+		result := q.Delete(&store.SKUPrice{})
+		if result.Error != nil {
+			return errors.Wrap(result.Error)
+		}
+
+		results = append(results, retentionResult{Rule: name, Trimmed: result.RowsAffected})
+		return nil
+	}
+
+	for i, r := range policy.Rules {
+		if r.Days == 0 {
+			results = append(results, retentionResult{Rule: r.Name})
+			continue
+		}
+
+		r := r
+		err := trim(r.Name, r.Days, &r, policy.Rules[:i])
+		if err != nil {
+			return nil, errors.Wrap(err)
+		}
+	}
+
+	err := trim(defaultRetentionRule, policy.DefaultDays, nil, policy.Rules)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	return results, nil
+}
+
+// trimOldPriceData removes the prices the retention policy no longer keeps
+// and logs how many were removed under each rule
+func trimOldPriceData(ctx context.Context, s Store, policy *retentionPolicy) error {
+	results, err := s.TrimPrices(ctx, policy, time.Now())
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	for _, r := range results {
+		slog.Info("trimmed prices", "rule", r.Rule, "prices", r.Trimmed)
+	}
+
+	return nil
+}
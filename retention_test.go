@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AustinMCrane/tcg-market-watch-api/pkg/store"
+	"github.com/AustinMCrane/tcgplayer"
+	"github.com/stretchr/testify/require"
+)
+
+var testRetentionPolicy = &retentionPolicy{
+	DefaultDays: 30,
+	Rules: []retentionRule{
+		{Name: "chase", Days: 730, Rarities: []string{"Secret Rare"}},
+		{Name: "sets", Groups: []int{2}},
+	},
+}
+
+func TestLoadRetentionPolicy(t *testing.T) {
+	policy, err := loadRetentionPolicy("")
+	require.NoError(t, err)
+	require.Equal(t, &retentionPolicy{DefaultDays: defaultRetentionDays}, policy)
+
+	path := filepath.Join(t.TempDir(), "retention.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"default_days": 30, "rules": [
+		{"name": "chase", "days": 730, "rarities": ["Secret Rare"]},
+		{"name": "sets", "groups": [2]}
+	]}`), 0o600))
+	policy, err = loadRetentionPolicy(path)
+	require.NoError(t, err)
+	require.Equal(t, testRetentionPolicy, policy)
+	require.Equal(t, 730, policy.longestDays())
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"default_days": 30, "rules": [{"days": 1}]}`), 0o600))
+	_, err = loadRetentionPolicy(path)
+	require.Error(t, err)
+}
+
+// writeRetentionFixture stores a secret rare and a common of group 1 and a
+// common of group 2 with an old and a recent price each
+func writeRetentionFixture(t *testing.T, s Store, now time.Time) {
+	ctx := context.Background()
+
+	_, err := s.UpsertGroups(ctx, []*tcgplayer.Group{
+		{ID: 1, Name: "test-1", CategoryID: tcgplayer.CategoryYugioh},
+		{ID: 2, Name: "test-2", CategoryID: tcgplayer.CategoryYugioh},
+	})
+	require.NoError(t, err)
+	require.NoError(t, s.UpsertRarities(ctx, []*tcgplayer.Rarity{
+		{ID: 1, Name: defaultRarityName},
+		{ID: 2, Name: rarityNameCommon},
+		{ID: 3, Name: "Secret Rare"},
+	}))
+	require.NoError(t, s.UpsertPrintings(ctx, []*tcgplayer.Printing{{ID: 1, Name: "1st Edition"}}))
+	require.NoError(t, s.UpsertConditions(ctx, []*tcgplayer.Condition{{ID: 1, Name: "Near Mint"}}))
+	require.NoError(t, s.UpsertLanguages(ctx, []*tcgplayer.Language{{ID: englishLanguageID, Name: "English"}}))
+
+	product := func(id, groupID int, rarity string) *tcgplayer.Product {
+		return &tcgplayer.Product{
+			ID:           id,
+			GroupID:      groupID,
+			CategoryID:   tcgplayer.CategoryYugioh,
+			CleanName:    "test",
+			ExtendedData: []tcgplayer.ExtendedData{{Name: "Rarity", Value: rarity}},
+			SKUS: []tcgplayer.SKU{{SKUID: id * 10, ProductID: id, PrintingID: 1, ConditionID: 1,
+				LanguageID: englishLanguageID}},
+		}
+	}
+	_, err = s.UpsertProducts(ctx, nil, []*tcgplayer.Product{
+		product(1, 1, "Secret Rare"),
+		product(2, 1, "Common"),
+		product(3, 2, "Common"),
+	})
+	require.NoError(t, err)
+
+	prices := []skuPrice{{SKUID: 10, IngestedAt: now.AddDate(0, 0, -800)}}
+	for _, id := range []int{10, 20, 30} {
+		prices = append(prices,
+			skuPrice{SKUID: id, IngestedAt: now.AddDate(0, 0, -100)},
+			skuPrice{SKUID: id, IngestedAt: now.AddDate(0, 0, -10)})
+	}
+	require.NoError(t, s.InsertPrices(ctx, prices))
+}
+
+// the secret rare keeps its 100 day old price, group 2 keeps every price
+// and the common of group 1 only keeps its recent one
+var testRetentionResults = []retentionResult{
+	{Rule: "chase", Trimmed: 1},
+	{Rule: "sets", Trimmed: 0},
+	{Rule: defaultRetentionRule, Trimmed: 1},
+}
+
+func TestMemoryStore_TrimPricesByRule(t *testing.T) {
+	now := time.Now()
+	s := newMemoryStore()
+	writeRetentionFixture(t, s, now)
+
+	results, err := s.TrimPrices(context.Background(), testRetentionPolicy, now)
+	require.NoError(t, err)
+	require.Equal(t, testRetentionResults, results)
+	require.Len(t, s.prices, 5)
+}
+
+func TestSQLiteTrimPricesByRule(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	dbConn, err := getDBConnection(dbDriverSQLite, "", "", "", "", filepath.Join(t.TempDir(), "dev.db"))
+	require.NoError(t, err)
+	require.NoError(t, migrate(ctx, dbConn))
+	s := newSQLStore(dbConn)
+	writeRetentionFixture(t, s, now)
+
+	results, err := s.TrimPrices(ctx, testRetentionPolicy, now)
+	require.NoError(t, err)
+	require.Equal(t, testRetentionResults, results)
+
+	var prices int64
+	require.NoError(t, dbConn.Model(&store.SKUPrice{}).Count(&prices).Error)
+	require.Equal(t, int64(5), prices)
+}
+
+func TestTrimPrices_RarityMatchesStoredRarity(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	dbConn, err := getDBConnection(dbDriverSQLite, "", "", "", "", filepath.Join(t.TempDir(), "dev.db"))
+	require.NoError(t, err)
+	require.NoError(t, migrate(ctx, dbConn))
+
+	// the commons of the fixture are listed as Common by tcgplayer and stored
+	// as rarityNameCommon, both stores keep their old prices
+	policy := &retentionPolicy{
+		DefaultDays: 30,
+		Rules:       []retentionRule{{Name: "commons", Days: 365, Rarities: []string{rarityNameCommon}}},
+	}
+	for _, s := range []Store{newMemoryStore(), newSQLStore(dbConn)} {
+		writeRetentionFixture(t, s, now)
+
+		results, err := s.TrimPrices(ctx, policy, now)
+		require.NoError(t, err)
+		require.Equal(t, []retentionResult{
+			{Rule: "commons", Trimmed: 0},
+			{Rule: defaultRetentionRule, Trimmed: 2},
+		}, results)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	errors "github.com/AustinMCrane/errorutil"
+)
+
+const commandRuns = "runs"
+
+// ingestRun is a run of a command that writes to the store, kept so the
+// runs command can tell how the latest ones went
+type ingestRun struct {
+	RunID      string `gorm:"primaryKey"`
+	Command    string
+	StartedAt  time.Time `gorm:"index"`
+	FinishedAt time.Time
+	OK         bool
+	Error      string
+}
+
+func (ingestRun) TableName() string {
+	return "ingest_runs"
+}
+
+func newIngestRun(runID string, start time.Time, completed runCompletedEvent) *ingestRun {
+	return &ingestRun{
+		RunID:      runID,
+		Command:    completed.Command,
+		StartedAt:  start,
+		FinishedAt: start.Add(time.Duration(completed.DurationSeconds * float64(time.Second))),
+		OK:         completed.OK,
+		Error:      completed.Error,
+	}
+}
+
+// recordRun writes the run, a run recorded twice keeps its last outcome
+func recordRun(ctx context.Context, dbConn *gorm.DB, run *ingestRun) error {
+	err := dbConn.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(run).Error
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
+
+// ExecRuns is the entry point of the runs command, it prints the latest runs
+func ExecRuns(ctx context.Context, args []string) error {
+	fs := newCommandFlagSet(commandRuns)
+	limit := fs.Int("limit", 20, "how many runs are listed")
+	command := fs.String("command", "", "only list the runs of this command")
+	err := fs.Parse(args)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	dbConn, err := openDB(ctx, commandRuns)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+	defer closeDB(dbConn)
+
+	runs, err := listRuns(ctx, dbConn, *command, *limit)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	writeRuns(os.Stdout, runs)
+	return nil
+}
+
+// listRuns returns the latest runs first, of every command when command is
+// empty
+func listRuns(ctx context.Context, dbConn *gorm.DB, command string, limit int) ([]*ingestRun, error) {
+	q := dbConn.WithContext(ctx).Order("started_at DESC").Limit(limit)
+	if command != "" {
+		q = q.Where("command = ?", command)
+	}
+
+	runs := []*ingestRun{}
+	err := q.Find(&runs).Error
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	return runs, nil
+}
+
+// writeRuns prints a line per run
+func writeRuns(w io.Writer, runs []*ingestRun) {
+	for _, r := range runs {
+		status := "ok"
+		if !r.OK {
+			status = "FAIL"
+		}
+
+		fmt.Fprintf(w, "%-4s  %-16s %s  %s  %-8s %s\n", status, r.Command,
+			r.StartedAt.UTC().Format(time.RFC3339), r.RunID,
+			r.FinishedAt.Sub(r.StartedAt).Round(time.Second), r.Error)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRuns(t *testing.T) {
+	ctx := context.Background()
+
+	dbConn, err := getDBConnection(dbDriverSQLite, "", "", "", "", filepath.Join(t.TempDir(), "dev.db"))
+	require.NoError(t, err)
+	require.NoError(t, migrate(ctx, dbConn))
+
+	start := time.Date(2023, 3, 14, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, recordRun(ctx, dbConn, newIngestRun("run-1", start, runCompletedEvent{
+		Command:         commandSyncCatalog,
+		OK:              true,
+		DurationSeconds: 90,
+	})))
+	require.NoError(t, recordRun(ctx, dbConn, newIngestRun("run-2", start.Add(time.Hour), runCompletedEvent{
+		Command:         commandIngestPrices,
+		DurationSeconds: 5,
+		Error:           "rate limited",
+	})))
+
+	runs, err := listRuns(ctx, dbConn, "", 10)
+	require.NoError(t, err)
+	out := bytes.Buffer{}
+	writeRuns(&out, runs)
+	require.Equal(t, ""+
+		"FAIL  ingest-prices    2023-03-14T13:00:00Z  run-2  5s       rate limited\n"+
+		"ok    sync-catalog     2023-03-14T12:00:00Z  run-1  1m30s    \n", out.String())
+
+	runs, err = listRuns(ctx, dbConn, commandSyncCatalog, 10)
+	require.NoError(t, err)
+	require.Len(t, runs, 1)
+	require.Equal(t, "run-1", runs[0].RunID)
+
+	runs, err = listRuns(ctx, dbConn, "", 1)
+	require.NoError(t, err)
+	require.Len(t, runs, 1)
+	require.Equal(t, "run-2", runs[0].RunID)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+
+	errors "github.com/AustinMCrane/errorutil"
+	"github.com/AustinMCrane/tcgplayer"
+)
+
+// groupSample limits a catalog sync to a few complete groups so a dev
+// database is small but every row it holds references rows it also holds
+type groupSample struct {
+	// names are the groups to sample by tcgplayer id or name
+	names []string
+	// size is how many groups are picked at random when no names are given
+	size int
+	// seed picks the random groups, the same seed picks the same groups
+	seed int64
+}
+
+// parseGroupNames splits a comma separated list of group ids or names
+func parseGroupNames(s string) []string {
+	names := []string{}
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if f != "" {
+			names = append(names, f)
+		}
+	}
+
+	return names
+}
+
+// pick returns the sampled groups sorted by id, every named group has to
+// exist
+func (s groupSample) pick(groups []*tcgplayer.Group) ([]*tcgplayer.Group, error) {
+	if len(s.names) > 0 {
+		picked := []*tcgplayer.Group{}
+		for _, name := range s.names {
+			g := findGroup(groups, name)
+			if g == nil {
+				return nil, errors.New(fmt.Sprintf("unable to find group %s", name))
+			}
+			picked = append(picked, g)
+		}
+		sortGroups(picked)
+
+		return picked, nil
+	}
+
+	if s.size >= len(groups) {
+		return groups, nil
+	}
+
+	// groups are ranked by a hash of their id so a group published later
+	// only replaces a picked one when it ranks before it
+	ranked := append([]*tcgplayer.Group{}, groups...)
+	sort.Slice(ranked, func(i, j int) bool {
+		ri, rj := sampleRank(s.seed, ranked[i].ID), sampleRank(s.seed, ranked[j].ID)
+		if ri == rj {
+			return ranked[i].ID < ranked[j].ID
+		}
+		return ri < rj
+	})
+
+	picked := ranked[:s.size]
+	sortGroups(picked)
+
+	return picked, nil
+}
+
+// findGroup returns the group with the tcgplayer id or the name, names are
+// compared case insensitively
+func findGroup(groups []*tcgplayer.Group, name string) *tcgplayer.Group {
+	id, err := strconv.Atoi(name)
+	for _, g := range groups {
+		if (err == nil && g.ID == id) || strings.EqualFold(g.Name, name) {
+			return g
+		}
+	}
+
+	return nil
+}
+
+func sampleRank(seed int64, id int) uint64 {
+	h := fnv.New64a()
+	b := make([]byte, 16)
+	binary.LittleEndian.PutUint64(b, uint64(seed))
+	binary.LittleEndian.PutUint64(b[8:], uint64(id))
+	h.Write(b)
+
+	return h.Sum64()
+}
+
+func sortGroups(groups []*tcgplayer.Group) {
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].ID < groups[j].ID
+	})
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/AustinMCrane/tcgplayer"
+	gomock "github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func testGroups(n int) []*tcgplayer.Group {
+	groups := []*tcgplayer.Group{}
+	for i := 1; i <= n; i++ {
+		groups = append(groups, &tcgplayer.Group{ID: i, Name: fmt.Sprintf("test-%d", i)})
+	}
+
+	return groups
+}
+
+func groupIDs(groups []*tcgplayer.Group) []int {
+	ids := []int{}
+	for _, g := range groups {
+		ids = append(ids, g.ID)
+	}
+
+	return ids
+}
+
+func TestGroupSample_Random(t *testing.T) {
+	groups := testGroups(50)
+
+	picked, err := groupSample{size: 5, seed: 1}.pick(groups)
+	require.NoError(t, err)
+	require.Len(t, picked, 5)
+	require.IsIncreasing(t, groupIDs(picked))
+
+	// the same seed picks the same groups
+	again, err := groupSample{size: 5, seed: 1}.pick(groups)
+	require.NoError(t, err)
+	require.Equal(t, groupIDs(picked), groupIDs(again))
+
+	other, err := groupSample{size: 5, seed: 2}.pick(groups)
+	require.NoError(t, err)
+	require.NotEqual(t, groupIDs(picked), groupIDs(other))
+
+	// a new group replaces at most one picked group
+	more, err := groupSample{size: 5, seed: 1}.pick(testGroups(51))
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, len(intersect(groupIDs(picked), groupIDs(more))), 4)
+
+	all, err := groupSample{size: 100, seed: 1}.pick(groups)
+	require.NoError(t, err)
+	require.Len(t, all, 50)
+}
+
+func intersect(a []int, b []int) []int {
+	both := []int{}
+	for _, id := range a {
+		if containsID(b, id) {
+			both = append(both, id)
+		}
+	}
+
+	return both
+}
+
+func TestGroupSample_Named(t *testing.T) {
+	groups := testGroups(10)
+
+	picked, err := groupSample{names: parseGroupNames("TEST-7, 3")}.pick(groups)
+	require.NoError(t, err)
+	require.Equal(t, []int{3, 7}, groupIDs(picked))
+
+	_, err = groupSample{names: []string{"test-11"}}.pick(groups)
+	require.Error(t, err)
+}
+
+func TestUpdateImmutableDataTcgPlayer_Sample(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	client := NewMockTcgplayer(ctrl)
+	s := newMemoryStore()
+
+	client.EXPECT().GetGroups(gomock.Any(), gomock.Any()).Return(testGroups(10), nil)
+	client.EXPECT().GetRarities(gomock.Any(), gomock.Any()).Return(nil, nil)
+	client.EXPECT().GetPrinting(gomock.Any(), gomock.Any()).Return(nil, nil)
+	client.EXPECT().GetConditions(gomock.Any(), gomock.Any()).Return(nil, nil)
+	client.EXPECT().GetLanguages(gomock.Any(), gomock.Any()).Return(nil, nil)
+
+	// only the sampled groups are crawled
+	for _, id := range []int{2, 9} {
+		client.EXPECT().ListAllProducts(gomock.Any(), tcgplayer.ProductParams{
+			CategoryID: tcgplayer.CategoryYugioh,
+			GroupName:  fmt.Sprintf("test-%d", id),
+			Limit:      100,
+		}).Return([]*tcgplayer.Product{{ID: id * 10, GroupID: id}}, nil)
+	}
+
+	err := updateImmutableDataTcgPlayer(ctx, s, client, nil, tcgplayer.CategoryYugioh, catalogOptions{
+		sample: &groupSample{names: []string{"test-9", "2"}},
+	})
+	require.NoError(t, err)
+
+	// and they are the only groups written
+	require.Len(t, s.groups, 2)
+	require.Contains(t, s.groups, 2)
+	require.Contains(t, s.groups, 9)
+	require.Len(t, s.products, 2)
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/xitongsys/parquet-go/writer"
+	"gocloud.dev/blob"
+	"gorm.io/gorm"
+
+	errors "github.com/AustinMCrane/errorutil"
+	"github.com/AustinMCrane/tcgplayer"
+)
+
+// pullRates is how many cards of each rarity a booster pack holds on
+// average, by rarity name
+type pullRates map[string]float64
+
+// loadPullRates reads the pull rates from a json object of rarity names to
+// cards per pack, there are none when path is empty
+func loadPullRates(path string) (pullRates, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	read := pullRates{}
+	err = json.Unmarshal(b, &read)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	if len(read) == 0 {
+		return nil, errors.New("pull rates config has no rarities: " + path)
+	}
+
+	rates := pullRates{}
+	for name, rate := range read {
+		if rate < 0 {
+			return nil, errors.New(fmt.Sprintf("pull rate of %s must not be negative", name))
+		}
+
+		// commons are stored under their full name, see rarityNameCommon
+		if name == "Common" {
+			name = rarityNameCommon
+		}
+		rates[name] = rate
+	}
+
+	return rates, nil
+}
+
+// productRarity is the name of the rarity a product is stored with
+func productRarity(p *tcgplayer.Product) string {
+	rarity, err := p.GetExtendedData("Rarity")
+	if err != nil {
+		return defaultRarityName
+	}
+
+	if rarity.Value == "Common" {
+		return rarityNameCommon
+	}
+
+	return rarity.Value
+}
+
+// setIndex adds up the cheapest copy of the cards of a set by rarity
+type setIndex struct {
+	sums  map[string]cents
+	cards map[string]int
+}
+
+func newSetIndex() *setIndex {
+	return &setIndex{sums: map[string]cents{}, cards: map[string]int{}}
+}
+
+func (s *setIndex) add(rarity string, price cents) {
+	s.sums[rarity] += price
+	s.cards[rarity]++
+}
+
+// weighted is what the cards of a pack are worth on average rounded to the
+// nearest cent, every rarity adds its pull rate times the average price of
+// its cards, rarities without a pull rate aren't found in packs
+func (s *setIndex) weighted(rates pullRates) cents {
+	index := 0.0
+	for rarity, sum := range s.sums {
+		index += rates[rarity] * float64(sum) / float64(s.cards[rarity])
+	}
+
+	return cents(math.Round(index))
+}
+
+// setIndexPoint is the value and index of a group at a point in time
+type setIndexPoint struct {
+	ComputedAt time.Time `json:"computed_at"`
+	GroupID    int       `json:"group_id"`
+	Group      string    `json:"group"`
+	Value      cents     `json:"value"`
+	Index      cents     `json:"index" gorm:"column:set_index"`
+	Currency   string    `json:"currency" gorm:"-"`
+	Weighted   bool      `json:"weighted"`
+	Cards      int       `json:"cards"`
+}
+
+// parquetSetIndexPoint is the parquet schema of a setIndexPoint
+type parquetSetIndexPoint struct {
+	ComputedAt int64  `parquet:"name=computed_at, type=INT64, convertedtype=TIMESTAMP_MILLIS"`
+	GroupID    int64  `parquet:"name=group_id, type=INT64"`
+	Group      string `parquet:"name=group, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Value      int64  `parquet:"name=value, type=INT64, convertedtype=DECIMAL, scale=2, precision=18"`
+	Index      int64  `parquet:"name=index, type=INT64, convertedtype=DECIMAL, scale=2, precision=18"`
+	Currency   string `parquet:"name=currency, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Weighted   bool   `parquet:"name=weighted, type=BOOLEAN"`
+	Cards      int64  `parquet:"name=cards, type=INT64"`
+}
+
+var setIndexHeader = []string{"computed_at", "group_id", "group", "value", "index", "currency", "weighted", "cards"}
+
+// groupValueColumns selects the exact value and index of ingest_group_values,
+// the points computed before the cents columns were added are rounded to the
+// nearest cent
+const groupValueColumns = "COALESCE(ingest_group_values.value_cents, " +
+	"CAST(ROUND(ingest_group_values.value * 100) AS BIGINT)) AS value, " +
+	"COALESCE(ingest_group_values.set_index_cents, " +
+	"CAST(ROUND(ingest_group_values.set_index * 100) AS BIGINT)) AS set_index"
+
+// listSetIndexes returns the set index points computed in [from, to) of the
+// groups whose name contains group, of every group when it is empty, in the
+// currency of conv at the rate of the day each point was computed
+func listSetIndexes(ctx context.Context, dbConn *gorm.DB, conv *converter, group string, from time.Time,
+	to time.Time) ([]*setIndexPoint, error) {
+	q := dbConn.WithContext(ctx).Table("ingest_group_values").
+		Select("ingest_group_values.computed_at, ingest_group_values.group_id, groups.name AS \"group\", "+
+			groupValueColumns+", ingest_group_values.weighted, ingest_group_values.cards").
+		Joins("JOIN groups ON groups.tcgplayer_id = ingest_group_values.group_id").
+		Where("ingest_group_values.computed_at >= ? AND ingest_group_values.computed_at < ?", from, to)
+	if group != "" {
+		q = q.Where("lower(groups.name) LIKE ? ESCAPE '!'", containsPattern(group))
+	}
+
+	points := []*setIndexPoint{}
+	err := q.Order("groups.name, ingest_group_values.computed_at").Scan(&points).Error
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	// the group values are aggregated in dollars
+	for _, p := range points {
+		p.Value, err = conv.convert(p.Value, priceCurrency, p.ComputedAt)
+		if err != nil {
+			return nil, errors.Wrap(err)
+		}
+
+		p.Index, err = conv.convert(p.Index, priceCurrency, p.ComputedAt)
+		if err != nil {
+			return nil, errors.Wrap(err)
+		}
+		p.Currency = conv.currency
+	}
+
+	return points, nil
+}
+
+// exportSetIndexes writes the set index points computed in [from, to) to
+// key in the bucket in the currency of conv and returns how many were
+// written
+func exportSetIndexes(ctx context.Context, dbConn *gorm.DB, bucket *blob.Bucket, key string,
+	format string, from time.Time, to time.Time, conv *converter) (int, error) {
+	points, err := listSetIndexes(ctx, dbConn, conv, "", from, to)
+	if err != nil {
+		return 0, errors.Wrap(err)
+	}
+
+	// cancelling the writer's context before closing it discards the
+	// object, so a failed export never leaves a truncated file behind
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	w, err := bucket.NewWriter(wctx, key, nil)
+	if err != nil {
+		return 0, errors.Wrap(err)
+	}
+
+	err = writeSetIndexes(w, points, format)
+	if err != nil {
+		cancel()
+		w.Close()
+		return 0, errors.Wrap(err)
+	}
+
+	err = w.Close()
+	if err != nil {
+		return 0, errors.Wrap(err)
+	}
+
+	return len(points), nil
+}
+
+// writeSetIndexes writes the points in an export format
+func writeSetIndexes(w io.Writer, points []*setIndexPoint, format string) error {
+	switch format {
+	case exportFormatCSV:
+		cw := csv.NewWriter(w)
+		err := cw.Write(setIndexHeader)
+		if err != nil {
+			return errors.Wrap(err)
+		}
+
+		for _, p := range points {
+			err = cw.Write([]string{
+				p.ComputedAt.UTC().Format(time.RFC3339),
+				strconv.Itoa(p.GroupID),
+				p.Group,
+				p.Value.String(),
+				p.Index.String(),
+				p.Currency,
+				strconv.FormatBool(p.Weighted),
+				strconv.Itoa(p.Cards),
+			})
+			if err != nil {
+				return errors.Wrap(err)
+			}
+		}
+
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return errors.Wrap(err)
+		}
+	case exportFormatJSONL:
+		enc := json.NewEncoder(w)
+		for _, p := range points {
+			err := enc.Encode(p)
+			if err != nil {
+				return errors.Wrap(err)
+			}
+		}
+	case exportFormatParquet:
+		pw, err := writer.NewParquetWriterFromWriter(w, new(parquetSetIndexPoint), 1)
+		if err != nil {
+			return errors.Wrap(err)
+		}
+
+		for _, p := range points {
+			err = pw.Write(parquetSetIndexPoint{
+				ComputedAt: p.ComputedAt.UnixMilli(),
+				GroupID:    int64(p.GroupID),
+				Group:      p.Group,
+				Value:      int64(p.Value),
+				Index:      int64(p.Index),
+				Currency:   p.Currency,
+				Weighted:   p.Weighted,
+				Cards:      int64(p.Cards),
+			})
+			if err != nil {
+				return errors.Wrap(err)
+			}
+		}
+
+		err = pw.WriteStop()
+		if err != nil {
+			return errors.Wrap(err)
+		}
+	default:
+		return errors.New("unknown export format: " + format)
+	}
+
+	return nil
+}
+
+// writeSetIndexTable prints a line per point
+func writeSetIndexTable(w io.Writer, points []*setIndexPoint) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "GROUP\tAS OF\tVALUE\tINDEX\tCURRENCY\tWEIGHTED\tCARDS")
+	for _, p := range points {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%t\t%d\n", p.Group, p.ComputedAt.UTC().Format(time.RFC3339),
+			p.Value, p.Index, p.Currency, p.Weighted, p.Cards)
+	}
+
+	err := tw.Flush()
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writePullRates(t *testing.T, config string) string {
+	path := filepath.Join(t.TempDir(), "pull-rates.json")
+	require.NoError(t, os.WriteFile(path, []byte(config), 0o644))
+
+	return path
+}
+
+func TestLoadPullRates(t *testing.T) {
+	rates, err := loadPullRates("")
+	require.NoError(t, err)
+	require.Nil(t, rates)
+
+	rates, err = loadPullRates(writePullRates(t, `{"Common": 7, "Secret Rare": 0.05}`))
+	require.NoError(t, err)
+	require.Equal(t, pullRates{rarityNameCommon: 7, "Secret Rare": 0.05}, rates)
+
+	_, err = loadPullRates(writePullRates(t, `{}`))
+	require.Error(t, err)
+
+	_, err = loadPullRates(writePullRates(t, `{"Rare": -1}`))
+	require.Error(t, err)
+}
+
+func TestAggregatePrices_PullRates(t *testing.T) {
+	now := time.Now()
+
+	_, groups := aggregatePrices([]latestPrice{
+		{SKUID: 10, ProductID: 1, GroupID: 1, Rarity: rarityNameCommon, Price: 100},
+		{SKUID: 20, ProductID: 2, GroupID: 1, Rarity: rarityNameCommon, Price: 300},
+		{SKUID: 30, ProductID: 3, GroupID: 1, Rarity: "Secret Rare", Price: 2000},
+		// not found in packs
+		{SKUID: 40, ProductID: 4, GroupID: 1, Rarity: "Ghost Rare", Price: 10000},
+	}, pullRates{rarityNameCommon: 7, "Secret Rare": 0.05}, now)
+
+	// 7 commons worth 2 on average and a twentieth of a secret rare
+	require.Len(t, groups, 1)
+	require.Equal(t, cents(12400), groups[0].ValueCents)
+	require.Equal(t, cents(1500), groups[0].IndexCents)
+	require.Equal(t, 15.0, groups[0].Index)
+	require.True(t, groups[0].Weighted)
+	require.Equal(t, 4, groups[0].Cards)
+}
+
+func TestSQLiteSetIndexes(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+
+	dbConn, err := getDBConnection(dbDriverSQLite, "", "", "", "", filepath.Join(t.TempDir(), "dev.db"))
+	require.NoError(t, err)
+	require.NoError(t, migrate(ctx, dbConn))
+	s := newSQLStore(dbConn)
+	writeRetentionFixture(t, s, now)
+	require.NoError(t, s.InsertPrices(ctx, []skuPrice{
+		{SKUID: 10, PriceCents: 4000, IngestedAt: now},
+		{SKUID: 20, PriceCents: 200, IngestedAt: now},
+		{SKUID: 30, PriceCents: 100, IngestedAt: now},
+	}))
+
+	rates := pullRates{rarityNameCommon: 7, "Secret Rare": 0.05}
+	require.NoError(t, updatePriceAggregates(ctx, s, rates, now.Add(-time.Hour)))
+	require.NoError(t, updatePriceAggregates(ctx, s, rates, now))
+
+	conv, err := newConverter(ctx, dbConn, priceCurrency, now)
+	require.NoError(t, err)
+
+	points, err := listSetIndexes(ctx, dbConn, conv, "TEST-1", now.Add(-time.Hour*2), now.Add(time.Second))
+	require.NoError(t, err)
+	require.Len(t, points, 2)
+	require.Equal(t, "test-1", points[1].Group)
+	require.Equal(t, cents(4200), points[1].Value)
+	require.Equal(t, cents(1600), points[1].Index)
+	require.Equal(t, priceCurrency, points[1].Currency)
+	require.True(t, points[1].Weighted)
+	require.True(t, now.Equal(points[1].ComputedAt))
+
+	// wildcards in the group match themselves
+	points, err = listSetIndexes(ctx, dbConn, conv, "test_1", now.Add(-time.Hour*2), now.Add(time.Second))
+	require.NoError(t, err)
+	require.Empty(t, points)
+
+	// the points are converted at the rate of the day they were computed
+	require.NoError(t, s.UpsertExchangeRates(ctx, []*exchangeRate{
+		{Day: now.Add(-time.Hour * 48).Format(dateLayout), Currency: "EUR", Rate: 800000},
+	}))
+	eur, err := newConverter(ctx, dbConn, "EUR", now)
+	require.NoError(t, err)
+
+	points, err = listSetIndexes(ctx, dbConn, eur, "test-1", now.Add(-time.Hour*2), now.Add(time.Second))
+	require.NoError(t, err)
+	require.Equal(t, cents(3360), points[1].Value)
+	require.Equal(t, cents(1280), points[1].Index)
+	require.Equal(t, "EUR", points[1].Currency)
+
+	// the range is half open
+	points, err = listSetIndexes(ctx, dbConn, conv, "", now.Add(-time.Hour*2), now)
+	require.NoError(t, err)
+	require.Len(t, points, 2)
+	require.Equal(t, []string{"test-1", "test-2"}, []string{points[0].Group, points[1].Group})
+
+	out := bytes.Buffer{}
+	require.NoError(t, writeSetIndexes(&out, points, exportFormatCSV))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 3)
+	require.Equal(t, "computed_at,group_id,group,value,index,currency,weighted,cards", lines[0])
+	require.True(t, strings.HasSuffix(lines[2], ",2,test-2,1.00,7.00,USD,true,1"), lines[2])
+
+	out.Reset()
+	require.NoError(t, writeSetIndexes(&out, points, exportFormatJSONL))
+	require.Equal(t, 2, strings.Count(out.String(), "\n"))
+
+	out.Reset()
+	require.NoError(t, writeSetIndexes(&out, points, exportFormatParquet))
+	require.True(t, bytes.HasPrefix(out.Bytes(), []byte("PAR1")))
+
+	out.Reset()
+	require.NoError(t, writeSetIndexTable(&out, points))
+	require.Contains(t, out.String(), "42.00  16.00  USD       true")
+}
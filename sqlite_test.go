@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AustinMCrane/tcg-market-watch-api/pkg/store"
+	"github.com/AustinMCrane/tcgplayer"
+	gomock "github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"gocloud.dev/blob/memblob"
+)
+
+func TestSQLiteSync(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	client := NewMockTcgplayer(ctrl)
+
+	dbConn, err := getDBConnection(dbDriverSQLite, "", "", "", "", filepath.Join(t.TempDir(), "dev.db"))
+	require.NoError(t, err)
+	require.NoError(t, migrate(ctx, dbConn))
+	s := newSQLStore(dbConn)
+
+	client.EXPECT().GetGroups(gomock.Any(), gomock.Any()).
+		Return([]*tcgplayer.Group{{ID: 1, Name: "test-1", CategoryID: tcgplayer.CategoryYugioh}}, nil).Times(2)
+	client.EXPECT().GetRarities(gomock.Any(), gomock.Any()).
+		Return([]*tcgplayer.Rarity{
+			{ID: 1, Name: defaultRarityName},
+			{ID: 2, Name: rarityNameCommon},
+		}, nil).Times(2)
+	client.EXPECT().GetPrinting(gomock.Any(), gomock.Any()).
+		Return([]*tcgplayer.Printing{{ID: 1, Name: "1st Edition"}}, nil).Times(2)
+	client.EXPECT().GetConditions(gomock.Any(), gomock.Any()).
+		Return([]*tcgplayer.Condition{{ID: 1, Name: "Near Mint"}}, nil).Times(2)
+	client.EXPECT().GetLanguages(gomock.Any(), gomock.Any()).
+		Return([]*tcgplayer.Language{{ID: 1, Name: "English"}}, nil).Times(2)
+	client.EXPECT().ListAllProducts(gomock.Any(), gomock.Any()).
+		Return([]*tcgplayer.Product{{
+			ID:           1,
+			GroupID:      1,
+			CategoryID:   tcgplayer.CategoryYugioh,
+			CleanName:    "test",
+			ExtendedData: []tcgplayer.ExtendedData{{Name: "Rarity", Value: "Common"}},
+			SKUS:         []tcgplayer.SKU{{SKUID: 10, ProductID: 1, PrintingID: 1, ConditionID: 1, LanguageID: 1}},
+		}}, nil).Times(2)
+
+	err = updateImmutableDataTcgPlayer(ctx, s, client, nil, tcgplayer.CategoryYugioh, catalogOptions{})
+	require.NoError(t, err)
+
+	// a full refresh empties the catalog without TRUNCATE
+	err = updateImmutableDataTcgPlayer(ctx, s, client, nil, tcgplayer.CategoryYugioh,
+		catalogOptions{fullRefresh: true})
+	require.NoError(t, err)
+
+	var products, skus int64
+	require.NoError(t, dbConn.Model(&store.Product{}).Count(&products).Error)
+	require.NoError(t, dbConn.Model(&store.SKU{}).Count(&skus).Error)
+	require.Equal(t, int64(1), products)
+	require.Equal(t, int64(1), skus)
+
+	client.EXPECT().GetSKUPrices(gomock.Any(), []int{10}).
+		Return([]*tcgplayer.SKUMarketPrice{{SKUID: 10, LowPrice: 1.5}}, nil)
+	require.NoError(t, ingetPrices(ctx, s, client, nil, 0, testFlushPolicy))
+
+	prices := []skuPrice{}
+	require.NoError(t, dbConn.Find(&prices).Error)
+	require.Len(t, prices, 1)
+	require.False(t, prices[0].IngestedAt.IsZero())
+	require.Equal(t, cents(150), prices[0].PriceCents)
+
+	// the maintenance commands work on the same file
+	results, err := verifyCatalog(ctx, dbConn, integrityChecks)
+	require.NoError(t, err)
+	require.Zero(t, writeReport(io.Discard, results))
+
+	bucket := memblob.OpenBucket(nil)
+	defer bucket.Close()
+	day := prices[0].IngestedAt.UTC().Truncate(time.Hour * 24)
+	count, err := exportPrices(ctx, dbConn, bucket, "prices.csv", exportFormatCSV, day, day.AddDate(0, 0, 1),
+		&converter{currency: priceCurrency})
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+}
+
+func TestMigrate_GroupSyncBaseline(t *testing.T) {
+	ctx := context.Background()
+
+	dbConn, err := getDBConnection(dbDriverSQLite, "", "", "", "", filepath.Join(t.TempDir(), "dev.db"))
+	require.NoError(t, err)
+	require.NoError(t, dbConn.AutoMigrate(&store.Group{}))
+	require.NoError(t, dbConn.Create(&store.Group{TCGPlayerID: 1, Name: "test-1"}).Error)
+
+	// the groups stored before the sync table existed count as synced
+	require.NoError(t, migrate(ctx, dbConn))
+	s := newSQLStore(dbConn)
+
+	synced, err := s.SyncedGroups(ctx, []int{1, 2})
+	require.NoError(t, err)
+	require.Equal(t, map[int]string{1: ""}, synced)
+
+	// the baseline is only recorded once
+	require.NoError(t, dbConn.Create(&store.Group{TCGPlayerID: 2, Name: "test-2"}).Error)
+	require.NoError(t, migrate(ctx, dbConn))
+
+	synced, err = s.SyncedGroups(ctx, []int{1, 2})
+	require.NoError(t, err)
+	require.Equal(t, map[int]string{1: ""}, synced)
+}
@@ -0,0 +1,503 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+
+	errors "github.com/AustinMCrane/errorutil"
+	"github.com/AustinMCrane/tcg-market-watch-api/pkg/store"
+	"github.com/AustinMCrane/tcgplayer"
+)
+
+// Store is where the ingester keeps the catalog and the prices, everything
+// is addressed by tcgplayer ids so callers don't depend on how a backend
+// keys its rows
+type Store interface {
+	// Transaction runs fn against a store whose writes are all kept when fn
+	// succeeds and all discarded when it returns an error
+	Transaction(ctx context.Context, fn func(s Store) error) error
+
+	// Truncate removes the catalog, categories and prices are kept
+	Truncate(ctx context.Context) error
+
+	UpsertCategories(ctx context.Context, categories []*tcgplayer.Category) error
+	// UpsertGroups adds the groups that aren't stored yet and returns them
+	UpsertGroups(ctx context.Context, groups []*tcgplayer.Group) ([]*tcgplayer.Group, error)
+	UpsertRarities(ctx context.Context, rarities []*tcgplayer.Rarity) error
+	UpsertPrintings(ctx context.Context, printings []*tcgplayer.Printing) error
+	UpsertConditions(ctx context.Context, conditions []*tcgplayer.Condition) error
+	UpsertLanguages(ctx context.Context, languages []*tcgplayer.Language) error
+
+	// UpsertProducts writes products with their skus, the products already
+	// stored and every other product of groupIDs are replaced, it returns
+	// the products that weren't stored before
+	UpsertProducts(ctx context.Context, groupIDs []int, products []*tcgplayer.Product) ([]*tcgplayer.Product, error)
+	// KnownProductIDs returns which of the product ids are stored
+	KnownProductIDs(ctx context.Context, ids []int) ([]int, error)
+
+	// SyncedGroups returns the publishedOn each of the groups had when its
+	// products were last written, groups never written are left out
+	SyncedGroups(ctx context.Context, ids []int) (map[int]string, error)
+	MarkGroupsSynced(ctx context.Context, groups []*tcgplayer.Group) error
+
+	ListSKUIDs(ctx context.Context) ([]int, error)
+	// WatchedSKUIDs returns the ids of the skus the watchlist matches
+	WatchedSKUIDs(ctx context.Context) ([]int, error)
+	InsertPrices(ctx context.Context, prices []skuPrice) error
+	// PricedSKUIDs returns the ids among skuIDs of the skus with a price
+	// ingested at the given time
+	PricedSKUIDs(ctx context.Context, ingestedAt time.Time, skuIDs []int) ([]int, error)
+	// PriceStats summarizes the prices of every sku priced since the given
+	// time, by tcgplayer sku id
+	PriceStats(ctx context.Context, since time.Time) (map[int]priceStats, error)
+	// LatestPrices returns the latest price of every sku
+	LatestPrices(ctx context.Context) ([]latestPrice, error)
+	// WriteAggregates replaces the product prices and adds the group values
+	WriteAggregates(ctx context.Context, products []*productPrice, groups []*groupValue) error
+	// UpsertExchangeRates writes the rates, replacing the ones of the same
+	// day and currency
+	UpsertExchangeRates(ctx context.Context, rates []*exchangeRate) error
+	// RecordRun writes the outcome of a run
+	RecordRun(ctx context.Context, run *ingestRun) error
+
+	// TrimPrices removes the prices the retention policy no longer keeps at
+	// now and returns how many were removed under each rule
+	TrimPrices(ctx context.Context, policy *retentionPolicy, now time.Time) ([]retentionResult, error)
+}
+
+// openStore opens the store the flags point at, a dry run keeps everything
+// in memory and never connects to the database
+func openStore(ctx context.Context) (Store, error) {
+	if *dryRun {
+		return newMemoryStore(), nil
+	}
+
+	dbConn, err := getDBConnection(*dbDriver, *dbHost, *dbPort, *dbUser, *dbPassword, *dbName)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	err = migrate(ctx, dbConn)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	return newSQLStore(dbConn), nil
+}
+
+// openDB opens the database of the commands querying it directly instead of
+// through a Store, it is migrated so the ingester tables they join exist,
+// there is no database to query in a dry run so they refuse -dry-run, the
+// caller closes it with closeDB
+func openDB(ctx context.Context, command string) (*gorm.DB, error) {
+	if *dryRun {
+		return nil, errors.New(command + " queries the database, it can't run with -dry-run")
+	}
+
+	dbConn, err := getDBConnection(*dbDriver, *dbHost, *dbPort, *dbUser, *dbPassword, *dbName)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	err = migrate(ctx, dbConn)
+	if err != nil {
+		closeDB(dbConn)
+		return nil, errors.Wrap(err)
+	}
+
+	return dbConn, nil
+}
+
+// closeDB closes a database opened with openDB
+func closeDB(dbConn *gorm.DB) {
+	err := newSQLStore(dbConn).Close()
+	if err != nil {
+		slog.Warn("unable to close the database", "error", err)
+	}
+}
+
+// sqlStore keeps the catalog in the tables of tcg-market-watch-api, on
+// postgres or on sqlite for local development
+type sqlStore struct {
+	db *gorm.DB
+}
+
+func newSQLStore(dbConn *gorm.DB) *sqlStore {
+	return &sqlStore{db: dbConn}
+}
+
+// Close releases the connections of the store
+func (s *sqlStore) Close() error {
+	sqlDB, err := s.db.DB()
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	err = sqlDB.Close()
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
+
+func (s *sqlStore) Transaction(ctx context.Context, fn func(s Store) error) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// already inside a transaction, don't wrap each batch in a savepoint
+		tx = tx.Session(&gorm.Session{SkipDefaultTransaction: true})
+		return fn(newSQLStore(tx))
+	})
+}
+
+func (s *sqlStore) Truncate(ctx context.Context) error {
+	return dropData(ctx, s.db)
+}
+
+func (s *sqlStore) UpsertCategories(ctx context.Context, categories []*tcgplayer.Category) error {
+	_, err := syncCategories(ctx, s.db, categories)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
+
+func (s *sqlStore) UpsertGroups(ctx context.Context, groups []*tcgplayer.Group) ([]*tcgplayer.Group, error) {
+	ids := []int{}
+	for _, g := range groups {
+		ids = append(ids, g.ID)
+	}
+
+	knownGroupIDs := []int{}
+	err := s.db.WithContext(ctx).Model(&store.Group{}).Where("tcgplayer_id IN ?", ids).
+		Pluck("tcgplayer_id", &knownGroupIDs).Error
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	_, err = syncGroups(ctx, s.db, groups)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	added := []*tcgplayer.Group{}
+	for _, g := range groups {
+		if !containsID(knownGroupIDs, g.ID) {
+			added = append(added, g)
+		}
+	}
+
+	return added, nil
+}
+
+func (s *sqlStore) UpsertRarities(ctx context.Context, rarities []*tcgplayer.Rarity) error {
+	_, err := syncRarities(ctx, s.db, rarities)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
+
+func (s *sqlStore) UpsertPrintings(ctx context.Context, printings []*tcgplayer.Printing) error {
+	_, err := syncPrintings(ctx, s.db, printings)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
+
+func (s *sqlStore) UpsertConditions(ctx context.Context, conditions []*tcgplayer.Condition) error {
+	_, err := syncConditions(ctx, s.db, conditions)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
+
+func (s *sqlStore) UpsertLanguages(ctx context.Context, languages []*tcgplayer.Language) error {
+	_, err := syncLanguages(ctx, s.db, languages)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
+
+func (s *sqlStore) UpsertProducts(ctx context.Context, groupIDs []int,
+	products []*tcgplayer.Product) ([]*tcgplayer.Product, error) {
+	ids := []int{}
+	for _, prod := range products {
+		ids = append(ids, prod.ID)
+	}
+
+	knownProductIDs, err := s.KnownProductIDs(ctx, ids)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	added := []*tcgplayer.Product{}
+	for _, prod := range products {
+		if !containsID(knownProductIDs, prod.ID) {
+			added = append(added, prod)
+		}
+	}
+
+	err = deleteProducts(ctx, s.db, groupIDs, ids)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	if len(products) == 0 {
+		return added, nil
+	}
+
+	err = insertProducts(ctx, s.db, products)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	return added, nil
+}
+
+func (s *sqlStore) KnownProductIDs(ctx context.Context, ids []int) ([]int, error) {
+	known := []int{}
+	err := s.db.WithContext(ctx).Model(&store.Product{}).Where("tcgplayer_id IN ?", ids).
+		Pluck("tcgplayer_id", &known).Error
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	return known, nil
+}
+
+func (s *sqlStore) SyncedGroups(ctx context.Context, ids []int) (map[int]string, error) {
+	synced := []groupSync{}
+	err := s.db.WithContext(ctx).Where("tcgplayer_id IN ?", ids).Find(&synced).Error
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	publishedOn := map[int]string{}
+	for _, g := range synced {
+		publishedOn[g.TCGPlayerID] = g.PublishedOn
+	}
+
+	return publishedOn, nil
+}
+
+func (s *sqlStore) MarkGroupsSynced(ctx context.Context, groups []*tcgplayer.Group) error {
+	return markGroupsSynced(ctx, s.db, groups)
+}
+
+func (s *sqlStore) ListSKUIDs(ctx context.Context) ([]int, error) {
+	ids := []int{}
+	err := s.db.WithContext(ctx).Model(&store.SKU{}).Pluck("tcgplayer_id", &ids).Error
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	return ids, nil
+}
+
+func (s *sqlStore) WatchedSKUIDs(ctx context.Context) ([]int, error) {
+	ids := []int{}
+	err := watchedSKUIDs(s.db.WithContext(ctx)).Order("skus.tcgplayer_id").Pluck("skus.tcgplayer_id", &ids).Error
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	return ids, nil
+}
+
+func (s *sqlStore) InsertPrices(ctx context.Context, prices []skuPrice) error {
+	if len(prices) == 0 {
+		return nil
+	}
+
+	if s.db.Dialector.Name() == dbDriverPostgres {
+		copied, err := copyPrices(ctx, s.db, prices)
+		if err != nil {
+			return errors.Wrap(err)
+		}
+		if copied {
+			return nil
+		}
+	}
+
+	err := s.db.WithContext(ctx).CreateInBatches(&prices, 1000).Error
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
+
+func (s *sqlStore) PricedSKUIDs(ctx context.Context, ingestedAt time.Time, skuIDs []int) ([]int, error) {
+	priced := []int{}
+	err := s.db.WithContext(ctx).Model(&skuPrice{}).Where("ingested_at = ? AND sku_id IN ?", ingestedAt, skuIDs).
+		Distinct().Pluck("sku_id", &priced).Error
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	return priced, nil
+}
+
+func (s *sqlStore) LatestPrices(ctx context.Context) ([]latestPrice, error) {
+	return latestPrices(ctx, s.db)
+}
+
+func (s *sqlStore) WriteAggregates(ctx context.Context, products []*productPrice, groups []*groupValue) error {
+	return writeAggregates(ctx, s.db, products, groups)
+}
+
+func (s *sqlStore) UpsertExchangeRates(ctx context.Context, rates []*exchangeRate) error {
+	return upsertExchangeRates(ctx, s.db, rates)
+}
+
+func (s *sqlStore) RecordRun(ctx context.Context, run *ingestRun) error {
+	return recordRun(ctx, s.db, run)
+}
+
+func (s *sqlStore) PriceStats(ctx context.Context, since time.Time) (map[int]priceStats, error) {
+	rows := []struct {
+		SKUID          int `gorm:"column:sku_id"`
+		Samples        int
+		Mean           float64
+		MeanSquare     float64
+		LastIngestedAt scannedTime
+	}{}
+	err := s.db.WithContext(ctx).Model(&store.SKUPrice{}).
+		Select("sku_id, count(*) AS samples, avg(price) AS mean, avg(price * price) AS mean_square, "+
+			"max(ingested_at) AS last_ingested_at").
+		Where("ingested_at >= ?", since).Group("sku_id").Scan(&rows).Error
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	stats := make(map[int]priceStats, len(rows))
+	for _, r := range rows {
+		stats[r.SKUID] = priceStats{
+			SKUID:          r.SKUID,
+			Samples:        r.Samples,
+			Mean:           r.Mean,
+			MeanSquare:     r.MeanSquare,
+			LastIngestedAt: r.LastIngestedAt.Time,
+		}
+	}
+
+	return stats, nil
+}
+
+// TrimPrices drops the partitions of a partitioned sku_prices that no rule
+// keeps anymore and deletes the expired rows of every rule, without rules a
+// partitioned sku_prices is only trimmed a partition at a time
+func (s *sqlStore) TrimPrices(ctx context.Context, policy *retentionPolicy,
+	now time.Time) ([]retentionResult, error) {
+	partitioned, err := pricesPartitioned(ctx, s.db)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	results := []retentionResult{}
+	if partitioned {
+		dropped, err := dropExpiredPartitions(ctx, s.db, cutoff(now, policy.longestDays()),
+			policy.keptForever(s.db))
+		if err != nil {
+			return nil, errors.Wrap(err)
+		}
+
+		if len(policy.Rules) == 0 {
+			return []retentionResult{{Rule: defaultRetentionRule, Trimmed: dropped}}, nil
+		}
+		results = append(results, retentionResult{Rule: retentionPartitions, Trimmed: dropped})
+	}
+
+	trimmed, err := trimPricesByPolicy(ctx, s.db, policy, now)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	return append(results, trimmed...), nil
+}
+
+// deleteProducts removes the products of the given groups and the products
+// with the given ids, and their skus, so fresh ones can be inserted in their
+// place
+func deleteProducts(ctx context.Context, dbConn *gorm.DB, groupIDs []int, productIDs []int) error {
+	groups := dbConn.Model(&store.Group{}).Select("id").Where("tcgplayer_id IN ?", groupIDs)
+	products := dbConn.Model(&store.Product{}).Select("id").
+		Where("group_id IN (?) OR tcgplayer_id IN ?", groups, productIDs)
+	err := dbConn.WithContext(ctx).Where("product_id IN (?)", products).Delete(&store.SKU{}).Error
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	err = dbConn.WithContext(ctx).Where("group_id IN (?) OR tcgplayer_id IN ?", groups, productIDs).
+		Delete(&store.Product{}).Error
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
+
+// insertProducts adds products and their skus to the catalog, their groups
+// and the rarities, printings, conditions and languages they use are
+// expected to be stored already
+func insertProducts(ctx context.Context, dbConn *gorm.DB, products []*tcgplayer.Product) error {
+	groupIDs := []int{}
+	for _, p := range products {
+		if !containsID(groupIDs, p.GroupID) {
+			groupIDs = append(groupIDs, p.GroupID)
+		}
+	}
+
+	groups := []*store.Group{}
+	err := dbConn.WithContext(ctx).Where("tcgplayer_id IN ?", groupIDs).Find(&groups).Error
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	rarities := []*store.Rarity{}
+	err = dbConn.WithContext(ctx).Find(&rarities).Error
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	createdProducts, err := syncProducts(ctx, dbConn, groups, rarities, products)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	languages := []*store.Language{}
+	err = dbConn.WithContext(ctx).Find(&languages).Error
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	conditions := []*store.Condition{}
+	err = dbConn.WithContext(ctx).Find(&conditions).Error
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	printings := []*store.Printing{}
+	err = dbConn.WithContext(ctx).Find(&printings).Error
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	err = syncSKUs(ctx, dbConn, languages, conditions, printings, createdProducts, products)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	return nil
+}
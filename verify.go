@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"gorm.io/gorm"
+
+	errors "github.com/AustinMCrane/errorutil"
+	"github.com/AustinMCrane/tcg-market-watch-api/pkg/store"
+)
+
+const (
+	commandVerify = "verify"
+
+	checkUnpricedSKUs = "unpriced_skus"
+)
+
+// integrityCheck counts the rows breaking one rule of the catalog
+type integrityCheck struct {
+	name string
+	// warn checks are reported but don't fail verification
+	warn  bool
+	query func(dbConn *gorm.DB) *gorm.DB
+}
+
+// checkResult is how many rows broke a check
+type checkResult struct {
+	name  string
+	warn  bool
+	count int64
+}
+
+// duplicateTCGPlayerIDs counts the tcgplayer ids stored more than once in
+// the table of model
+func duplicateTCGPlayerIDs(model interface{}) func(dbConn *gorm.DB) *gorm.DB {
+	return func(dbConn *gorm.DB) *gorm.DB {
+		duplicates := dbConn.Model(model).Select("tcgplayer_id").
+			Group("tcgplayer_id").Having("count(*) > 1")
+		return dbConn.Table("(?) AS duplicates", duplicates)
+	}
+}
+
+var integrityChecks = []integrityCheck{
+	{
+		name: "orphan_skus",
+		query: func(dbConn *gorm.DB) *gorm.DB {
+			return dbConn.Model(&store.SKU{}).
+				Where("product_id NOT IN (?)", dbConn.Model(&store.Product{}).Select("id"))
+		},
+	},
+	{
+		name: "products_without_group",
+		query: func(dbConn *gorm.DB) *gorm.DB {
+			return dbConn.Model(&store.Product{}).
+				Where("group_id NOT IN (?)", dbConn.Model(&store.Group{}).Select("id"))
+		},
+	},
+	{name: "duplicate_category_ids", query: duplicateTCGPlayerIDs(&store.Category{})},
+	{name: "duplicate_group_ids", query: duplicateTCGPlayerIDs(&store.Group{})},
+	{name: "duplicate_product_ids", query: duplicateTCGPlayerIDs(&store.Product{})},
+	{name: "duplicate_sku_ids", query: duplicateTCGPlayerIDs(&store.SKU{})},
+	{name: "duplicate_rarity_ids", query: duplicateTCGPlayerIDs(&store.Rarity{})},
+	{name: "duplicate_printing_ids", query: duplicateTCGPlayerIDs(&store.Printing{})},
+	{name: "duplicate_condition_ids", query: duplicateTCGPlayerIDs(&store.Condition{})},
+	{name: "duplicate_language_ids", query: duplicateTCGPlayerIDs(&store.Language{})},
+	{
+		name: "duplicate_details",
+		query: func(dbConn *gorm.DB) *gorm.DB {
+			duplicates := dbConn.Model(&store.Detail{}).Select("name").
+				Group("name").Having("count(*) > 1")
+			return dbConn.Table("(?) AS duplicates", duplicates)
+		},
+	},
+	{
+		name: "unreferenced_details",
+		query: func(dbConn *gorm.DB) *gorm.DB {
+			return dbConn.Model(&store.Detail{}).
+				Where("id NOT IN (?)", dbConn.Model(&store.Product{}).Select("detail_id"))
+		},
+	},
+	{
+		// skus that were never priced never show up in the api, run verify
+		// with -warn-unpriced right after a catalog sync when the new skus
+		// haven't been through a price run yet
+		name: checkUnpricedSKUs,
+		query: func(dbConn *gorm.DB) *gorm.DB {
+			return dbConn.Model(&store.SKU{}).
+				Where("tcgplayer_id NOT IN (?)", dbConn.Model(&store.SKUPrice{}).Distinct("sku_id"))
+		},
+	},
+}
+
+// ExecVerify is the entry point of the verify command, it prints a report of
+// the integrity checks and fails when any of them found broken rows
+func ExecVerify(ctx context.Context, args []string) error {
+	fs := newCommandFlagSet(commandVerify)
+	warnUnpriced := fs.Bool("warn-unpriced", false, "report skus that were never priced as a warning "+
+		"instead of failing")
+	err := fs.Parse(args)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	dbConn, err := openDB(ctx, commandVerify)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+	defer closeDB(dbConn)
+
+	checks := integrityChecks
+	if *warnUnpriced {
+		checks = warnOnly(checks, checkUnpricedSKUs)
+	}
+
+	results, err := verifyCatalog(ctx, dbConn, checks)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	failed := writeReport(os.Stdout, results)
+	if failed > 0 {
+		return errors.New(fmt.Sprintf("%d integrity checks failed", failed))
+	}
+
+	return nil
+}
+
+// warnOnly returns the checks with the named ones turned into warnings
+func warnOnly(checks []integrityCheck, names ...string) []integrityCheck {
+	warned := make([]integrityCheck, 0, len(checks))
+	for _, c := range checks {
+		for _, n := range names {
+			if c.name == n {
+				c.warn = true
+			}
+		}
+		warned = append(warned, c)
+	}
+
+	return warned
+}
+
+// verifyCatalog runs every check and returns how many rows broke each one
+func verifyCatalog(ctx context.Context, dbConn *gorm.DB, checks []integrityCheck) ([]checkResult, error) {
+	results := []checkResult{}
+	for _, c := range checks {
+		var count int64
+		err := c.query(dbConn.WithContext(ctx)).Count(&count).Error
+		if err != nil {
+			return nil, errors.Wrap(err)
+		}
+
+		results = append(results, checkResult{name: c.name, warn: c.warn, count: count})
+	}
+
+	return results, nil
+}
+
+// writeReport prints a line per check and returns how many checks failed
+func writeReport(w io.Writer, results []checkResult) int {
+	failed := 0
+	for _, r := range results {
+		status := "ok"
+		if r.count > 0 && r.warn {
+			status = "WARN"
+		} else if r.count > 0 {
+			status = "FAIL"
+			failed++
+		}
+
+		fmt.Fprintf(w, "%-4s  %-24s %d\n", status, r.name, r.count)
+	}
+
+	return failed
+}
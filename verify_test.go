@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyCatalog(t *testing.T) {
+	ctx := context.Background()
+	dbConn, mock := GetMockDB(t)
+
+	counts := map[string]int64{
+		"orphan_skus":          2,
+		"duplicate_details":    1,
+		"unpriced_skus":        5,
+		"duplicate_sku_ids":    0,
+		"unreferenced_details": 0,
+	}
+	mock.ExpectQuery(`SELECT count\(\*\) FROM \"skus\" WHERE product_id NOT IN \(SELECT \"id\" FROM \"products\"\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(counts["orphan_skus"]))
+	mock.ExpectQuery(`SELECT count\(\*\) FROM \"products\" WHERE group_id NOT IN \(SELECT \"id\" FROM \"groups\"\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	for _, table := range []string{"categories", "groups", "products", "skus", "rarities", "printings",
+		"conditions", "languages"} {
+		mock.ExpectQuery(`SELECT count\(\*\) FROM \(SELECT \"tcgplayer_id\" FROM \"` + table +
+			`\" GROUP BY \"tcgplayer_id\" HAVING count\(\*\) > 1\) AS duplicates`).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	}
+	mock.ExpectQuery(`SELECT count\(\*\) FROM \(SELECT \"name\" FROM \"details\" GROUP BY \"name\" HAVING count\(\*\) > 1\) AS duplicates`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(counts["duplicate_details"]))
+	mock.ExpectQuery(`SELECT count\(\*\) FROM \"details\" WHERE id NOT IN \(SELECT \"detail_id\" FROM \"products\"\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery(`SELECT count\(\*\) FROM \"skus\" WHERE tcgplayer_id NOT IN \(SELECT DISTINCT \"?sku_id\"? FROM \"sku_prices\"\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(counts["unpriced_skus"]))
+
+	results, err := verifyCatalog(ctx, dbConn, integrityChecks)
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+	require.Len(t, results, len(integrityChecks))
+	for _, r := range results {
+		require.Equal(t, counts[r.name], r.count, r.name)
+	}
+
+	out := &bytes.Buffer{}
+	failed := writeReport(out, results)
+	require.Equal(t, 3, failed)
+	require.Contains(t, out.String(), "FAIL  orphan_skus              2\n")
+	require.Contains(t, out.String(), "FAIL  unpriced_skus            5\n")
+	require.Contains(t, out.String(), "ok    products_without_group   0\n")
+}
+
+func TestWarnOnly(t *testing.T) {
+	checks := warnOnly(integrityChecks, checkUnpricedSKUs)
+	require.Len(t, checks, len(integrityChecks))
+
+	// unpriced skus are only a warning with -warn-unpriced
+	results := []checkResult{}
+	for _, c := range checks {
+		results = append(results, checkResult{name: c.name, warn: c.warn, count: 1})
+	}
+	require.Equal(t, len(integrityChecks)-1, writeReport(&bytes.Buffer{}, results))
+
+	for _, c := range integrityChecks {
+		require.False(t, c.warn, c.name)
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	errors "github.com/AustinMCrane/errorutil"
+	"github.com/AustinMCrane/tcg-market-watch-api/pkg/store"
+	"github.com/AustinMCrane/tcgplayer"
+)
+
+const (
+	commandWatch = "watch"
+
+	watchKindSKU     = "sku"
+	watchKindProduct = "product"
+	watchKindGroup   = "group"
+	watchKindRarity  = "rarity"
+)
+
+// watchEntry is an entry of the watchlist, skus and groups are watched by
+// tcgplayer id, products and rarities by name so skus added to them later are
+// watched too
+type watchEntry struct {
+	ID          int
+	Kind        string `gorm:"uniqueIndex:idx_ingest_watchlist_entry"`
+	TCGPlayerID int    `gorm:"column:tcgplayer_id;uniqueIndex:idx_ingest_watchlist_entry"`
+	Name        string `gorm:"uniqueIndex:idx_ingest_watchlist_entry"`
+	CreatedAt   time.Time
+}
+
+func (watchEntry) TableName() string {
+	return "ingest_watchlist"
+}
+
+// Value is the tcgplayer id or the name the entry watches
+func (e watchEntry) Value() string {
+	if e.Kind == watchKindSKU || e.Kind == watchKindGroup {
+		return strconv.Itoa(e.TCGPlayerID)
+	}
+
+	return e.Name
+}
+
+// parseWatchEntries builds an entry of the given kind for every value
+func parseWatchEntries(kind string, values []string) ([]watchEntry, error) {
+	if len(values) == 0 {
+		return nil, errors.New("nothing to watch, usage: watch add|remove sku|product|group|rarity <value>...")
+	}
+
+	entries := []watchEntry{}
+	for _, v := range values {
+		switch kind {
+		case watchKindSKU, watchKindGroup:
+			id, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("%s must be a tcgplayer id: %s", kind, v))
+			}
+			entries = append(entries, watchEntry{Kind: kind, TCGPlayerID: id})
+		case watchKindProduct, watchKindRarity:
+			entries = append(entries, watchEntry{Kind: kind, Name: v})
+		default:
+			return nil, errors.New("unknown watch kind: " + kind)
+		}
+	}
+
+	return entries, nil
+}
+
+// ExecWatch is the entry point of the watch command, it adds, removes or
+// lists the watchlist entries whose skus the watchlist price mode refreshes
+func ExecWatch(ctx context.Context, args []string) error {
+	fs := newCommandFlagSet(commandWatch)
+	err := fs.Parse(args)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+
+	dbConn, err := openDB(ctx, commandWatch)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+	defer closeDB(dbConn)
+
+	switch fs.Arg(0) {
+	case "add", "remove":
+		values := []string{}
+		if fs.NArg() > 2 {
+			values = fs.Args()[2:]
+		}
+
+		entries, err := parseWatchEntries(fs.Arg(1), values)
+		if err != nil {
+			return errors.Wrap(err)
+		}
+
+		if fs.Arg(0) == "add" {
+			added, err := addWatchEntries(ctx, dbConn, entries)
+			if err != nil {
+				return errors.Wrap(err)
+			}
+			fmt.Fprintf(os.Stdout, "entries added: %d\n", added)
+		} else {
+			removed, err := removeWatchEntries(ctx, dbConn, entries)
+			if err != nil {
+				return errors.Wrap(err)
+			}
+			fmt.Fprintf(os.Stdout, "entries removed: %d\n", removed)
+		}
+	case "list":
+		entries, err := listWatchEntries(ctx, dbConn)
+		if err != nil {
+			return errors.Wrap(err)
+		}
+
+		skus, err := newSQLStore(dbConn).WatchedSKUIDs(ctx)
+		if err != nil {
+			return errors.Wrap(err)
+		}
+
+		writeWatchlist(os.Stdout, entries, len(skus))
+	default:
+		return errors.New("usage: watch add|remove|list")
+	}
+
+	return nil
+}
+
+// addWatchEntries adds the entries that aren't on the watchlist yet and
+// returns how many were added
+func addWatchEntries(ctx context.Context, dbConn *gorm.DB, entries []watchEntry) (int64, error) {
+	result := dbConn.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&entries)
+	if result.Error != nil {
+		return 0, errors.Wrap(result.Error)
+	}
+
+	return result.RowsAffected, nil
+}
+
+// removeWatchEntries removes the entries from the watchlist and returns how
+// many were on it
+func removeWatchEntries(ctx context.Context, dbConn *gorm.DB, entries []watchEntry) (int64, error) {
+	var removed int64
+	for _, e := range entries {
+		result := dbConn.WithContext(ctx).Where("kind = ? AND tcgplayer_id = ? AND name = ?",
+			e.Kind, e.TCGPlayerID, e.Name).Delete(&watchEntry{})
+		if result.Error != nil {
+			return 0, errors.Wrap(result.Error)
+		}
+		removed += result.RowsAffected
+	}
+
+	return removed, nil
+}
+
+func listWatchEntries(ctx context.Context, dbConn *gorm.DB) ([]watchEntry, error) {
+	entries := []watchEntry{}
+	err := dbConn.WithContext(ctx).Order("kind, tcgplayer_id, name").Find(&entries).Error
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	return entries, nil
+}
+
+// writeWatchlist prints a line per entry followed by how many skus they
+// watch together
+func writeWatchlist(w io.Writer, entries []watchEntry, skus int) {
+	for _, e := range entries {
+		fmt.Fprintf(w, "%-8s %s\n", e.Kind, e.Value())
+	}
+
+	fmt.Fprintf(w, "watched skus: %d\n", skus)
+}
+
+// watchedSKUIDs selects the tcgplayer ids of the skus the watchlist matches
+func watchedSKUIDs(dbConn *gorm.DB) *gorm.DB {
+	entries := func(kind, column string) *gorm.DB {
+		return dbConn.Model(&watchEntry{}).Select(column).Where("kind = ?", kind)
+	}
+
+	return dbConn.Model(&store.SKU{}).Select("skus.tcgplayer_id").
+		Joins("JOIN products ON products.id = skus.product_id").
+		Joins("LEFT JOIN groups ON groups.id = products.group_id").
+		Joins("LEFT JOIN details ON details.id = products.detail_id").
+		Joins("LEFT JOIN rarities ON rarities.id = products.rarity_id").
+		Where("skus.tcgplayer_id IN (?)", entries(watchKindSKU, "tcgplayer_id")).
+		Or("groups.tcgplayer_id IN (?)", entries(watchKindGroup, "tcgplayer_id")).
+		Or("details.name IN (?)", entries(watchKindProduct, "name")).
+		Or("rarities.name IN (?)", entries(watchKindRarity, "name"))
+}
+
+// watches reports whether the entry matches the sku of the product, rarity
+// is the name the product's rarity is stored under
+func (e watchEntry) watches(p *tcgplayer.Product, rarity string, skuID int) bool {
+	switch e.Kind {
+	case watchKindSKU:
+		return e.TCGPlayerID == skuID
+	case watchKindGroup:
+		return e.TCGPlayerID == p.GroupID
+	case watchKindProduct:
+		return e.Name == p.CleanName
+	case watchKindRarity:
+		return e.Name == rarity
+	default:
+		return false
+	}
+}
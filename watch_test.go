@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AustinMCrane/tcgplayer"
+	gomock "github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseWatchEntries(t *testing.T) {
+	entries, err := parseWatchEntries(watchKindSKU, []string{"10", "20"})
+	require.NoError(t, err)
+	require.Equal(t, []watchEntry{
+		{Kind: watchKindSKU, TCGPlayerID: 10},
+		{Kind: watchKindSKU, TCGPlayerID: 20},
+	}, entries)
+
+	entries, err = parseWatchEntries(watchKindRarity, []string{"Secret Rare"})
+	require.NoError(t, err)
+	require.Equal(t, []watchEntry{{Kind: watchKindRarity, Name: "Secret Rare"}}, entries)
+
+	_, err = parseWatchEntries(watchKindGroup, []string{"test-1"})
+	require.Error(t, err)
+	_, err = parseWatchEntries("card", []string{"10"})
+	require.Error(t, err)
+	_, err = parseWatchEntries(watchKindSKU, nil)
+	require.Error(t, err)
+}
+
+func TestSQLiteWatchlist(t *testing.T) {
+	ctx := context.Background()
+
+	dbConn, err := getDBConnection(dbDriverSQLite, "", "", "", "", filepath.Join(t.TempDir(), "dev.db"))
+	require.NoError(t, err)
+	require.NoError(t, migrate(ctx, dbConn))
+	s := newSQLStore(dbConn)
+	writeRetentionFixture(t, s, time.Now())
+
+	added, err := addWatchEntries(ctx, dbConn, []watchEntry{
+		{Kind: watchKindSKU, TCGPlayerID: 20},
+		{Kind: watchKindGroup, TCGPlayerID: 2},
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(2), added)
+
+	// entries already on the watchlist are skipped
+	added, err = addWatchEntries(ctx, dbConn, []watchEntry{{Kind: watchKindSKU, TCGPlayerID: 20}})
+	require.NoError(t, err)
+	require.Zero(t, added)
+
+	skus, err := s.WatchedSKUIDs(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []int{20, 30}, skus)
+
+	_, err = addWatchEntries(ctx, dbConn, []watchEntry{{Kind: watchKindProduct, Name: "test"}})
+	require.NoError(t, err)
+	skus, err = s.WatchedSKUIDs(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []int{10, 20, 30}, skus)
+
+	entries, err := listWatchEntries(ctx, dbConn)
+	require.NoError(t, err)
+	out := bytes.Buffer{}
+	writeWatchlist(&out, entries, len(skus))
+	require.Equal(t, "group    2\nproduct  test\nsku      20\nwatched skus: 3\n", out.String())
+
+	removed, err := removeWatchEntries(ctx, dbConn, []watchEntry{
+		{Kind: watchKindProduct, Name: "test"},
+		{Kind: watchKindGroup, TCGPlayerID: 2},
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(2), removed)
+
+	skus, err = s.WatchedSKUIDs(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []int{20}, skus)
+
+	results, err := s.TrimPrices(ctx, &retentionPolicy{
+		DefaultDays: 30,
+		Rules:       []retentionRule{{Name: "watchlist", Watchlisted: true, Days: 365}},
+	}, time.Now())
+	require.NoError(t, err)
+	require.Equal(t, []retentionResult{
+		{Rule: "watchlist"},
+		{Rule: defaultRetentionRule, Trimmed: 3},
+	}, results)
+}
+
+func TestMemoryStore_WatchedSKUIDs(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	s := newMemoryStore()
+	writeRetentionFixture(t, s, now)
+	s.watchlist = []watchEntry{
+		{Kind: watchKindRarity, Name: "Secret Rare"},
+		{Kind: watchKindSKU, TCGPlayerID: 20},
+	}
+
+	skus, err := s.WatchedSKUIDs(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []int{10, 20}, skus)
+
+	// watchlisted skus keep every price, the old price of sku 30 is trimmed
+	results, err := s.TrimPrices(ctx, &retentionPolicy{
+		DefaultDays: 30,
+		Rules:       []retentionRule{{Name: "watchlist", Watchlisted: true}},
+	}, now)
+	require.NoError(t, err)
+	require.Equal(t, []retentionResult{
+		{Rule: "watchlist"},
+		{Rule: defaultRetentionRule, Trimmed: 1},
+	}, results)
+}
+
+func TestWatchedSKUIDs_RarityMatchesStoredRarity(t *testing.T) {
+	ctx := context.Background()
+
+	dbConn, err := getDBConnection(dbDriverSQLite, "", "", "", "", filepath.Join(t.TempDir(), "dev.db"))
+	require.NoError(t, err)
+	require.NoError(t, migrate(ctx, dbConn))
+	entries := []watchEntry{
+		{Kind: watchKindRarity, Name: rarityNameCommon},
+		{Kind: watchKindRarity, Name: defaultRarityName},
+	}
+	_, err = addWatchEntries(ctx, dbConn, entries)
+	require.NoError(t, err)
+
+	memory := newMemoryStore()
+	memory.watchlist = entries
+
+	// the commons are stored as rarityNameCommon and a rarity that isn't
+	// stored as the default one, both stores watch the same skus
+	for _, s := range []Store{memory, newSQLStore(dbConn)} {
+		writeRetentionFixture(t, s, time.Now())
+		_, err := s.UpsertProducts(ctx, nil, []*tcgplayer.Product{{
+			ID: 4, GroupID: 2, CategoryID: tcgplayer.CategoryYugioh, CleanName: "unknown",
+			ExtendedData: []tcgplayer.ExtendedData{{Name: "Rarity", Value: "Starlight Rare"}},
+			SKUS: []tcgplayer.SKU{{SKUID: 40, ProductID: 4, PrintingID: 1, ConditionID: 1,
+				LanguageID: englishLanguageID}},
+		}})
+		require.NoError(t, err)
+
+		skus, err := s.WatchedSKUIDs(ctx)
+		require.NoError(t, err)
+		require.ElementsMatch(t, []int{20, 30, 40}, skus)
+	}
+}
+
+func TestIngestSKUPrices_Watchlist(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	client := NewMockTcgplayer(ctrl)
+
+	s := newMemoryStore()
+	writeRetentionFixture(t, s, time.Now())
+	s.watchlist = []watchEntry{{Kind: watchKindGroup, TCGPlayerID: 2}}
+
+	skus, err := s.WatchedSKUIDs(ctx)
+	require.NoError(t, err)
+
+	// only the watchlisted skus are fetched
+	client.EXPECT().GetSKUPrices(gomock.Any(), []int{30}).
+		Return([]*tcgplayer.SKUMarketPrice{{SKUID: 30, LowPrice: 12}}, nil)
+	require.NoError(t, ingestSKUPrices(ctx, s, client, nil, skus, 0, testFlushPolicy))
+	require.Len(t, s.prices, 8)
+	require.Equal(t, float32(12), s.prices[7].Price)
+}